@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // 辨别非事务的操作
@@ -44,6 +45,18 @@ func (db *DB) NewWriteBatch(options WriteBatchOptions) *WriteBatch {
 
 // Put 写入数据
 func (wb *WriteBatch) Put(key []byte, value []byte) error {
+	return wb.put(key, value, 0)
+}
+
+// PutWithTTL 写入带有过期时间的数据, 过期时间从调用时开始计算
+func (wb *WriteBatch) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	return wb.put(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+func (wb *WriteBatch) put(key []byte, value []byte, expire int64) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
@@ -51,9 +64,10 @@ func (wb *WriteBatch) Put(key []byte, value []byte) error {
 	defer wb.lock.Unlock()
 	// 构建 LogRecord
 	logRecord := &data.LogRecord{
-		Key:   key,
-		Type:  data.LogRecordNormal,
-		Value: value,
+		Key:    key,
+		Type:   data.LogRecordNormal,
+		Value:  value,
+		Expire: expire,
 	}
 	// 暂存到内存中
 	wb.pendingWrites[string(key)] = logRecord
@@ -100,62 +114,77 @@ func (wb *WriteBatch) Commit() error {
 	if uint(len(wb.pendingWrites)) > wb.options.MaxBatchSize {
 		return ErrExceedMaxBatchNum
 	}
-	// 加锁保证事务的串行化
-	wb.db.lock.Lock()
-	defer wb.db.lock.Unlock()
+	// 和其他并发的写入一起组提交, 一批数据的记录连续写入, 保证事务的串行化
+	pendingWrites := wb.pendingWrites
+	logRecords, keys := wb.db.transactionRecords(pendingWrites)
+	err := wb.db.commitWrite(logRecords, wb.options.SyncWrites, func(positions []*data.LogRecordPos) {
+		wb.db.applyTransaction(pendingWrites, keys, positions)
+	})
+	if err != nil {
+		return err
+	}
+
+	// 清空暂存区
+	wb.pendingWrites = make(map[string]*data.LogRecord)
+	return nil
+}
+
+// commitRecords 以事务的方式写入一批数据并更新内存索引
+// 每条记录的 key 都带上同一个事务序列号, 最后写一条 LogRecordFinished 标识事务完成,
+// 重启时只有读到了完成标识的事务才会被加载到内存索引中
+// 调用前必须持有 db.lock
+func (db *DB) commitRecords(pendingWrites map[string]*data.LogRecord, syncWrites bool) error {
+	logRecords, keys := db.transactionRecords(pendingWrites)
+	records, _, err := db.encodeRecords(logRecords)
+	if err != nil {
+		return err
+	}
+	// 所有的记录合并成一次写入
+	positions, err := db.writeRecords(records, syncWrites)
+	if err != nil {
+		return err
+	}
+	db.applyTransaction(pendingWrites, keys, positions)
+	return nil
+}
 
+// transactionRecords 获取新的事务序列号, 构造事务中的记录以及最后的完成标识
+// 返回的 keys 和记录一一对应, 不包括完成标识
+func (db *DB) transactionRecords(pendingWrites map[string]*data.LogRecord) ([]*data.LogRecord, []string) {
 	// 获取当前最新的事务序列号
-	seqNo := atomic.AddUint64(&wb.db.transactionSeq, 1)
-
-	// 开始写数据到数据文件中
-	positions := make(map[string]*data.LogRecordPos)
-	for _, logRecord := range wb.pendingWrites {
-		// 写入数据到数据文件中
-		pos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(logRecord.Key, seqNo),
-			Value: logRecord.Value,
-			Type:  logRecord.Type,
+	seqNo := atomic.AddUint64(&db.transactionSeq, 1)
+
+	logRecords := make([]*data.LogRecord, 0, len(pendingWrites)+1)
+	keys := make([]string, 0, len(pendingWrites))
+	for key, logRecord := range pendingWrites {
+		logRecords = append(logRecords, &data.LogRecord{
+			Key:    logRecordKeyWithSeq(logRecord.Key, seqNo),
+			Value:  logRecord.Value,
+			Type:   logRecord.Type,
+			Expire: logRecord.Expire,
 		})
-		if err != nil {
-			return err
-		}
-		positions[string(logRecord.Key)] = pos
+		keys = append(keys, key)
 	}
 	// 写一条标识数据完成的数据
-	finish := &data.LogRecord{
+	logRecords = append(logRecords, &data.LogRecord{
 		Key:  logRecordKeyWithSeq(txnFinkey, seqNo),
 		Type: data.LogRecordFinished,
-	}
-	if _, err := wb.db.appendLogRecord(finish); err != nil {
-		return err
-	}
-
-	// 根据配置决定是否进行持久化
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
-			return err
-		}
-	}
+	})
+	return logRecords, keys
+}
 
-	// 更新内存索引
-	for _, record := range wb.pendingWrites {
-		pos := positions[string(record.Key)]
-		var oldPos *data.LogRecordPos
+// applyTransaction 事务的记录写入之后更新内存索引, 调用前必须持有 db.lock
+func (db *DB) applyTransaction(pendingWrites map[string]*data.LogRecord, keys []string, positions []*data.LogRecordPos) {
+	for i, key := range keys {
+		record := pendingWrites[key]
 		// 判断数据类型是否为删除, 如果是删除则删除索引
 		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
+			db.putIndex(record.Key, positions[i])
 		}
 		if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
-		}
-		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size) // 删除数据这条记录的大小,也是需要记录的
+			db.deleteIndex(record.Key)
 		}
 	}
-
-	// 清空暂存区
-	wb.pendingWrites = make(map[string]*data.LogRecord)
-	return nil
 }
 
 // 将 SeqNum 转换为字节流
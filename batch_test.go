@@ -5,6 +5,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestDB_NewWriteBatch(t *testing.T) {
@@ -114,3 +115,42 @@ func TestWriteBatch_Commit(t *testing.T) {
 	err = db.Close()
 	//assert.Nil(t, err)
 }
+
+func TestWriteBatch_PutWithTTL(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-batch-ttl")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.PutWithTTL(util.GetRandomKey(1), util.GetRandomValue(10), -time.Second)
+	assert.Equal(t, ErrInvalidTTL, err)
+	err = wb.PutWithTTL(util.GetRandomKey(1), util.GetRandomValue(10), 100*time.Millisecond)
+	assert.Nil(t, err)
+	err = wb.Put(util.GetRandomKey(2), util.GetRandomValue(10))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	_, err = db.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+
+	time.Sleep(150 * time.Millisecond)
+	_, err = db.Get(util.GetRandomKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(util.GetRandomKey(2))
+	assert.Nil(t, err)
+
+	// 重启之后从事务记录中恢复的 key 同样过期
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	_, err = db2.Get(util.GetRandomKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(util.GetRandomKey(2))
+	assert.Nil(t, err)
+}
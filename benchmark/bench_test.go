@@ -8,6 +8,7 @@ import (
 	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 )
 
@@ -57,6 +58,127 @@ func Benchmark_Delete(b *testing.B) {
 	}
 }
 
+// Benchmark_PutSyncParallel 开启 SyncWrites 时并发写入, 组提交让多个写入共用一次持久化
+// 可以通过 -cpu 1,4,16 比较不同并发数下的吞吐量
+func Benchmark_PutSyncParallel(b *testing.B) {
+	options := GoKeeper.DefaultOptions
+	options.DirPath = filepath.Join(os.TempDir(), "goKeeperBenchSync")
+	options.SyncWrites = true
+	db, err := GoKeeper.Open(options)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(options.DirPath)
+	defer db.Close()
+
+	var seq atomic.Int64
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			err := db.Put(util.GetRandomKey(int(seq.Add(1))), util.GetRandomValue(128))
+			assert.Nil(b, err)
+		}
+	})
+}
+
+// readBenchKeys 并发读取的 benchmark 预先写入的 key 的数量
+const readBenchKeys = 100000
+
+// openReadBench 打开一个预先写入了 readBenchKeys 个 key 的数据库
+func openReadBench(b *testing.B, name string) *GoKeeper.DB {
+	options := GoKeeper.DefaultOptions
+	options.DirPath = filepath.Join(os.TempDir(), name)
+	_ = os.RemoveAll(options.DirPath)
+	db, err := GoKeeper.Open(options)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		_ = db.Close()
+		_ = os.RemoveAll(options.DirPath)
+	})
+	for i := 0; i < readBenchKeys; i++ {
+		if err := db.Put(util.GetRandomKey(i), util.GetRandomValue(128)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return db
+}
+
+// Benchmark_GetParallel 并发读取, 读取不持有 db.lock, 吞吐量随着 CPU 数量增加
+// 可以通过 -cpu 1,4,16 比较不同并发数下的吞吐量
+func Benchmark_GetParallel(b *testing.B) {
+	db := openReadBench(b, "goKeeperBenchGet")
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, err := db.Get(util.GetRandomKey(rand.IntN(readBenchKeys)))
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// Benchmark_GetParallelWithWrites 后台持续写入的同时并发读取, 读取不会被写入阻塞
+func Benchmark_GetParallelWithWrites(b *testing.B) {
+	db := openReadBench(b, "goKeeperBenchGetWrite")
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := db.Put(util.GetRandomKey(i%readBenchKeys), util.GetRandomValue(128)); err != nil {
+				panic(err)
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, err := db.Get(util.GetRandomKey(rand.IntN(readBenchKeys)))
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// Benchmark_FoldParallel 并发遍历所有的数据
+func Benchmark_FoldParallel(b *testing.B) {
+	db := openReadBench(b, "goKeeperBenchFold")
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			count := 0
+			err := db.Fold(func(key []byte, value []byte) bool {
+				count++
+				return count < 1000
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
 //func TestDB_LIST(t *testing.T) {
 //	//keys := DB.ListKeys()
 //	//for i := 0; i < 3; i++ {
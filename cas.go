@@ -0,0 +1,84 @@
+package GoKeeper
+
+import (
+	"bytes"
+	"time"
+)
+
+// CompareAndSwap 当 key 存在并且当前的值等于 expected 时, 把值替换为 value
+// 比较和写入在 db.lock 的保护下原子地完成, 返回是否执行了替换
+func (db *DB) CompareAndSwap(key []byte, expected []byte, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	current, exists, err := db.getLocked(key)
+	if err != nil {
+		return false, err
+	}
+	if !exists || !bytes.Equal(current, expected) {
+		return false, nil
+	}
+	if err = db.putLocked(key, value, 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutIfAbsent 只有当 key 不存在(或者已经过期)时才写入, 返回是否执行了写入
+func (db *DB) PutIfAbsent(key []byte, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	_, exists, err := db.getLocked(key)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+	if err = db.putLocked(key, value, 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteIfEquals 当 key 存在并且当前的值等于 expected 时删除 key, 返回是否执行了删除
+func (db *DB) DeleteIfEquals(key []byte, expected []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	current, exists, err := db.getLocked(key)
+	if err != nil {
+		return false, err
+	}
+	if !exists || !bytes.Equal(current, expected) {
+		return false, nil
+	}
+	if err = db.deleteLocked(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// getLocked 读取 key 当前的值, 已经过期的 key 视为不存在
+// 调用前必须持有 db.lock
+func (db *DB) getLocked(key []byte) ([]byte, bool, error) {
+	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
+		return nil, false, nil
+	}
+	value, err := db.getValueByPosition(logRecordPos)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
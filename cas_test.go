@@ -0,0 +1,133 @@
+package GoKeeper
+
+import (
+	"GoKeeper/util"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-cas")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1. key 不存在
+	ok, err := db.CompareAndSwap(util.GetRandomKey(1), nil, []byte("v1"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 2. 值不相等
+	assert.Nil(t, db.Put(util.GetRandomKey(1), []byte("v1")))
+	ok, err = db.CompareAndSwap(util.GetRandomKey(1), []byte("v0"), []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 3. 值相等时替换
+	ok, err = db.CompareAndSwap(util.GetRandomKey(1), []byte("v1"), []byte("v2"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err := db.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+
+	// 4. key 为空
+	_, err = db.CompareAndSwap(nil, nil, nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	// 5. 并发递增计数器, 每次 CAS 失败后重试
+	assert.Nil(t, db.Put([]byte("counter"), binary.BigEndian.AppendUint64(nil, 0)))
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				for {
+					current, err := db.Get([]byte("counter"))
+					assert.Nil(t, err)
+					next := binary.BigEndian.AppendUint64(nil, binary.BigEndian.Uint64(current)+1)
+					swapped, err := db.CompareAndSwap([]byte("counter"), current, next)
+					assert.Nil(t, err)
+					if swapped {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	val, err = db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(400), binary.BigEndian.Uint64(val))
+}
+
+func TestDB_PutIfAbsent(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-put-if-absent")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ok, err := db.PutIfAbsent(util.GetRandomKey(1), []byte("v1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = db.PutIfAbsent(util.GetRandomKey(1), []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	val, err := db.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+
+	// 过期的 key 视为不存在
+	assert.Nil(t, db.PutWithTTL(util.GetRandomKey(2), []byte("v1"), 50*time.Millisecond))
+	time.Sleep(100 * time.Millisecond)
+	ok, err = db.PutIfAbsent(util.GetRandomKey(2), []byte("v2"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err = db.Get(util.GetRandomKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+}
+
+func TestDB_DeleteIfEquals(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-delete-if-equals")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ok, err := db.DeleteIfEquals(util.GetRandomKey(1), []byte("v1"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	assert.Nil(t, db.Put(util.GetRandomKey(1), []byte("v1")))
+	ok, err = db.DeleteIfEquals(util.GetRandomKey(1), []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = db.DeleteIfEquals(util.GetRandomKey(1), []byte("v1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = db.Get(util.GetRandomKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 重启之后删除依然有效
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	_, err = db2.Get(util.GetRandomKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
@@ -0,0 +1,74 @@
+// gokeeper-fsck 离线检查 GoKeeper 数据目录是否完整, 以 JSON 格式输出检查报告
+// 指定 -repair 时把能够恢复的数据写入新的目录, 输出修复报告, 使用了合并操作符的数据目录需要调用 GoKeeper.Repair
+//
+// 用法:
+//
+//	gokeeper-fsck [-key 十六进制密钥] [-repair 输出目录] <数据目录>
+//
+// 退出码: 0 表示没有发现错误或者修复成功, 1 表示数据目录中有错误, 2 表示参数错误或者无法检查
+package main
+
+import (
+	"GoKeeper"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	key := flag.String("key", "", "hex encoded encryption key of the database")
+	repairDir := flag.String("repair", "", "write the salvaged data into this directory instead of verifying")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-key hex] [-repair outdir] <dir>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var options GoKeeper.VerifyOptions
+	if *key != "" {
+		encryptionKey, err := hex.DecodeString(*key)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid key:", err)
+			os.Exit(2)
+		}
+		options.EncryptionKey = encryptionKey
+	}
+
+	if *repairDir != "" {
+		repairOptions := GoKeeper.DefaultOptions
+		repairOptions.DirPath = *repairDir
+		repairOptions.EncryptionKey = options.EncryptionKey
+		report, err := GoKeeper.Repair(flag.Arg(0), repairOptions)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "repair failed:", err)
+			os.Exit(2)
+		}
+		writeReport(report)
+		return
+	}
+
+	report, err := GoKeeper.VerifyWithOptions(flag.Arg(0), options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "verify failed:", err)
+		os.Exit(2)
+	}
+	writeReport(report)
+	if !report.OK {
+		os.Exit(1)
+	}
+}
+
+func writeReport(report any) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, "write report failed:", err)
+		os.Exit(2)
+	}
+}
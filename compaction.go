@@ -0,0 +1,302 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"GoKeeper/util"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Compact 增量压缩, 只重写无效数据比例最高的 maxFiles 个旧数据文件, maxFiles <= 0 表示不限制
+// 和 Merge 不同, 每次只需要一个数据文件大小的剩余空间, 适合数据量很大的数据库分多次回收空间
+// 流程:
+//  1. 选出无效数据占比达到 MergeThreshold 的旧数据文件, 按照无效数据的大小从大到小排序
+//  2. 逐个文件将有效的记录重写到临时文件中, 记录的顺序保持不变
+//  3. 用临时文件原子地替换掉原来的数据文件, 同时更新内存索引
+func (db *DB) Compact(maxFiles int) error {
+	db.lock.Lock()
+	if db.isMerging {
+		db.lock.Unlock()
+		return ErrMergeIsRunning
+	}
+	fileIds := db.pickCompactFiles(maxFiles)
+	if len(fileIds) == 0 {
+		db.lock.Unlock()
+		return ErrMergeNotExceedThreshold
+	}
+	db.isMerging = true
+	db.lock.Unlock()
+
+	defer func() {
+		db.lock.Lock()
+		db.isMerging = false
+		db.lock.Unlock()
+	}()
+
+	// 每个文件单独加锁, 压缩的间隙中用户可以正常读写
+	for _, fid := range fileIds {
+		if err := db.compactFile(fid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pickCompactFiles 选出需要压缩的旧数据文件, 调用前必须持有 db.lock
+func (db *DB) pickCompactFiles(maxFiles int) []uint32 {
+	var files []*data.DataFile
+	for fid, dataFile := range db.olderFiles {
+		if dataFile.DeadSize <= 0 || dataFile.WriteOff <= 0 || !db.canCompactFile(fid) {
+			continue
+		}
+		if float32(dataFile.DeadSize)/float32(dataFile.WriteOff) < db.options.MergeThreshold {
+			continue
+		}
+		files = append(files, dataFile)
+	}
+	// 无效数据越多的文件越先压缩
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].DeadSize > files[j].DeadSize
+	})
+	if maxFiles > 0 && len(files) > maxFiles {
+		files = files[:maxFiles]
+	}
+
+	fileIds := make([]uint32, 0, len(files))
+	for _, dataFile := range files {
+		fileIds = append(fileIds, dataFile.FileID)
+	}
+	return fileIds
+}
+
+// canCompactFile 判断数据文件是否可以被重写, 调用前必须持有 db.lock
+// 被快照引用的文件以及订阅者还没有读完的文件中, 记录的位置不能发生变化
+// 已经关闭的订阅保存的序列号不会阻止压缩, 重写之后 Subscribe 会拒绝指向这个文件内部的序列号
+func (db *DB) canCompactFile(fid uint32) bool {
+	if _, ok := db.olderFiles[fid]; !ok || db.fileRefs[fid] > 0 {
+		return false
+	}
+	for _, sub := range db.subscriptions {
+		if sub.fid <= fid {
+			return false
+		}
+	}
+	return true
+}
+
+// compactFile 重写一个旧数据文件, 只保留有效的记录以及还需要的删除记录
+func (db *DB) compactFile(fid uint32) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	// 释放锁的间隙中文件可能被快照或者订阅引用了
+	if !db.canCompactFile(fid) {
+		return nil
+	}
+	dataFile := db.olderFiles[fid]
+
+	// 查看剩余空间是否可以容纳文件中有效的数据
+	availableDiskSize, err := util.AvailableDiskSize(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	if uint64(dataFile.LiveSize()) >= availableDiskSize {
+		return ErrDiskSpaceNotEnough
+	}
+
+	// 最早的数据文件之前没有数据, 删除记录可以直接丢弃
+	isOldest := true
+	for id := range db.olderFiles {
+		if id < fid {
+			isOldest = false
+			break
+		}
+	}
+
+	compactFileName := data.GetDataFileName(db.options.DirPath, fid) + data.CompactFileSuffix
+	if err = os.Remove(compactFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	compactFile, err := data.OpenCompactFile(db.options.DirPath, fid)
+	if err != nil {
+		return err
+	}
+	compactFile.Cipher = db.cipher
+	// 文件头中标记文件被重写过, 恢复订阅时不能再使用重写之前这个文件中的序列号
+	if err = compactFile.InitHeaderWithFlags(db.fingerprint, data.FileFlagCompacted); err != nil {
+		_ = compactFile.Close()
+		return err
+	}
+	defer func() {
+		_ = os.Remove(compactFileName)
+	}()
+
+	// pos 为 nil 表示从内存索引中删除 key
+	type indexUpdate struct {
+		key []byte
+		pos *data.LogRecordPos
+	}
+	var updates []indexUpdate
+	var deadSize, logicalSize int64
+	now := time.Now().UnixNano()
+	offset := dataFile.HeaderSize()
+	for {
+		record, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			// 损坏的记录不再重写
+			next, err := db.skipCorruptedRecord(dataFile, offset, size, err)
+			if err != nil {
+				_ = compactFile.Close()
+				return err
+			}
+			if next < 0 {
+				break
+			}
+			offset = next
+			continue
+		}
+		pos := &data.LogRecordPos{Fid: fid, Offset: offset, Size: uint32(size), Expire: record.Expire}
+		offset += size
+
+		realKey, _ := parseLogRecordKey(record.Key)
+		live, keep := false, false
+		switch record.Type {
+		case data.LogRecordFinished:
+			// 事务的其他记录可能在更早的文件中, 完成标记需要保留
+			keep = true
+		case data.LogRecordRangeDeleted:
+			keep = !isOldest
+		default:
+			indexPos := db.index.Get(realKey)
+			switch {
+			case indexPos != nil && indexPos.Fid == pos.Fid && indexPos.Offset == pos.Offset:
+				// 已经过期的数据和删除记录一样, 需要遮住更早的数据
+				live = !indexPos.IsExpired(now)
+				keep = live || !isOldest
+				if !live {
+					updates = append(updates, indexUpdate{key: realKey})
+				}
+			case indexPos == nil:
+				// key 已经被删除, 删除记录需要保留, 否则更早的文件中的数据会重新出现
+				keep = !isOldest && (record.Type == data.LogRecordDeleted || record.IsExpired(now))
+			case record.Type != data.LogRecordDeleted:
+				// 这条记录可能还在 key 最新的操作数链表中, 先把操作数链表合并成一条普通记录
+				if err := db.collapseOperands(realKey, indexPos); err != nil {
+					_ = compactFile.Close()
+					return err
+				}
+			}
+		}
+		if !keep {
+			continue
+		}
+
+		if live {
+			// 操作数合并成一条普通记录
+			if record.Type == data.LogRecordOperand {
+				value, err := db.readValue(db.getDataFile, pos)
+				if err != nil {
+					_ = compactFile.Close()
+					return err
+				}
+				record.Value, record.Type = value, data.LogRecordNormal
+			}
+			// 清除事务标记
+			record.Key = logRecordKeyWithSeq(realKey, nonTransactionKey)
+		}
+		// 按照当前配置的算法重新压缩, 使用当前的密钥重新加密
+		record.Compression = db.options.Compression
+		encodeRecord, n, err := db.cipher.EncodeLogRecord(record)
+		if err != nil {
+			_ = compactFile.Close()
+			return err
+		}
+		newPos := &data.LogRecordPos{Fid: fid, Offset: compactFile.WriteOff, Size: uint32(n), Expire: record.Expire}
+		if err := compactFile.Write(encodeRecord); err != nil {
+			_ = compactFile.Close()
+			return err
+		}
+		logicalSize += data.LogicalRecordSize(record)
+		if live {
+			updates = append(updates, indexUpdate{key: realKey, pos: newPos})
+		} else if record.Type != data.LogRecordFinished {
+			deadSize += int64(n)
+		}
+	}
+
+	if err = compactFile.Sync(); err != nil {
+		_ = compactFile.Close()
+		return err
+	}
+	if err = compactFile.Close(); err != nil {
+		return err
+	}
+
+	// hint 文件中的位置信息马上就会失效, 下次启动时需要从数据文件中加载索引
+	// merge 完成标识文件记录了被 merge 重写过的文件范围, 恢复订阅时需要用到, 保留
+	if err = os.Remove(filepath.Join(db.options.DirPath, data.HintFileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// 用临时文件替换原来的数据文件, rename 是原子的, 不会出现只替换了一半的情况
+	// 不加锁的读取可能还在使用原来的数据文件, 替换之后再关闭
+	fileName := data.GetDataFileName(db.options.DirPath, fid)
+	if err = os.Rename(compactFileName, fileName); err != nil {
+		// 替换失败, 继续使用原来的数据文件
+		_ = os.Remove(compactFileName)
+		return err
+	}
+	newFile, err := data.OpenDataFile(db.options.DirPath, fid, db.options.IOType)
+	if err != nil {
+		return err
+	}
+	newFile.Cipher = db.cipher
+	if newFile.WriteOff, err = newFile.IoManager.Size(); err != nil {
+		_ = newFile.Close()
+		return err
+	}
+	newFile.DeadSize = deadSize
+	newFile.LogicalSize = logicalSize
+
+	// 发布新的数据文件并更新内存索引, 这期间读取到的位置可能是错误的, 读取结束之后发现序列号变化会重试
+	// 原来的数据文件等到不再有读取使用旧的数据文件集合之后再关闭
+	db.beginRelocate()
+	db.olderFiles[fid] = newFile
+	db.publishFiles(dataFile)
+	for _, update := range updates {
+		if update.pos == nil {
+			db.index.Delete(update.key)
+		} else {
+			db.index.Put(update.key, update.pos)
+		}
+	}
+	db.endRelocate()
+	db.reclaimSize += deadSize - dataFile.DeadSize
+	if db.reclaimSize < 0 {
+		db.reclaimSize = 0
+	}
+	return nil
+}
+
+// collapseOperands 如果 key 当前是一条操作数记录, 将整个操作数链表合并成一条普通记录写入活跃文件
+// 合并之后链表中旧的记录都不会再被读取, 调用前必须持有 db.lock
+func (db *DB) collapseOperands(key []byte, pos *data.LogRecordPos) error {
+	logRecord, err := readLogRecord(db.getDataFile(pos.Fid), pos)
+	if err != nil {
+		return err
+	}
+	if logRecord.Type != data.LogRecordOperand {
+		return nil
+	}
+	value, err := db.foldOperands(db.getDataFile, logRecord)
+	if err != nil {
+		return err
+	}
+	return db.putLocked(key, value, pos.Expire)
+}
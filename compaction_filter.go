@@ -0,0 +1,101 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"bytes"
+)
+
+// CompactionDecision 压缩过滤器对一条记录的处理方式
+type CompactionDecision int8
+
+const (
+	// CompactionKeep 保留原来的值
+	CompactionKeep CompactionDecision = iota
+
+	// CompactionDrop 删除这个 key
+	CompactionDrop
+
+	// CompactionReplace 用新的值替换原来的值
+	CompactionReplace
+)
+
+// CompactionFilter 压缩过滤器
+// Merge 在重写每一条有效的记录之前调用 Filter, 可以删除或者改写过期的 key, 例如 schema 版本过旧的数据
+// 删除的 key 不需要额外写入数据, 改写的值在 merge 完成之后还会写入活跃文件, 之后的读取马上就能看到新的值
+type CompactionFilter interface {
+	// Name 压缩过滤器的名称
+	Name() string
+
+	// Filter 决定如何处理 key 当前的值, 只有返回 CompactionReplace 时才会使用 newValue
+	// 调用时不持有数据库的锁, 不能在 Filter 中读写数据库
+	Filter(key []byte, value []byte) (decision CompactionDecision, newValue []byte)
+}
+
+// PrefixDropFilter 删除指定前缀的 key 的压缩过滤器
+type PrefixDropFilter struct {
+	Prefix []byte
+}
+
+func (f PrefixDropFilter) Name() string {
+	return "prefix-drop"
+}
+
+func (f PrefixDropFilter) Filter(key []byte, value []byte) (CompactionDecision, []byte) {
+	if bytes.HasPrefix(key, f.Prefix) {
+		return CompactionDrop, nil
+	}
+	return CompactionKeep, nil
+}
+
+// droppedKey 被压缩过滤器删除的 key 以及当时的位置
+type droppedKey struct {
+	key []byte
+	pos *data.LogRecordPos
+}
+
+// replacedKey 被压缩过滤器改写的 key 以及当时的位置和新的值
+type replacedKey struct {
+	key   []byte
+	pos   *data.LogRecordPos
+	value []byte
+}
+
+// putReplacedKeys merge 完成之后把被压缩过滤器改写的值写入活跃文件, 读取、快照和订阅者都能马上看到新的值
+// merge 开始之后又被修改过的 key 已经有了更新的值, 不能覆盖
+func (db *DB) putReplacedKeys(replaced []replacedKey) error {
+	if len(replaced) == 0 {
+		return nil
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, item := range replaced {
+		pos := db.index.Get(item.key)
+		if pos == nil || pos.Fid != item.pos.Fid || pos.Offset != item.pos.Offset {
+			continue
+		}
+		if err := db.putLocked(item.key, item.value, pos.Expire); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteDroppedKeys merge 完成之后从内存索引中删除被压缩过滤器删除的 key
+// merge 开始之后又被修改过的 key 在没有参与 merge 的文件中有新的数据, 不能删除
+func (db *DB) deleteDroppedKeys(dropped []droppedKey) {
+	if len(dropped) == 0 {
+		return
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, item := range dropped {
+		pos := db.index.Get(item.key)
+		if pos != nil && pos.Fid == item.pos.Fid && pos.Offset == item.pos.Offset {
+			db.deleteIndex(item.key)
+		}
+	}
+	// 内存索引中已经没有这些 key 了, merge 的结果被丢弃的话这些 key 会在重启之后重新出现
+	db.mergeDropped = true
+}
@@ -0,0 +1,104 @@
+package GoKeeper
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// schemaFilter 删除 v1 版本的数据, 把 v2 版本的数据改写成大写
+type schemaFilter struct{}
+
+func (schemaFilter) Name() string {
+	return "schema"
+}
+
+func (schemaFilter) Filter(key []byte, value []byte) (CompactionDecision, []byte) {
+	switch {
+	case bytes.HasPrefix(key, []byte("v1:")):
+		return CompactionDrop, nil
+	case bytes.HasPrefix(key, []byte("v2:")):
+		return CompactionReplace, bytes.ToUpper(value)
+	}
+	return CompactionKeep, nil
+}
+
+func TestDB_CompactionFilter(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-compaction-filter")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeThreshold = 0
+	opts.CompactionFilter = schemaFilter{}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("v1:a"), []byte("a")))
+	assert.Nil(t, db.Put([]byte("v1:b"), []byte("b")))
+	assert.Nil(t, db.Put([]byte("v2:c"), []byte("c")))
+	assert.Nil(t, db.Put([]byte("v3:d"), []byte("d")))
+	assert.Nil(t, db.Put([]byte("v2:e"), []byte("e")))
+
+	// merge 开始之后又被修改的 key 不会被删除或者改写
+	err = db.MergeWithOptions(context.Background(), MergeOptions{
+		Progress: func(status MergeStatus) {
+			if status.Running && status.FilesDone == 0 {
+				assert.Nil(t, db.Put([]byte("v1:b"), []byte("b2")))
+				assert.Nil(t, db.Put([]byte("v2:e"), []byte("e2")))
+			}
+		},
+	})
+	assert.Nil(t, err)
+
+	// 1. 被删除的 key 立即从内存索引中删除
+	_, err = db.Get([]byte("v1:a"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get([]byte("v1:b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b2"), val)
+	assert.Equal(t, 4, len(db.ListKeys()))
+
+	// 2. 改写的值立即生效, 不需要等到重启安装 merge 的结果
+	val, err = db.Get([]byte("v2:c"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("C"), val)
+	val, err = db.Get([]byte("v2:e"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("e2"), val)
+
+	// 3. merge 的结果安装之前不能再次 merge
+	assert.Equal(t, ErrMergeNotInstalled, db.Merge())
+
+	// 4. 重启安装之后改写的值依然生效, 删除的 key 依然不存在
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	_, err = db2.Get([]byte("v1:a"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db2.Get([]byte("v1:b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b2"), val)
+	val, err = db2.Get([]byte("v2:c"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("C"), val)
+	val, err = db2.Get([]byte("v2:e"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("e2"), val)
+	val, err = db2.Get([]byte("v3:d"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("d"), val)
+	assert.Equal(t, 4, len(db2.ListKeys()))
+	assert.Nil(t, db2.Merge())
+}
+
+func TestPrefixDropFilter(t *testing.T) {
+	filter := PrefixDropFilter{Prefix: []byte("tmp:")}
+	decision, _ := filter.Filter([]byte("tmp:1"), []byte("a"))
+	assert.Equal(t, CompactionDrop, decision)
+	decision, _ = filter.Filter([]byte("user:1"), []byte("a"))
+	assert.Equal(t, CompactionKeep, decision)
+}
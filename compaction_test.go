@@ -0,0 +1,147 @@
+package GoKeeper
+
+import (
+	"GoKeeper/util"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// checkValues 检查数据库中的数据和期望的一致, nil 表示 key 已经被删除
+func checkValues(t *testing.T, db *DB, expected map[int][]byte) {
+	for i, value := range expected {
+		val, err := db.Get(util.GetRandomKey(i))
+		if value == nil {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+	}
+}
+
+func TestDB_Compact(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-compact")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeThreshold = 0.3
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 没有无效数据的时候不需要压缩
+	expected := make(map[int][]byte)
+	for i := 0; i < 1000; i++ {
+		expected[i] = util.GetRandomValue(64)
+		assert.Nil(t, db.Put(util.GetRandomKey(i), expected[i]))
+	}
+	assert.Equal(t, ErrMergeNotExceedThreshold, db.Compact(0))
+
+	// 删除一部分 key, 覆盖写入一部分 key
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(util.GetRandomKey(i)))
+		expected[i] = nil
+	}
+	for n := 0; n < 2; n++ {
+		for i := 100; i < 600; i++ {
+			expected[i] = util.GetRandomValue(64)
+			assert.Nil(t, db.Put(util.GetRandomKey(i), expected[i]))
+		}
+	}
+
+	// 1. 每个数据文件的无效数据之和就是可回收的空间
+	var deadSize int64
+	sizes := make(map[uint32]int64)
+	for fid, dataFile := range db.olderFiles {
+		deadSize += dataFile.DeadSize
+		sizes[fid] = dataFile.WriteOff
+	}
+	deadSize += db.activeFile.DeadSize
+	assert.Equal(t, db.Stat().ReclaimableSize, deadSize)
+
+	// 2. 只压缩无效数据最多的两个文件
+	reclaimSize := db.Stat().ReclaimableSize
+	assert.Nil(t, db.Compact(2))
+	var compacted int
+	for fid, dataFile := range db.olderFiles {
+		if dataFile.WriteOff < sizes[fid] {
+			compacted++
+		}
+	}
+	assert.Equal(t, 2, compacted)
+	assert.Equal(t, len(sizes), len(db.olderFiles))
+	assert.True(t, db.Stat().ReclaimableSize < reclaimSize)
+	checkValues(t, db, expected)
+
+	// 3. 压缩剩余的文件, 压缩之后可以继续写入
+	assert.Nil(t, db.Compact(0))
+	checkValues(t, db, expected)
+	expected[0] = []byte("new")
+	assert.Nil(t, db.Put(util.GetRandomKey(0), expected[0]))
+
+	// 4. 重启之后数据和删除记录依然有效
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	checkValues(t, db2, expected)
+	assert.Equal(t, 901, len(db2.ListKeys()))
+}
+
+func TestDB_CompactPinned(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-compact-pinned")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for n := 0; n < 3; n++ {
+		for i := 0; i < 500; i++ {
+			assert.Nil(t, db.Put(util.GetRandomKey(i), util.GetRandomValue(64)))
+		}
+	}
+
+	// 被快照引用的文件不能重写
+	snapshot := db.NewSnapshot()
+	assert.Equal(t, ErrMergeNotExceedThreshold, db.Compact(0))
+	snapshot.Release()
+	assert.Nil(t, db.Compact(0))
+}
+
+func TestDB_CompactOperand(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-compact-operand")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeOperator = Int64AddOperator{}
+	opts.MergeThreshold = 0.1
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 操作数链表跨越多个数据文件
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, db.Apply([]byte("counter"), []byte("1")))
+		for j := 0; j < 50; j++ {
+			assert.Nil(t, db.Put(util.GetRandomKey(j), util.GetRandomValue(64)))
+		}
+	}
+	assert.True(t, len(db.olderFiles) > 1)
+
+	assert.Nil(t, db.Compact(0))
+	val, err := db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("20"), val)
+
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	val, err = db2.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("20"), val)
+}
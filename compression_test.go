@@ -0,0 +1,73 @@
+package GoKeeper
+
+import (
+	"GoKeeper/util"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Compression(t *testing.T) {
+	for _, indexType := range []IndexType{Btree, BPlusTree} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "goKeeper-compression")
+		opts.DirPath = dir
+		opts.DataFileSize = 32 * 1024
+		opts.IndexType = indexType
+		opts.MergeThreshold = 0
+
+		// 每次重新打开时换一种压缩算法, 之前写入的数据仍然可以读取
+		expected := make(map[int][]byte)
+		for n, compression := range []CompressionType{Snappy, Zstd, NoCompression} {
+			opts.Compression = compression
+			db, err := Open(opts)
+			assert.Nil(t, err)
+			checkValues(t, db, expected)
+			for i := 0; i < 500; i++ {
+				key := n*500 + i
+				expected[key] = bytes.Repeat([]byte{byte('a' + key%26)}, 256)
+				assert.Nil(t, db.Put(util.GetRandomKey(key), expected[key]))
+			}
+			for i := 0; i < 50; i++ {
+				assert.Nil(t, db.Delete(util.GetRandomKey(n*500+i)))
+				expected[n*500+i] = nil
+			}
+			checkValues(t, db, expected)
+
+			// B+树索引重新打开时不读取数据文件, 之前的数据文件不压缩时的大小是未知的
+			stat := db.Stat()
+			assert.Greater(t, stat.CompressedSize, int64(0))
+			if indexType == Btree || n == 0 {
+				assert.Greater(t, stat.LogicalSize, stat.CompressedSize*2)
+			}
+			assert.Nil(t, db.Close())
+		}
+
+		// merge 时按照当前的配置重新写入, 启动之后从 hint 文件加载索引也能统计不压缩时的大小
+		opts.Compression = Zstd
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.Nil(t, db.Merge())
+		assert.Nil(t, db.Close())
+
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		checkValues(t, db, expected)
+		stat := db.Stat()
+		if indexType == Btree {
+			assert.Greater(t, stat.LogicalSize, stat.CompressedSize*2)
+		}
+		destroyDB(db)
+	}
+}
+
+func TestOpen_CompressionOption(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-compression-opt")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.Compression = CompressionType(3)
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+}
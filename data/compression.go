@@ -0,0 +1,62 @@
+package data
+
+import (
+	"errors"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	ErrUnknownCompression = errors.New("unknown compression type")
+)
+
+// CompressionType value 的压缩算法
+type CompressionType byte
+
+const (
+	// NoCompression 不压缩
+	NoCompression CompressionType = iota
+	// SnappyCompression snappy 压缩, 速度快
+	SnappyCompression
+	// ZstdCompression zstd 压缩, 压缩率高
+	ZstdCompression
+)
+
+// zstd 的编码器和解码器创建的开销比较大, 全局共享, EncodeAll 和 DecodeAll 可以并发调用
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+)
+
+// compressValue 使用指定的算法压缩 value, 压缩之后没有变小时返回 false, 此时按照不压缩存储
+func compressValue(compression CompressionType, value []byte) ([]byte, bool) {
+	if len(value) == 0 {
+		return value, false
+	}
+	var compressed []byte
+	switch compression {
+	case SnappyCompression:
+		compressed = snappy.Encode(nil, value)
+	case ZstdCompression:
+		compressed = zstdEncoder.EncodeAll(value, nil)
+	default:
+		return value, false
+	}
+	if len(compressed) >= len(value) {
+		return value, false
+	}
+	return compressed, true
+}
+
+// decompressValue 使用指定的算法解压 value
+func decompressValue(compression CompressionType, value []byte) ([]byte, error) {
+	switch compression {
+	case NoCompression:
+		return value, nil
+	case SnappyCompression:
+		return snappy.Decode(nil, value)
+	case ZstdCompression:
+		return zstdDecoder.DecodeAll(value, nil)
+	}
+	return nil, ErrUnknownCompression
+}
@@ -15,6 +15,7 @@ var (
 
 const (
 	DataFileNameSuffix    = ".data"
+	CompactFileSuffix     = ".compact"
 	HintFileName          = "hint-index"
 	MergeFinishedFileName = "merge-finished"
 	SeqNoFileName         = "seq-no"
@@ -22,18 +23,32 @@ const (
 
 // DataFile 数据文件
 type DataFile struct {
-	FileID    uint32        // 文件id
-	WriteOff  int64         // 文件写到了哪个位置
-	IoManager fio.IOManager // io 读写管理
+	FileID      uint32        // 文件id
+	WriteOff    int64         // 文件写到了哪个位置
+	DeadSize    int64         // 文件中无效数据的大小
+	LogicalSize int64         // 文件中的记录不压缩时的大小, 0 表示未知
+	IoManager   fio.IOManager // io 读写管理
+	Cipher      *Cipher       // 加密和解密记录, nil 表示不加密
+	Header      *FileHeader   // 文件头, nil 表示空文件或者旧版本的文件
+}
+
+// LiveSize 文件中有效数据的大小
+func (df *DataFile) LiveSize() int64 {
+	return df.WriteOff - df.DeadSize
 }
 
 // OpenDataFile 打开新的数据文件
 func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
 	fileName := GetDataFileName(dirPath, fileId)
-	fmt.Println("FileName:", fileName)
 	return newDateFile(fileName, fileId, ioType)
 }
 
+// OpenCompactFile 打开压缩数据文件时使用的临时文件, 压缩完成之后替换掉原来的数据文件
+func OpenCompactFile(dirPath string, fileId uint32) (*DataFile, error) {
+	fileName := GetDataFileName(dirPath, fileId) + CompactFileSuffix
+	return newDateFile(fileName, fileId, fio.StandardFIO)
+}
+
 // OpenHintFile 打开新的 hint 文件
 func OpenHintFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, HintFileName)
@@ -63,21 +78,32 @@ func newDateFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFi
 	if err != nil {
 		return nil, err
 	}
-	return &DataFile{
+	dataFile := &DataFile{
 		FileID:    fileId,
 		WriteOff:  0,
 		IoManager: ioManager,
-	}, err
+	}
+	// 检查文件格式的版本
+	if err = dataFile.readHeader(); err != nil {
+		_ = ioManager.Close()
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return dataFile, nil
 }
 
 // ReadLogRecord 读取日志记录
 // 返回日志记录,日志记录长度,error
+// 记录超出了文件末尾时返回 io.ErrUnexpectedEOF, crc 校验失败时同时返回记录的长度, 可以用来跳过这条记录
 func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	// 获取文件大小
 	fileSize, err := df.IoManager.Size()
 	if err != nil {
 		return nil, 0, err
 	}
+	// 文件已经关闭, 或者位置超出了文件末尾
+	if offset >= fileSize {
+		return nil, 0, io.EOF
+	}
 	var headerBytes int64 = maxLogRecordHeaderSize
 	if offset+maxLogRecordHeaderSize > fileSize {
 		headerBytes = fileSize - offset
@@ -91,6 +117,10 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	// 对 Head 进行解码:
 	// 返回解码后的 Header 和 HeaderSize
 	header, headerSize := DecodeLogRecordHead(headerBuf)
+	// 头部损坏, 无法确定记录的长度
+	if headerSize < 0 {
+		return nil, 0, ErrInvalidCRC
+	}
 	// 这两个条件标识读取到了文件末尾
 	if header == nil {
 		return nil, 0, io.EOF
@@ -102,9 +132,15 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	// 取出对应的 key 和 value的长度
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
 	var recordSize = headerSize + keySize + valueSize
+	// 写入过程中崩溃, 或者头部损坏之后解码出了错误的长度
+	if offset+recordSize > fileSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
 
 	logRecord := &LogRecord{
-		Type: header.recordType,
+		Type:        header.recordType,
+		Expire:      header.expire,
+		Compression: header.compression,
 	}
 	// 读取用户实际存储的 key/value长度
 	if keySize > 0 || valueSize > 0 {
@@ -121,7 +157,18 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	// 与存储在数据文件中的 CRC 进行比较
 	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:headerSize])
 	if crc != header.crc {
-		return nil, 0, ErrInvalidCRC
+		return nil, recordSize, ErrInvalidCRC
+	}
+
+	// 校验通过之后再解密和解压 value, 出错时记录的边界是可信的, 同样返回记录的长度
+	if header.encrypted {
+		aad := headerBuf[crc32.Size:headerSize]
+		if logRecord.Key, logRecord.Value, err = df.Cipher.open(logRecord.Key, logRecord.Value, aad); err != nil {
+			return nil, recordSize, err
+		}
+	}
+	if logRecord.Value, err = decompressValue(header.compression, logRecord.Value); err != nil {
+		return nil, recordSize, err
 	}
 
 	return logRecord, recordSize, nil
@@ -143,7 +190,10 @@ func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 		Value: EncodeLogRecordPos(pos),
 	}
 	// 再对 logRecord 进行编码
-	logRecord, _ := EncodeLogRecord(record)
+	logRecord, _, err := df.Cipher.EncodeLogRecord(record)
+	if err != nil {
+		return err
+	}
 	return df.Write(logRecord)
 }
 
@@ -164,6 +214,21 @@ func (df *DataFile) readNBytes(n int64, offset int64) ([]byte, error) {
 	return b, err
 }
 
+// Preallocate 可读写的内存映射文件预分配 size 大小的空间, 其他 IO 类型不需要预分配
+func (df *DataFile) Preallocate(size int64) {
+	if mmap, ok := df.IoManager.(*fio.MMapRW); ok {
+		mmap.Preallocate(size)
+	}
+}
+
+// Trim 截断可读写的内存映射文件末尾没有使用的预分配空间
+func (df *DataFile) Trim() error {
+	if mmap, ok := df.IoManager.(*fio.MMapRW); ok {
+		return mmap.Trim()
+	}
+	return nil
+}
+
 func (df *DataFile) SetIOManager(dirPath string, ioType fio.FileIOType) error {
 	if err := df.IoManager.Close(); err != nil {
 		return err
@@ -119,3 +119,25 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	assert.Equal(t, readRecord3.Value, record3.Value)
 	assert.Equal(t, readRecord3.Type, record3.Type)
 }
+
+func TestDataFile_ReadLogRecordExpire(t *testing.T) {
+	dir := t.TempDir()
+	file, err := OpenDataFile(dir, 1, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer file.Close()
+
+	record := &LogRecord{
+		Key:    []byte("session"),
+		Value:  []byte("token"),
+		Type:   LogRecordNormal,
+		Expire: 1700000000000000000,
+	}
+	buf, size := EncodeLogRecord(record)
+	err = file.Write(buf)
+	assert.Nil(t, err)
+
+	readRecord, readSize, err := file.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, size, readSize)
+	assert.Equal(t, record, readRecord)
+}
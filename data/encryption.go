@@ -0,0 +1,170 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrEncryptionKeyRequired = errors.New("the record is encrypted but no encryption key is provided")
+	ErrDecryptFailed         = errors.New("failed to decrypt the record")
+)
+
+// 加密记录的 key 部分存储密钥 id 和随机数, value 部分存储加密之后的原始 key 和 value
+//
+//	+------------+----------+-------------------------------------------+
+//	|  密钥 id   |  随机数  |  AES-GCM(原始 key 的长度 | key | value)   |
+//	+------------+----------+-------------------------------------------+
+//	   4 字节       12 字节    变长
+//
+// 密钥 id 保存在每条记录中, 而不是数据文件的文件头中:
+//   - 并发的写入在加锁之前各自加密, 加密时还不知道组提交会把记录写到哪个数据文件
+//   - KeyProvider 随时可以轮换密钥, 轮换之后的写入马上使用新的密钥, 同一个数据文件中会有不同密钥加密的记录
+//   - hint 文件和 merge 完成标识文件中的记录使用同样的格式, 解密不依赖记录所在的文件
+//
+// 每条记录多占用 4 字节, 相比 12 字节的随机数和 16 字节的认证标签很小
+const (
+	encryptionKeyIDSize = 4
+	encryptionNonceSize = 12
+)
+
+// KeyProvider 提供数据文件加密使用的密钥
+// 每条记录都会保存加密时使用的密钥 id, 轮换密钥之后旧的密钥仍然需要能够通过 id 获取, 旧的数据才能读取
+type KeyProvider interface {
+	// CurrentKeyID 写入新数据时使用的密钥 id
+	CurrentKeyID() uint32
+
+	// Key 根据 id 获取密钥, 长度为 16, 24 或者 32 字节, 分别对应 AES-128, AES-192 和 AES-256
+	// 同一个 id 对应的密钥不能改变
+	Key(id uint32) ([]byte, error)
+}
+
+// KeyRing 保存在内存中的一组密钥
+type KeyRing struct {
+	CurrentID uint32
+	Keys      map[uint32][]byte
+}
+
+func (r *KeyRing) CurrentKeyID() uint32 {
+	return r.CurrentID
+}
+
+func (r *KeyRing) Key(id uint32) ([]byte, error) {
+	key, ok := r.Keys[id]
+	if !ok {
+		return nil, errors.New("encryption key not found")
+	}
+	return key, nil
+}
+
+// Cipher 加密和解密日志记录, 缓存每个密钥 id 对应的 AES-GCM 实例
+type Cipher struct {
+	provider KeyProvider
+	lock     sync.Mutex
+	aeads    map[uint32]cipher.AEAD
+}
+
+// NewCipher 创建 Cipher, provider 为 nil 时返回 nil, 表示不加密
+func NewCipher(provider KeyProvider) *Cipher {
+	if provider == nil {
+		return nil
+	}
+	return &Cipher{
+		provider: provider,
+		aeads:    make(map[uint32]cipher.AEAD),
+	}
+}
+
+// EncodeLogRecord 对 LogRecord 进行编码并使用当前的密钥加密, c 为 nil 时不加密
+func (c *Cipher) EncodeLogRecord(logRecord *LogRecord) ([]byte, int64, error) {
+	if c == nil {
+		encodeRecord, size := EncodeLogRecord(logRecord)
+		return encodeRecord, size, nil
+	}
+	keyID := c.provider.CurrentKeyID()
+	aead, err := c.getAEAD(keyID)
+	if err != nil {
+		return nil, 0, err
+	}
+	sealer := &recordSealer{
+		aead:    aead,
+		keyPart: make([]byte, encryptionKeyIDSize+encryptionNonceSize),
+	}
+	binary.LittleEndian.PutUint32(sealer.keyPart, keyID)
+	if _, err = rand.Read(sealer.keyPart[encryptionKeyIDSize:]); err != nil {
+		return nil, 0, err
+	}
+	encodeRecord, size := encodeLogRecord(logRecord, sealer)
+	return encodeRecord, size, nil
+}
+
+// open 解密记录, 返回原始的 key 和 value
+func (c *Cipher) open(keyPart, sealed, header []byte) ([]byte, []byte, error) {
+	if c == nil {
+		return nil, nil, ErrEncryptionKeyRequired
+	}
+	if len(keyPart) != encryptionKeyIDSize+encryptionNonceSize {
+		return nil, nil, ErrDecryptFailed
+	}
+	aead, err := c.getAEAD(binary.LittleEndian.Uint32(keyPart))
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := aead.Open(nil, keyPart[encryptionKeyIDSize:], sealed, header)
+	if err != nil {
+		return nil, nil, ErrDecryptFailed
+	}
+	keySize, n := binary.Uvarint(plaintext)
+	if n <= 0 || keySize > uint64(len(plaintext)-n) {
+		return nil, nil, ErrDecryptFailed
+	}
+	plaintext = plaintext[n:]
+	return plaintext[:keySize], plaintext[keySize:], nil
+}
+
+func (c *Cipher) getAEAD(keyID uint32) (cipher.AEAD, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if aead, ok := c.aeads[keyID]; ok {
+		return aead, nil
+	}
+	key, err := c.provider.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c.aeads[keyID] = aead
+	return aead, nil
+}
+
+// recordSealer 加密一条记录使用的密钥和随机数
+type recordSealer struct {
+	aead    cipher.AEAD
+	keyPart []byte // 密钥 id 和随机数
+}
+
+// sealedSize 加密之后 value 部分的长度
+func (s *recordSealer) sealedSize(key, value []byte) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], uint64(len(key))) + len(key) + len(value) + s.aead.Overhead()
+}
+
+// seal 加密原始的 key 和 value, header 作为附加数据一起认证
+func (s *recordSealer) seal(key, value, header []byte) []byte {
+	plaintext := make([]byte, 0, binary.MaxVarintLen64+len(key)+len(value))
+	plaintext = binary.AppendUvarint(plaintext, uint64(len(key)))
+	plaintext = append(plaintext, key...)
+	plaintext = append(plaintext, value...)
+	return s.aead.Seal(nil, s.keyPart[encryptionKeyIDSize:], plaintext, header)
+}
@@ -0,0 +1,89 @@
+package data
+
+import (
+	"GoKeeper/fio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestCipher_EncodeLogRecord(t *testing.T) {
+	dir := t.TempDir()
+	keyRing := &KeyRing{
+		CurrentID: 1,
+		Keys: map[uint32][]byte{
+			1: bytes.Repeat([]byte("k"), 16),
+			2: bytes.Repeat([]byte("K"), 32),
+		},
+	}
+	c := NewCipher(keyRing)
+	file, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer file.Close()
+	file.Cipher = c
+
+	// 轮换密钥前后写入的记录都可以读取
+	records := []*LogRecord{
+		{Key: []byte("name"), Value: []byte("Sakura"), Type: LogRecordNormal},
+		{Key: []byte("city"), Value: bytes.Repeat([]byte("Tokyo"), 100), Expire: 1700000000000000000, Compression: SnappyCompression},
+		{Key: []byte("name"), Type: LogRecordDeleted},
+	}
+	var offsets []int64
+	for i, record := range records {
+		if i == 1 {
+			keyRing.CurrentID = 2
+		}
+		encodeRecord, n, err := c.EncodeLogRecord(record)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(encodeRecord)), n)
+		offsets = append(offsets, file.WriteOff)
+		assert.Nil(t, file.Write(encodeRecord))
+	}
+	for i, record := range records {
+		readRecord, _, err := file.ReadLogRecord(offsets[i])
+		assert.Nil(t, err)
+		assert.Equal(t, record.Key, readRecord.Key)
+		assert.Equal(t, string(record.Value), string(readRecord.Value))
+		assert.Equal(t, record.Type, readRecord.Type)
+		assert.Equal(t, record.Expire, readRecord.Expire)
+	}
+
+	// 文件中没有明文
+	content, err := os.ReadFile(GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(content, []byte("Sakura")))
+	assert.False(t, bytes.Contains(content, []byte("name")))
+
+	// 没有密钥或者密钥不对时无法读取
+	file.Cipher = nil
+	_, _, err = file.ReadLogRecord(offsets[0])
+	assert.Equal(t, ErrEncryptionKeyRequired, err)
+	file.Cipher = NewCipher(&KeyRing{Keys: map[uint32][]byte{1: bytes.Repeat([]byte("x"), 16)}})
+	_, _, err = file.ReadLogRecord(offsets[0])
+	assert.Equal(t, ErrDecryptFailed, err)
+	_, _, err = file.ReadLogRecord(offsets[1])
+	assert.NotNil(t, err)
+
+	// 不加密时和原来的编码一致
+	record := &LogRecord{Key: []byte("name"), Value: []byte("Sakura")}
+	encodeRecord, _, err := (*Cipher)(nil).EncodeLogRecord(record)
+	assert.Nil(t, err)
+	expected, _ := EncodeLogRecord(record)
+	assert.Equal(t, expected, encodeRecord)
+}
+
+func TestCipher_HintRecord(t *testing.T) {
+	dir := t.TempDir()
+	hintFile, err := OpenHintFile(dir)
+	assert.Nil(t, err)
+	defer hintFile.Close()
+	hintFile.Cipher = NewCipher(&KeyRing{Keys: map[uint32][]byte{0: bytes.Repeat([]byte("k"), 24)}})
+
+	pos := &LogRecordPos{Fid: 3, Offset: 100, Size: 20}
+	assert.Nil(t, hintFile.WriteHintRecord([]byte("name"), pos))
+	record, _, err := hintFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("name"), record.Key)
+	assert.Equal(t, pos, DecodeLogRecordPos(record.Value))
+}
@@ -0,0 +1,134 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"time"
+)
+
+var (
+	ErrInvalidFileHeader      = errors.New("invalid file header")
+	ErrUnsupportedFileVersion = errors.New("unsupported file format version, upgrade GoKeeper to open it")
+)
+
+// FileFormatVersion 当前的文件格式版本
+// 修改记录的编码方式时需要增加版本号, 旧版本的文件通过文件头中的版本号区分
+const FileFormatVersion uint16 = 1
+
+// FileHeaderSize 文件头的长度, 文件中的第一条记录从文件头之后开始
+const FileHeaderSize = 32
+
+// 文件头的结构:
+//
+//	+--------+--------+--------+------------+------------+--------+--------+
+//	|  魔数  |  版本  |  标志  |  创建时间  | 配置指纹   |  保留  |  crc   |
+//	+--------+--------+--------+------------+------------+--------+--------+
+//	  4        2        2        8            8            4        4
+var fileMagic = []byte("GKDB")
+
+// FileFlagCompacted 文件是增量压缩重写出来的, 文件中记录的位置和重写之前不同
+const FileFlagCompacted uint16 = 1 << 0
+
+// FileHeader 文件头, 旧版本的文件没有文件头
+type FileHeader struct {
+	Version     uint16 // 文件格式版本
+	Flags       uint16 // 文件标志
+	CreatedAt   int64  // 创建时间(UnixNano)
+	Fingerprint uint64 // 创建文件时影响数据格式的配置项的指纹
+}
+
+func (h *FileHeader) encode() []byte {
+	buf := make([]byte, FileHeaderSize)
+	copy(buf, fileMagic)
+	binary.LittleEndian.PutUint16(buf[4:], h.Version)
+	binary.LittleEndian.PutUint16(buf[6:], h.Flags)
+	binary.LittleEndian.PutUint64(buf[8:], uint64(h.CreatedAt))
+	binary.LittleEndian.PutUint64(buf[16:], h.Fingerprint)
+	binary.LittleEndian.PutUint32(buf[28:], crc32.ChecksumIEEE(buf[:28]))
+	return buf
+}
+
+// DecodeFileHeader 解码文件头, 开头不是魔数时返回 nil, 表示旧版本没有文件头的文件
+func DecodeFileHeader(buf []byte) (*FileHeader, error) {
+	if len(buf) < len(fileMagic) || !bytes.Equal(buf[:len(fileMagic)], fileMagic) {
+		return nil, nil
+	}
+	if len(buf) < FileHeaderSize || binary.LittleEndian.Uint32(buf[28:]) != crc32.ChecksumIEEE(buf[:28]) {
+		return nil, ErrInvalidFileHeader
+	}
+	header := &FileHeader{
+		Version:     binary.LittleEndian.Uint16(buf[4:]),
+		Flags:       binary.LittleEndian.Uint16(buf[6:]),
+		CreatedAt:   int64(binary.LittleEndian.Uint64(buf[8:])),
+		Fingerprint: binary.LittleEndian.Uint64(buf[16:]),
+	}
+	if header.Version == 0 || header.Version > FileFormatVersion {
+		return nil, ErrUnsupportedFileVersion
+	}
+	return header, nil
+}
+
+// IsTornHeader 判断文件内容是否只有不完整的文件头, 创建文件之后写入文件头的过程中崩溃会出现这种情况
+func IsTornHeader(buf []byte) bool {
+	if len(buf) == 0 || len(buf) >= FileHeaderSize {
+		return false
+	}
+	n := min(len(buf), len(fileMagic))
+	return bytes.Equal(buf[:n], fileMagic[:n])
+}
+
+// readHeader 读取文件头, 空文件和旧版本的文件没有文件头
+func (df *DataFile) readHeader() error {
+	size, err := df.IoManager.Size()
+	if err != nil || size == 0 {
+		return err
+	}
+	n := int64(FileHeaderSize)
+	if size < n {
+		n = size
+	}
+	buf, err := df.readNBytes(n, 0)
+	if err != nil {
+		return err
+	}
+	df.Header, err = DecodeFileHeader(buf)
+	return err
+}
+
+// InitHeader 如果文件是空的, 写入当前版本的文件头
+func (df *DataFile) InitHeader(fingerprint uint64) error {
+	return df.InitHeaderWithFlags(fingerprint, 0)
+}
+
+// InitHeaderWithFlags 如果文件是空的, 写入带有标志的当前版本的文件头
+func (df *DataFile) InitHeaderWithFlags(fingerprint uint64, flags uint16) error {
+	if df.Header != nil || df.WriteOff > 0 {
+		return nil
+	}
+	header := &FileHeader{
+		Version:     FileFormatVersion,
+		Flags:       flags,
+		CreatedAt:   time.Now().UnixNano(),
+		Fingerprint: fingerprint,
+	}
+	if err := df.Write(header.encode()); err != nil {
+		return err
+	}
+	df.Header = header
+	return nil
+}
+
+// IsCompacted 文件是否被增量压缩重写过
+func (df *DataFile) IsCompacted() bool {
+	return df.Header != nil && df.Header.Flags&FileFlagCompacted != 0
+}
+
+// HeaderSize 文件头的长度, 也是文件中第一条记录的位置
+func (df *DataFile) HeaderSize() int64 {
+	if df.Header == nil {
+		return 0
+	}
+	return FileHeaderSize
+}
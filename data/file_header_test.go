@@ -0,0 +1,88 @@
+package data
+
+import (
+	"GoKeeper/fio"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"os"
+	"testing"
+)
+
+func TestDecodeFileHeader(t *testing.T) {
+	header := &FileHeader{Version: FileFormatVersion, Flags: FileFlagCompacted, CreatedAt: 1700000000000000000, Fingerprint: 42}
+	buf := header.encode()
+	assert.Equal(t, FileHeaderSize, len(buf))
+	decoded, err := DecodeFileHeader(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, header, decoded)
+
+	// 旧版本的文件开头是记录的 crc
+	record, _ := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("Sakura")})
+	decoded, err = DecodeFileHeader(record)
+	assert.Nil(t, err)
+	assert.Nil(t, decoded)
+
+	// 文件头损坏
+	corrupted := append([]byte{}, buf...)
+	corrupted[10]++
+	_, err = DecodeFileHeader(corrupted)
+	assert.Equal(t, ErrInvalidFileHeader, err)
+	_, err = DecodeFileHeader(buf[:10])
+	assert.Equal(t, ErrInvalidFileHeader, err)
+
+	// 更新版本的文件
+	future := append([]byte{}, buf...)
+	binary.LittleEndian.PutUint16(future[4:], FileFormatVersion+1)
+	binary.LittleEndian.PutUint32(future[28:], crc32.ChecksumIEEE(future[:28]))
+	_, err = DecodeFileHeader(future)
+	assert.Equal(t, ErrUnsupportedFileVersion, err)
+}
+
+func TestDataFile_InitHeader(t *testing.T) {
+	dir := t.TempDir()
+	file, err := OpenDataFile(dir, 1, fio.StandardFIO)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Header)
+	assert.Equal(t, int64(0), file.HeaderSize())
+
+	assert.Nil(t, file.InitHeader(42))
+	assert.Equal(t, int64(FileHeaderSize), file.WriteOff)
+	// 已经有文件头时不会重复写入
+	assert.Nil(t, file.InitHeader(43))
+	assert.Equal(t, int64(FileHeaderSize), file.WriteOff)
+
+	record, size := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("Sakura")})
+	assert.Nil(t, file.Write(record))
+	assert.Nil(t, file.Close())
+
+	// 重新打开时读取文件头, 第一条记录在文件头之后
+	file, err = OpenDataFile(dir, 1, fio.MemoryMapFIO)
+	assert.Nil(t, err)
+	defer file.Close()
+	assert.NotNil(t, file.Header)
+	assert.Equal(t, uint64(42), file.Header.Fingerprint)
+	readRecord, n, err := file.ReadLogRecord(file.HeaderSize())
+	assert.Nil(t, err)
+	assert.Equal(t, size, n)
+	assert.Equal(t, []byte("Sakura"), readRecord.Value)
+
+	// 更新版本的文件无法打开
+	header := &FileHeader{Version: FileFormatVersion + 1}
+	buf := header.encode()
+	assert.Nil(t, os.WriteFile(GetDataFileName(dir, 2), buf, 0644))
+	_, err = OpenDataFile(dir, 2, fio.StandardFIO)
+	assert.ErrorIs(t, err, ErrUnsupportedFileVersion)
+}
+
+func TestIsTornHeader(t *testing.T) {
+	buf := (&FileHeader{Version: FileFormatVersion}).encode()
+	assert.True(t, IsTornHeader(buf[:2]))
+	assert.True(t, IsTornHeader(buf[:10]))
+	assert.False(t, IsTornHeader(buf))
+	assert.False(t, IsTornHeader(nil))
+
+	// 旧版本很小的数据文件
+	record, _ := EncodeLogRecord(&LogRecord{Key: []byte("a"), Value: []byte("b")})
+	assert.False(t, IsTornHeader(record))
+}
@@ -14,27 +14,52 @@ const (
 	LogRecordDeleted
 	// LogRecordFinished 表示删除状态的标记位
 	LogRecordFinished
+	// LogRecordOperand 表示合并操作数, 读取时由合并操作符和之前的值合并
+	LogRecordOperand
+	// LogRecordRangeDeleted 表示范围删除, key 为范围的下界, value 为范围的上界
+	LogRecordRangeDeleted
 )
 
+// type 字节的最高位作为过期时间的标记位
+// 旧版本的数据文件中没有这个标记, 解码时按永不过期处理
+const logRecordExpireFlag byte = 1 << 7
+
+// type 字节的第 5, 6 位记录 value 的压缩算法
+// 旧版本的数据文件中为 0, 解码时按没有压缩处理
+const (
+	logRecordCompressionShift      = 5
+	logRecordCompressionMask  byte = 3 << logRecordCompressionShift
+)
+
+// type 字节的第 4 位作为加密的标记位
+const logRecordEncryptedFlag byte = 1 << 4
+
 // 日志记录(Header)的结构:
-// crc type keySize valueSize
-// 4    1     5       5
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
+// crc type keySize valueSize expire
+// 4    1     5       5       10(可选)
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5 + binary.MaxVarintLen64
 
 // LogRecord 写入到数据文件的记录
 // 之所以叫做日志,是因为数据文件中的数据是追加写入的,类似日志的格式
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key    []byte
+	Value  []byte
+	Type   LogRecordType
+	Expire int64 // 过期时间(UnixNano), 0 表示永不过期
+
+	// 编码时使用的压缩算法, 读取时为数据文件中实际使用的压缩算法
+	Compression CompressionType
 }
 
 // LogRecordHeader LogRecord 的头部信息
 type LogRecordHeader struct {
-	crc        uint32        // crc 校验值
-	recordType LogRecordType // LogRecord 的类型
-	keySize    uint32        // key 的长度
-	valueSize  uint32        // value 的长度
+	crc         uint32          // crc 校验值
+	recordType  LogRecordType   // LogRecord 的类型
+	compression CompressionType // value 的压缩算法
+	encrypted   bool            // key 和 value 是否加密
+	keySize     uint32          // key 的长度
+	valueSize   uint32          // value 的长度
+	expire      int64           // 过期时间, 0 表示永不过期
 }
 
 // LogRecordPos 数据内存的索引,描述数据在磁盘上的位置
@@ -42,6 +67,17 @@ type LogRecordPos struct {
 	Fid    uint32 // 文件 id,表示数据在哪个文件上
 	Offset int64  // 表示数据在这个文件中的偏移量
 	Size   uint32 // 表示这个数据在磁盘上的大小
+	Expire int64  // 数据的过期时间(UnixNano), 0 表示永不过期
+}
+
+// IsExpired 判断日志记录在 now 时刻是否已经过期
+func (lr *LogRecord) IsExpired(now int64) bool {
+	return lr.Expire > 0 && lr.Expire <= now
+}
+
+// IsExpired 判断索引指向的数据在 now 时刻是否已经过期
+func (pos *LogRecordPos) IsExpired(now int64) bool {
+	return pos.Expire > 0 && pos.Expire <= now
 }
 
 // TransactionRecord  暂存的日志相关数据
@@ -51,25 +87,52 @@ type TransactionRecord struct {
 }
 
 // EncodeLogRecord 对 LogRecord 进行编码,返回字节数组以及长度
-// crc校验值  type     keySize,     valueSize     key     value
+// crc校验值  type     keySize,     valueSize     expire           key     value
 //
-//	4        1     变长(最大5)    变长(最大5)    变长       变长
+//	4        1     变长(最大5)    变长(最大5)    变长(最大10,可选)    变长       变长
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
+	return encodeLogRecord(logRecord, nil)
+}
+
+// encodeLogRecord 对 LogRecord 进行编码, sealer 不为 nil 时加密 key 和 value
+func encodeLogRecord(logRecord *LogRecord, sealer *recordSealer) ([]byte, int64) {
 	header := make([]byte, maxLogRecordHeaderSize)
 	//header := make([]byte, maxLogRecordHeaderSize+len(logRecord.Key)+len(logRecord.Value))
 
 	// 因为crc部分要包含后面的一切信息,所以最后进行crc校验
-	// 第五个存储 Type
+	// 第五个存储 Type, 设置了过期时间的记录在最高位打上标记
 	header[4] = byte(logRecord.Type)
+	if logRecord.Expire > 0 {
+		header[4] |= logRecordExpireFlag
+	}
+	// 压缩 value, 压缩算法记录在 type 字节中
+	value, compressed := compressValue(logRecord.Compression, logRecord.Value)
+	if compressed {
+		header[4] |= byte(logRecord.Compression) << logRecordCompressionShift
+	}
+	// 压缩之后再加密, 加密之后的数据无法压缩
+	key, valueSize := logRecord.Key, len(value)
+	if sealer != nil {
+		header[4] |= logRecordEncryptedFlag
+		key, valueSize = sealer.keyPart, sealer.sealedSize(logRecord.Key, value)
+	}
 	var index = 5
 
 	// 之后存储 key 和 value 的长度信息
 	// PutVarint 写入一个可变的 int 变量
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	index += binary.PutVarint(header[index:], int64(len(key)))
+	index += binary.PutVarint(header[index:], int64(valueSize))
+	// 存储过期时间
+	if logRecord.Expire > 0 {
+		index += binary.PutVarint(header[index:], logRecord.Expire)
+	}
+	// header 作为附加数据认证, 类型和过期时间不能被篡改
+	if sealer != nil {
+		value = sealer.seal(logRecord.Key, value, header[4:index])
+	}
 
 	// 计算logRecord的长度
-	var size = index + len(logRecord.Key) + len(logRecord.Value)
+	var size = index + len(key) + len(value)
 
 	// 根据长度创建一个byte切片
 	resultBytes := make([]byte, size)
@@ -77,8 +140,8 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	// 将 header 部分拷贝过来
 	copy(resultBytes[:index], header[:index])
 	// key 和 value 也拷贝过来
-	copy(resultBytes[index:], logRecord.Key)
-	copy(resultBytes[index+len(logRecord.Key):], logRecord.Value)
+	copy(resultBytes[index:], key)
+	copy(resultBytes[index+len(key):], value)
 
 	// 对整个 LogRecord 的数据进行 crc 校验
 	// 从索引 4 开始
@@ -90,6 +153,7 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 }
 
 // DecodeLogRecordHead 对 LogRecord 进行解码，返回 Header 和 size
+// 头部损坏导致变长编码溢出时返回 nil 和 -1
 func DecodeLogRecordHead(buf []byte) (*LogRecordHeader, int64) {
 	// 如果传进来的长度连crc 4 个字节都没有,直接返回
 	if len(buf) <= 4 {
@@ -97,8 +161,10 @@ func DecodeLogRecordHead(buf []byte) (*LogRecordHeader, int64) {
 	}
 
 	header := &LogRecordHeader{
-		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: LogRecordType(buf[4]),
+		crc:         binary.LittleEndian.Uint32(buf[:4]),
+		recordType:  LogRecordType(buf[4] &^ (logRecordExpireFlag | logRecordCompressionMask | logRecordEncryptedFlag)),
+		compression: CompressionType((buf[4] & logRecordCompressionMask) >> logRecordCompressionShift),
+		encrypted:   buf[4]&logRecordEncryptedFlag != 0,
 	}
 
 	var index = 5
@@ -107,25 +173,96 @@ func DecodeLogRecordHead(buf []byte) (*LogRecordHeader, int64) {
 	// 因为变长编码有最高有效位 msb,所以 Varint 会自动读出一个变长编码
 	// eg: 110 111 000 表示一个变长编码的数据
 	keySize, n := binary.Varint(buf[index:])
+	if n < 0 {
+		return nil, -1
+	}
 	header.keySize = uint32(keySize)
 	index += n
 
 	// 取出value Size
 	valueSize, n := binary.Varint(buf[index:])
+	if n < 0 {
+		return nil, -1
+	}
 	header.valueSize = uint32(valueSize)
 	index += n
 
+	// 取出过期时间
+	if buf[4]&logRecordExpireFlag != 0 {
+		expire, n := binary.Varint(buf[index:])
+		if n < 0 {
+			return nil, -1
+		}
+		header.expire = expire
+		index += n
+	}
+
 	return header, int64(index)
 }
 
+// IsRecordAt 判断 buf 是否以一条完整并且 crc 校验通过的记录开头, 用来在损坏的数据之后查找下一条记录
+// 先检查类型字节和长度是否合理, 声明的长度不能超过 buf 的剩余部分, 通过之后才计算 crc, 不会分配内存
+func IsRecordAt(buf []byte) bool {
+	if len(buf) <= 5 {
+		return false
+	}
+	typ := buf[4]
+	if LogRecordType(typ&^(logRecordExpireFlag|logRecordCompressionMask|logRecordEncryptedFlag)) > LogRecordRangeDeleted {
+		return false
+	}
+	if CompressionType((typ&logRecordCompressionMask)>>logRecordCompressionShift) > ZstdCompression {
+		return false
+	}
+
+	// 数据文件中的 key 至少包含事务序列号, 长度不会是 0
+	var index = 5
+	keySize, n := binary.Varint(buf[index:])
+	if n <= 0 || keySize <= 0 || keySize > int64(len(buf)) {
+		return false
+	}
+	index += n
+	valueSize, n := binary.Varint(buf[index:])
+	if n <= 0 || valueSize < 0 || valueSize > int64(len(buf)) {
+		return false
+	}
+	index += n
+	if typ&logRecordExpireFlag != 0 {
+		expire, n := binary.Varint(buf[index:])
+		if n <= 0 || expire <= 0 {
+			return false
+		}
+		index += n
+	}
+
+	size := int64(index) + keySize + valueSize
+	if size > int64(len(buf)) {
+		return false
+	}
+	return crc32.ChecksumIEEE(buf[4:size]) == binary.LittleEndian.Uint32(buf[:4])
+}
+
+// LogicalRecordSize LogRecord 不压缩时编码之后的长度
+func LogicalRecordSize(logRecord *LogRecord) int64 {
+	var buf [binary.MaxVarintLen64]byte
+	size := 5 + binary.PutVarint(buf[:], int64(len(logRecord.Key))) + binary.PutVarint(buf[:], int64(len(logRecord.Value)))
+	if logRecord.Expire > 0 {
+		size += binary.PutVarint(buf[:], logRecord.Expire)
+	}
+	return int64(size + len(logRecord.Key) + len(logRecord.Value))
+}
+
 // EncodeLogRecordPos 对 logRecordPos 进行编码
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
 	// 变长编码的最大值创建切片
-	result := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	result := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*2)
 	var index = 0
 	index = binary.PutVarint(result[index:], int64(uint64(pos.Fid)))
 	index += binary.PutVarint(result[index:], pos.Offset)
 	index += binary.PutVarint(result[index:], int64(pos.Size))
+	// 过期时间为可选字段, 不设置时保持和旧版本相同的编码
+	if pos.Expire > 0 {
+		index += binary.PutVarint(result[index:], pos.Expire)
+	}
 	return result[:index]
 }
 
@@ -139,12 +276,19 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	index += n
 	offset, n := binary.Varint(buf[index:]) // 解码 offset
 	index += n
-	size, _ := binary.Varint(buf[index:]) // 解码 size
+	size, n := binary.Varint(buf[index:]) // 解码 size
+	index += n
+
+	var expire int64
+	if index < len(buf) {
+		expire, _ = binary.Varint(buf[index:]) // 解码过期时间
+	}
 
 	return &LogRecordPos{
 		Fid:    uint32(fileId),
 		Offset: offset,
 		Size:   uint32(size),
+		Expire: expire,
 	}
 }
 
@@ -1,10 +1,12 @@
 package data
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"hash/crc32"
 	"testing"
+	"time"
 )
 
 func TestEncodeLogRecord(t *testing.T) {
@@ -137,3 +139,119 @@ func TestGetLogRecordCRC(t *testing.T) {
 	crc3 := getLogRecordCRC(logRecord3, headerBuf3[crc32.Size:])
 	assert.Equal(t, uint32(4278954632), crc3)
 }
+
+func TestEncodeLogRecord_Expire(t *testing.T) {
+	// 1.带有过期时间的记录
+	logRecord := &LogRecord{
+		Key:    []byte("name"),
+		Value:  []byte("Sakura"),
+		Type:   LogRecordNormal,
+		Expire: 1700000000000000000,
+	}
+	res, n := EncodeLogRecord(logRecord)
+	assert.Greater(t, n, int64(17))
+	assert.NotZero(t, res[4]&logRecordExpireFlag)
+
+	header, headerSize := DecodeLogRecordHead(res)
+	assert.NotNil(t, header)
+	assert.Equal(t, LogRecordNormal, header.recordType)
+	assert.Equal(t, logRecord.Expire, header.expire)
+	assert.Equal(t, uint32(4), header.keySize)
+	assert.Equal(t, uint32(6), header.valueSize)
+	assert.Equal(t, n, headerSize+4+6)
+
+	// 2.没有过期时间的记录和旧版本的编码完全一致
+	headerBuf := []byte{16, 97, 221, 162, 0, 8, 12}
+	res2, _ := EncodeLogRecord(&LogRecord{
+		Key:   []byte("name"),
+		Value: []byte("Sakura"),
+		Type:  LogRecordNormal,
+	})
+	assert.Equal(t, headerBuf, res2[:7])
+	header2, _ := DecodeLogRecordHead(headerBuf)
+	assert.Equal(t, int64(0), header2.expire)
+}
+
+func TestLogRecordPos_Expire(t *testing.T) {
+	// 旧版本的位置信息没有过期时间
+	pos := &LogRecordPos{Fid: 1, Offset: 100, Size: 20}
+	buf := EncodeLogRecordPos(pos)
+	assert.Equal(t, pos, DecodeLogRecordPos(buf))
+	assert.False(t, pos.IsExpired(time.Now().UnixNano()))
+
+	pos2 := &LogRecordPos{Fid: 1, Offset: 100, Size: 20, Expire: 12345}
+	buf2 := EncodeLogRecordPos(pos2)
+	assert.Equal(t, pos2, DecodeLogRecordPos(buf2))
+	assert.True(t, pos2.IsExpired(time.Now().UnixNano()))
+}
+
+func TestEncodeLogRecord_Compression(t *testing.T) {
+	value := bytes.Repeat([]byte("Sakura"), 100)
+	for _, compression := range []CompressionType{SnappyCompression, ZstdCompression} {
+		logRecord := &LogRecord{
+			Key:         []byte("name"),
+			Value:       value,
+			Type:        LogRecordDeleted,
+			Expire:      1700000000000000000,
+			Compression: compression,
+		}
+		res, n := EncodeLogRecord(logRecord)
+		assert.Less(t, n, LogicalRecordSize(logRecord))
+		assert.Equal(t, int64(len(res)), n)
+
+		// 压缩算法和类型, 过期标记互不影响
+		header, headerSize := DecodeLogRecordHead(res)
+		assert.Equal(t, LogRecordDeleted, header.recordType)
+		assert.Equal(t, compression, header.compression)
+		assert.Equal(t, logRecord.Expire, header.expire)
+
+		stored := res[headerSize+int64(header.keySize):]
+		assert.Equal(t, int(header.valueSize), len(stored))
+		assert.Equal(t, header.crc, getLogRecordCRC(&LogRecord{Key: logRecord.Key, Value: stored}, res[crc32.Size:headerSize]))
+		decompressed, err := decompressValue(header.compression, stored)
+		assert.Nil(t, err)
+		assert.Equal(t, value, decompressed)
+	}
+
+	// 压缩之后没有变小的 value 按照不压缩存储
+	logRecord := &LogRecord{Key: []byte("name"), Value: []byte("Sakura"), Compression: ZstdCompression}
+	res, n := EncodeLogRecord(logRecord)
+	assert.Equal(t, LogicalRecordSize(logRecord), n)
+	header, _ := DecodeLogRecordHead(res)
+	assert.Equal(t, NoCompression, header.compression)
+
+	_, err := decompressValue(CompressionType(3), []byte("Sakura"))
+	assert.Equal(t, ErrUnknownCompression, err)
+}
+
+func TestIsRecordAt(t *testing.T) {
+	record, size := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("Sakura"), Expire: time.Now().UnixNano()})
+	assert.True(t, IsRecordAt(record))
+	// 之后还有其他的数据
+	assert.True(t, IsRecordAt(append(append([]byte{}, record...), 1, 2, 3)))
+
+	// 不完整的记录, 声明的长度超出了剩余的数据
+	assert.False(t, IsRecordAt(record[:size-1]))
+	assert.False(t, IsRecordAt(record[:5]))
+
+	// crc 校验失败
+	corrupted := append([]byte{}, record...)
+	corrupted[size-1]++
+	assert.False(t, IsRecordAt(corrupted))
+
+	// 类型字节不合理时不需要计算 crc
+	invalidType := append([]byte{}, record...)
+	invalidType[4] = 0x0f
+	assert.False(t, IsRecordAt(invalidType))
+
+	// 全部是 0 的数据不是记录
+	assert.False(t, IsRecordAt(make([]byte, 64)))
+}
+
+func TestDecodeLogRecordHead_Overflow(t *testing.T) {
+	// 损坏的头部中变长编码溢出
+	buf := []byte{1, 2, 3, 4, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	header, size := DecodeLogRecordHead(buf)
+	assert.Nil(t, header)
+	assert.Equal(t, int64(-1), size)
+}
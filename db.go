@@ -8,14 +8,16 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gofrs/flock"
-	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -32,20 +34,58 @@ type DB struct {
 	index           index.Index               // 内存索引
 	transactionSeq  uint64                    // 事务序列号, 全局递增
 	isMerging       bool                      // 是否正在 merge
+	mergeBoundary   uint32                    // 正在进行或者等待安装的 merge 中, 没有参与 merge 的第一个文件 id
+	mergeDropped    bool                      // 等待安装的 merge 是否通过压缩过滤器删除了 key
+	mergedFileId    uint32                    // 已经安装的 merge 中没有参与 merge 的第一个文件 id, 更小的文件都被重写过
+	mergeStatus     MergeStatus               // 最近一次 merge 的进度
+	mergeStatusLock sync.Mutex                // 保护 mergeStatus, merge 过程中不持有 db.lock
+	cipher          *data.Cipher              // 加密和解密记录, nil 表示不加密
+	fingerprint     uint64                    // 影响数据格式的配置项的指纹, 写入新文件的文件头中
 	seqNoFileExists bool                      // 存储事务序列号的文件是否存在
 	isInitial       bool                      // 是否是第一次初始化此数据目录
 	fileLock        *flock.Flock              // 文件锁:确保多个进程之间的互斥
 	byteWrite       uint                      // 表示数据库已经写入的字节数
 	reclaimSize     int64                     // 表示有多少数据是无效的
+	snapshots       map[uint64]*Snapshot      // 存活的快照
+	snapshotSeq     uint64                    // 快照 id, 全局递增
+	fileRefs        map[uint32]int            // 数据文件被快照引用的次数
+	subscriptions   map[uint64]*Subscription  // 变更订阅
+	subscriptionSeq uint64                    // 订阅 id, 全局递增
+	txnWatches      map[string]*txnWatch      // 被还没有结束的事务读取过的 key 的版本号
+	txnWatchLock    sync.Mutex                // 保护 txnWatches, 事务读取时只持有 db.lock 的读锁
+	mergeStop       chan struct{}             // 通知后台 merge 退出
+	mergeWg         sync.WaitGroup            // 等待后台 merge 退出
+	writeLock       sync.Mutex                // 保护组提交的写入队列
+	writeCond       *sync.Cond                // 唤醒组提交队列中等待的写入
+	writers         []*writeRequest           // 组提交的写入队列, 第一个是正在写入的 leader
+	files           atomic.Pointer[fileSet]   // 读取时可见的数据文件, 读取不需要持有 db.lock
+	fileSetLock     sync.Mutex                // 保护 retiredFiles
+	retiredFiles    []*fileSet                // 按照发布顺序排列的旧的数据文件集合, 其中被替换掉的文件还没有关闭
+	relocateSeq     atomic.Uint64             // 增量压缩移动数据时递增, 为奇数表示正在移动数据
 	lock            *sync.RWMutex
 }
 
 // Stat 存储数据库引擎状态
 type Stat struct {
-	KeyNum          uint  // key 的总数
-	DataFileNum     uint  // 数据文件总数
-	ReclaimableSize int64 // 可以进行 merge 回收的数据量, 单位为字节
-	DiskSize        int64 // 数据目录所占用磁盘空间的大小
+	KeyNum          uint        // key 的总数
+	DataFileNum     uint        // 数据文件总数
+	ReclaimableSize int64       // 可以进行 merge 回收的数据量, 单位为字节
+	DiskSize        int64       // 数据目录所占用磁盘空间的大小
+	LogicalSize     int64       // 数据文件中的记录不压缩时的大小, 不知道的部分按照实际大小计算
+	CompressedSize  int64       // 数据文件中的记录实际占用的大小
+	Merge           MergeStatus // 最近一次 merge 的进度
+}
+
+// MergeStatus merge 的进度
+type MergeStatus struct {
+	Running        bool      // 是否正在 merge
+	StartTime      time.Time // merge 开始的时间
+	TotalFiles     int       // 参与 merge 的数据文件总数
+	FilesDone      int       // 已经处理完的数据文件数量
+	BytesRead      int64     // 已经读取的数据量, 单位为字节
+	BytesRewritten int64     // 重写到新数据文件中的有效数据量, 单位为字节
+	BytesReclaimed int64     // 已经处理完的数据文件中回收的无效数据量, 单位为字节
+	Error          string    // merge 结束时的错误信息, 为空表示成功
 }
 
 // Open 启动数据库
@@ -53,7 +93,7 @@ type Stat struct {
 // 1. 校验数据库配置
 // 2. 加载数据目录中的文件
 // 3. 遍历数据文件中的内容构建内存索引
-func Open(options Options) (*DB, error) {
+func Open(options Options) (_ *DB, err error) {
 	// 对用户传入的配置进行校验
 	if err := checkOptions(options); err != nil {
 		return nil, err
@@ -78,6 +118,15 @@ func Open(options Options) (*DB, error) {
 		return nil, ErrDatabaseIsUsing
 	}
 
+	var db *DB
+	defer func() {
+		// 启动失败时释放已经打开的资源, 之后才能再次打开数据目录
+		if err != nil {
+			db.releaseOnOpenError()
+			_ = fileLock.Unlock()
+		}
+	}()
+
 	entries, err := os.ReadDir(options.DirPath)
 	if err != nil {
 		return nil, err
@@ -87,47 +136,74 @@ func Open(options Options) (*DB, error) {
 	}
 
 	// 初始化 DB 实例结构体
-	db := &DB{
+	db = &DB{
 		options:    options,
 		lock:       new(sync.RWMutex),
 		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
 		isInitial:  isInitial,
 		fileLock:   fileLock,
+		snapshots:  make(map[uint64]*Snapshot),
+		fileRefs:   make(map[uint32]int),
+		txnWatches: make(map[string]*txnWatch),
+
+		subscriptions: make(map[uint64]*Subscription),
+	}
+	db.writeCond = sync.NewCond(&db.writeLock)
+	// 配置了密钥时加密所有写入的记录
+	keyProvider := options.KeyProvider
+	if options.EncryptionKey != nil {
+		keyProvider = &KeyRing{Keys: map[uint32][]byte{0: options.EncryptionKey}}
 	}
+	db.cipher = data.NewCipher(keyProvider)
+	db.fingerprint = optionsFingerprint(options)
 	// 加载 merge 数据目录
-	//if err = db.loadMergeFiles(); err != nil {
-	//	return nil, err
-	//}
+	if err = db.loadMergeFiles(); err != nil {
+		return nil, err
+	}
+	// 已经安装的 merge 重写过的文件范围, 恢复订阅时不能从这个范围内的位置开始
+	if _, err = os.Stat(filepath.Join(options.DirPath, data.MergeFinishedFileName)); err == nil {
+		if db.mergedFileId, err = db.getNonMergeFileId(options.DirPath); err != nil {
+			return nil, err
+		}
+	}
+
+	// B+树的索引文件存在时不需要从数据文件中加载索引
+	// 第一次打开或者 merge 安装之后索引文件不存在, 和其他索引一样加载
+	loadIndex := options.IndexType != BPlusTree
+	if !loadIndex {
+		_, statErr := os.Stat(filepath.Join(options.DirPath, index.BPlusTreeIndexFileName))
+		loadIndex = os.IsNotExist(statErr)
+	}
+	db.index = index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites, options.IndexShards)
 
 	// 加载数据文件
 	if err = db.loadDataFile(); err != nil {
 		return nil, err
 	}
 
-	// B+树不需要从数据文件中加载索引
-	if options.IndexType != BPlusTree {
-		//  从 hint 索引文件中加载 内存索引
-		if err = db.loadIndexFromDataFiles(); err != nil {
+	// 取出当前的事务序列号
+	if options.IndexType == BPlusTree {
+		if err = db.loadSeqNo(); err != nil {
 			return nil, err
 		}
-		// 从数据文件中加载索引
-		if err = db.loadIndexFromDataFiles(); err != nil {
+	}
+	if loadIndex {
+		// 从 hint 索引文件中加载参与过 merge 的数据的索引
+		if err = db.loadIndexFromHintFile(); err != nil {
 			return nil, err
 		}
-	}
-	// 取出当前的事务序列号
-	if options.IndexType == BPlusTree {
-		if err = db.loadSeqNo(); err != nil {
+		// 从数据文件中加载没有参与 merge 的数据的索引
+		if err = db.loadIndexFromDataFiles(); err != nil {
 			return nil, err
 		}
-		if db.activeFile != nil {
-			size, err := db.activeFile.IoManager.Size()
-			if err != nil {
-				return nil, err
-			}
-			db.activeFile.WriteOff = size
+		db.seqNoFileExists = true
+	} else if db.activeFile != nil {
+		// 索引已经持久化, 只需要检查活跃文件末尾是否有不完整的记录
+		offset, err := db.readDataFile(db.activeFile, func(*data.LogRecord, *data.LogRecordPos) {})
+		if err != nil {
+			return nil, err
 		}
+		db.activeFile.WriteOff = offset
 	}
 
 	// 重置 IO 类型为标准文件 IO
@@ -137,9 +213,28 @@ func Open(options Options) (*DB, error) {
 		}
 	}
 
+	// 启动后台 merge
+	db.startMergeScheduler()
+
 	return db, nil
 }
 
+// releaseOnOpenError 启动失败时关闭已经打开的索引和数据文件
+func (db *DB) releaseOnOpenError() {
+	if db == nil {
+		return
+	}
+	if db.index != nil {
+		_ = db.index.Close()
+	}
+	if db.activeFile != nil {
+		_ = db.activeFile.Close()
+	}
+	for _, file := range db.olderFiles {
+		_ = file.Close()
+	}
+}
+
 // Close 方法
 func (db *DB) Close() error {
 	defer func() {
@@ -149,6 +244,11 @@ func (db *DB) Close() error {
 		}
 	}()
 
+	// 停止后台 merge, 关闭所有的订阅, 释放所有存活的快照
+	db.stopMergeScheduler()
+	db.closeSubscriptions()
+	db.closeSnapshots()
+
 	// 关闭索引
 	if err := db.index.Close(); err != nil {
 		return err
@@ -160,17 +260,27 @@ func (db *DB) Close() error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	// 保存当前的事务序列号
+	// 保存当前的事务序列号, 重新创建文件, 文件中只有一条记录
+	seqNoFileName := filepath.Join(db.options.DirPath, data.SeqNoFileName)
+	if err := os.Remove(seqNoFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
 	defer seqNoFile.Close()
 	if err != nil {
 		return err
 	}
+	if err = seqNoFile.InitHeader(db.fingerprint); err != nil {
+		return err
+	}
 	record := &data.LogRecord{
 		Key:   []byte(seqNoKey),
 		Value: []byte(strconv.FormatUint(db.transactionSeq, 10)),
 	}
-	logRecord, _ := data.EncodeLogRecord(record)
+	logRecord, _, err := db.cipher.EncodeLogRecord(record)
+	if err != nil {
+		return err
+	}
 	if err = seqNoFile.Write(logRecord); err != nil {
 		return err
 	}
@@ -188,6 +298,8 @@ func (db *DB) Close() error {
 			return err
 		}
 	}
+	// 关闭增量压缩替换掉, 但是还没有关闭的数据文件
+	db.closeAllRetiredFiles()
 
 	return nil
 }
@@ -214,6 +326,13 @@ func (db *DB) loadDataFile() error {
 	var fileIds []int
 	// 遍历目录中的所有文件,找到所有以 .data 结尾的文件
 	for _, entry := range dirEntries {
+		// 压缩数据文件时没有完成的临时文件, 原来的数据文件还在, 直接删除
+		if strings.HasSuffix(entry.Name(), data.CompactFileSuffix) {
+			if err := os.Remove(filepath.Join(db.options.DirPath, entry.Name())); err != nil {
+				return err
+			}
+			continue
+		}
 		// 判断文件是否以 .data 结尾
 		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
 			splitNames := strings.Split(entry.Name(), ".")
@@ -232,22 +351,29 @@ func (db *DB) loadDataFile() error {
 
 	// 遍历每个文件id,打开对应的数据文件
 	for i, fid := range fileIds {
-		// 数据库启动加载数据文件的时候,使用 MMap 方式打开数据文件
-		ioType := fio.StandardFIO
-		if db.options.MMapStartup {
-			ioType = fio.MemoryMapFIO
+		ioType := db.startupIOType()
+		if i == len(fileIds)-1 {
+			if err := db.truncateTornHeader(uint32(fid)); err != nil {
+				return err
+			}
 		}
-
 		datafile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioType)
 		if err != nil {
 			return err
 		}
+		datafile.Cipher = db.cipher
+		// 旧的数据文件不会再写入, 文件大小就是写入的位置
+		if datafile.WriteOff, err = datafile.IoManager.Size(); err != nil {
+			return err
+		}
 		if i == len(fileIds)-1 {
+			datafile.Preallocate(db.options.DataFileSize)
 			db.activeFile = datafile
 		} else {
 			db.olderFiles[uint32(fid)] = datafile
 		}
 	}
+	db.publishFiles()
 	return nil
 }
 
@@ -260,10 +386,11 @@ func (db *DB) loadIndexFromDataFiles() error {
 		return nil
 	}
 
-	// 查看是否发生过 Merge
+	// 查看是否发生过 Merge, 增量压缩之后 hint 文件被删除, 所有的数据文件都需要加载
 	isMerge, nonMergeFileId := false, uint32(0)
 	mergerFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
-	if _, err := os.Stat(mergerFinFileName); err == nil {
+	_, hintErr := os.Stat(filepath.Join(db.options.DirPath, data.HintFileName))
+	if _, err := os.Stat(mergerFinFileName); err == nil && hintErr == nil {
 		fid, err := db.getNonMergeFileId(db.options.DirPath)
 		if err != nil {
 			return err
@@ -272,16 +399,29 @@ func (db *DB) loadIndexFromDataFiles() error {
 		nonMergeFileId = fid
 	}
 
-	updateMemoryIndex := func(key []byte, recordType data.LogRecordType, pos *data.LogRecordPos) {
+	now := time.Now().UnixNano()
+	updateMemoryIndex := func(key []byte, logRecord *data.LogRecord, pos *data.LogRecordPos) {
+		recordType := logRecord.Type
+		// 范围删除, 删除之前加载的范围内所有的 key
+		if recordType == data.LogRecordRangeDeleted {
+			db.deleteRangeIndex(key, logRecord.Value)
+			db.markDead(pos)
+			return
+		}
+
 		var oldPos *data.LogRecordPos
-		if recordType == data.LogRecordDeleted {
+		// 已经过期的数据和删除的数据一样处理
+		if recordType == data.LogRecordDeleted || pos.IsExpired(now) {
 			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += int64(pos.Size) // 删除数据这条记录的大小,也是需要记录的
+			db.markDead(pos) // 删除数据这条记录的大小,也是需要记录的
+		} else if recordType == data.LogRecordOperand {
+			// 之前的记录还在操作数链表中, 不能计入可回收空间
+			db.index.Put(key, pos)
 		} else {
 			oldPos = db.index.Put(key, pos)
 		}
 		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size)
+			db.markDead(oldPos)
 		}
 	}
 
@@ -306,38 +446,20 @@ func (db *DB) loadIndexFromDataFiles() error {
 		} else {
 			dataFile = db.olderFiles[fileId]
 		}
-		// 偏移量
-		var offset int64 = 0
-		for {
-			// 读取日志记录,返回的日志记录和记录大小
-			// todo 重点理解这块儿
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			if err != nil {
-				if errors.Is(err, io.EOF) {
-					break
-				}
-				return err
-			}
-
-			// 构造内存索引并保存
-			logRecordPos := &data.LogRecordPos{
-				Fid:    fileId,
-				Offset: offset,
-				Size:   uint32(size),
-			}
-
+		// 读取文件中的每一条有效记录, 损坏的记录按照 RecoveryMode 处理
+		offset, err := db.readDataFile(dataFile, func(logRecord *data.LogRecord, logRecordPos *data.LogRecordPos) {
 			// 从 LogRecord 中获取 序列号
 			realKey, seqNo := parseLogRecordKey(logRecord.Key)
 
 			// 非事务提交的记录,直接更新内存索引
 			if seqNo == nonTransactionKey {
-				updateMemoryIndex(realKey, logRecord.Type, logRecordPos)
+				updateMemoryIndex(realKey, logRecord, logRecordPos)
 			} else {
 				// 如果是事务完成的记录
 				// 更新内存索引
 				if logRecord.Type == data.LogRecordFinished {
 					for _, txRecord := range tansactionRecord[seqNo] {
-						updateMemoryIndex(txRecord.Record.Key, txRecord.Record.Type, txRecord.Pos)
+						updateMemoryIndex(txRecord.Record.Key, txRecord.Record, txRecord.Pos)
 					}
 					delete(tansactionRecord, seqNo)
 				} else {
@@ -354,9 +476,9 @@ func (db *DB) loadIndexFromDataFiles() error {
 			if seqNo > currentSeq {
 				currentSeq = seqNo
 			}
-
-			// 递增 offset,下一次从新的位置开始
-			offset += size
+		})
+		if err != nil {
+			return err
 		}
 
 		// 如果是当前活跃文件，更新这个文件的 Write0ff
@@ -364,8 +486,10 @@ func (db *DB) loadIndexFromDataFiles() error {
 			db.activeFile.WriteOff = offset
 		}
 	}
-	// 更新事务序列号
-	db.transactionSeq = currentSeq
+	// 更新事务序列号, merge 之后的数据文件中没有事务序列号, 不能让序列号变小
+	if currentSeq > db.transactionSeq {
+		db.transactionSeq = currentSeq
+	}
 	return nil
 }
 
@@ -382,15 +506,34 @@ func (db *DB) Stat() *Stat {
 	if err != nil {
 		return nil
 	}
-	return &Stat{
+	stat := &Stat{
 		KeyNum:          uint(db.index.Size()),
 		DataFileNum:     dataFiles,
 		ReclaimableSize: db.reclaimSize,
 		DiskSize:        size, // todo
+		Merge:           db.MergeStatus(),
 	}
+	// B+树索引启动时不读取数据文件, 文件不压缩时的大小可能是未知的
+	addFileSize := func(dataFile *data.DataFile) {
+		stat.CompressedSize += dataFile.WriteOff
+		if dataFile.LogicalSize > 0 {
+			stat.LogicalSize += dataFile.LogicalSize
+		} else {
+			stat.LogicalSize += dataFile.WriteOff
+		}
+	}
+	for _, dataFile := range db.olderFiles {
+		addFileSize(dataFile)
+	}
+	if db.activeFile != nil {
+		addFileSize(db.activeFile)
+	}
+	return stat
 }
 
 // Backup 拷贝数据库
+// 只拷贝数据目录下的文件, 不拷贝子目录, 任何一个文件拷贝失败时返回错误
+// 使用 MMapIO 时活跃文件末尾预分配的空间也会被拷贝, 打开备份时和崩溃之后一样截断
 func (db *DB) Backup(dirPath string) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
@@ -405,6 +548,21 @@ func (db *DB) Backup(dirPath string) error {
 //  3. 将日志记录追加写入到当前文件中 appendLogRecord(LogRecord)
 //  4. 更新内存索引(向内存索引执行Put)
 func (db *DB) Put(key []byte, value []byte) error {
+	return db.put(key, value, 0)
+}
+
+// PutWithTTL 写入带有过期时间的 key/value 数据
+// 超过 ttl 之后, Get, Fold, ListKeys 和迭代器都会认为 key 不存在
+// 过期的数据会在 Merge 时被清理
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	return db.put(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+// put 写入数据, expire 为 0 表示永不过期
+func (db *DB) put(key []byte, value []byte, expire int64) error {
 	// 判断key 是否有效
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
@@ -412,43 +570,57 @@ func (db *DB) Put(key []byte, value []byte) error {
 
 	// 构造 LogRecord 结构体
 	logRecord := &data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, nonTransactionKey),
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:    logRecordKeyWithSeq(key, nonTransactionKey),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
+	}
+	// 和其他并发的写入一起组提交, 写入之后更新内存索引
+	return db.commitWrite([]*data.LogRecord{logRecord}, false, func(positions []*data.LogRecordPos) {
+		db.putIndex(key, positions[0])
+	})
+}
+
+// putLocked 写入一条普通记录并更新内存索引, 调用前必须持有 db.lock
+func (db *DB) putLocked(key []byte, value []byte, expire int64) error {
+	// 构造 LogRecord 结构体
+	logRecord := &data.LogRecord{
+		Key:    logRecordKeyWithSeq(key, nonTransactionKey),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
 	}
 
 	// 追加写入到当前活跃数据文件中
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
 	// 更新内存索引
-	if oldVal := db.index.Put(key, pos); oldVal != nil {
-		db.reclaimSize += int64(oldVal.Size)
-	}
+	db.putIndex(key, pos)
 
 	return nil
 }
 
 // Get 根据 key 读取数据
+// 不持有 db.lock, 可以和其他读取以及写入并发执行
 func (db *DB) Get(key []byte) ([]byte, error) {
-	db.lock.Lock()
-	defer db.lock.Unlock()
-
 	// 判断 key 的有效性
 	if len(key) == 0 {
 		return nil, ErrKeyIsEmpty
 	}
 
 	// 从内存数据结构中取出key对应的索引信息
+	// 已经过期的 key 视为不存在
+	seq := db.relocateSeq.Load()
 	logRecordPos := db.index.Get(key)
-	if logRecordPos == nil {
+	if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
 		return nil, ErrKeyNotFound
 	}
 
-	return db.getValueByPosition(logRecordPos)
+	return db.getValue(key, logRecordPos, seq)
 }
 
 // ListKeys 获取数据库中所有的 key
@@ -457,26 +629,38 @@ func (db *DB) ListKeys() [][]byte {
 	defer db.lock.RUnlock()
 
 	iterator := db.index.Iterator(false)
-	keys := make([][]byte, db.index.Size())
-	idx := 0
+	defer iterator.Close()
+	now := time.Now().UnixNano()
+	keys := make([][]byte, 0, db.index.Size())
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		// 跳过已经过期的 key
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
+		keys = append(keys, iterator.Key())
 	}
 	return keys
 }
 
 // Fold 获取所有的数据,并且执行用户指定的操作, 函数返回 false 时终止遍历
+// 不持有 db.lock, 遍历过程中的写入可能看得到也可能看不到
 func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
-	db.lock.RLock()
-	defer db.lock.RUnlock()
-
 	// 遍历内存索引
+	seq := db.relocateSeq.Load()
 	iterator := db.index.Iterator(false)
 	defer iterator.Close()
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		// 跳过已经过期的 key
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
 		// 根据value的位置信息,从数据文件中获取数据
-		value, err := db.getValueByPosition(iterator.Value())
+		value, err := db.getValue(iterator.Key(), iterator.Value(), seq)
+		if err == ErrKeyNotFound {
+			// 遍历过程中 key 被删除
+			continue
+		}
 		if err != nil {
 			return err
 		}
@@ -489,14 +673,36 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 
 // getValueByPosition 根据位置信息获取数据
 func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
-	// 根据文件, id 找到对应的数据文件
-	var dataFile *data.DataFile
-	if db.activeFile.FileID == logRecordPos.Fid {
-		dataFile = db.activeFile
-	} else {
-		dataFile = db.olderFiles[logRecordPos.Fid]
+	return db.readValue(db.getDataFile, logRecordPos)
+}
+
+// getDataFile 根据文件 id 找到对应的数据文件, 找不到时返回 nil
+func (db *DB) getDataFile(fid uint32) *data.DataFile {
+	if db.activeFile != nil && db.activeFile.FileID == fid {
+		return db.activeFile
+	}
+	return db.olderFiles[fid]
+}
+
+// readValue 根据位置信息读取 value, getDataFile 用来根据文件 id 找到对应的数据文件
+// 如果读到的是合并操作数, 会沿着操作数链向前读取, 再用合并操作符计算出最终的值
+func (db *DB) readValue(getDataFile func(fid uint32) *data.DataFile, logRecordPos *data.LogRecordPos) ([]byte, error) {
+	logRecord, err := readLogRecord(getDataFile(logRecordPos.Fid), logRecordPos)
+	if err != nil {
+		return nil, err
+	}
+
+	switch logRecord.Type {
+	case data.LogRecordDeleted:
+		return nil, ErrDataCountDeleted
+	case data.LogRecordOperand:
+		return db.foldOperands(getDataFile, logRecord)
 	}
+	return logRecord.Value, nil
+}
 
+// readLogRecord 根据位置信息从数据文件中读取日志记录
+func readLogRecord(dataFile *data.DataFile, logRecordPos *data.LogRecordPos) (*data.LogRecord, error) {
 	// 数据文件为空
 	if dataFile == nil {
 		return nil, ErrDataFileNotFound
@@ -507,12 +713,7 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 	if err != nil {
 		return nil, err
 	}
-
-	if logRecord.Type == data.LogRecordDeleted {
-		return nil, ErrDataCountDeleted
-	}
-
-	return logRecord.Value, nil
+	return logRecord, nil
 }
 
 // Delete 删除数据
@@ -523,37 +724,76 @@ func (db *DB) Delete(key []byte) error {
 	}
 
 	// 检查key是否存在
-	if pos := db.index.Get(key); pos == nil {
+	db.lock.RLock()
+	pos := db.index.Get(key)
+	db.lock.RUnlock()
+	if pos == nil {
 		return nil
 	}
 
+	// 构造 LogRecord, 标识类型是被删除的
+	logRecord := &data.LogRecord{
+		Key:  logRecordKeyWithSeq(key, nonTransactionKey),
+		Type: data.LogRecordDeleted,
+	}
+	// 和其他并发的写入一起组提交, key 在排队的过程中可能已经被删除了, 多写一条删除记录不影响结果
+	return db.commitWrite([]*data.LogRecord{logRecord}, false, func(positions []*data.LogRecordPos) {
+		db.markDead(positions[0])
+		db.deleteIndex(key)
+	})
+}
+
+// deleteLocked 写入一条删除记录并从内存索引中删除 key, 调用前必须持有 db.lock
+func (db *DB) deleteLocked(key []byte) error {
 	// 构造 LogRecord, 标识类型是被删除的
 	logRecord := &data.LogRecord{
 		Key:  logRecordKeyWithSeq(key, nonTransactionKey),
 		Type: data.LogRecordDeleted,
 	}
 	// 写入到数据文件中
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
-		return nil
+		return err
 	}
-	db.reclaimSize += int64(pos.Size) // 将 Delete 这条日志记录的 Size 加入到回收空间中
+	db.markDead(pos) // 将 Delete 这条日志记录的 Size 加入到回收空间中
 
 	// 从内存索引中删除对应的 Key
-	oldPos, ok := db.index.Delete(key)
-	if !ok {
+	if _, ok := db.deleteIndex(key); !ok {
 		return ErrIndexUpdateFailed
 	}
+	return nil
+}
+
+// putIndex 更新内存索引, 并累计被覆盖的旧数据大小
+// 调用前必须持有 db.lock
+func (db *DB) putIndex(key []byte, pos *data.LogRecordPos) {
+	// 在修改索引之前, 为存活的快照保留 key 原来的位置
+	db.saveSnapshotOverlay(key)
+	db.bumpKeyVersion(key)
+	if oldPos := db.index.Put(key, pos); oldPos != nil {
+		db.markDead(oldPos)
+	}
+}
+
+// deleteIndex 从内存索引中删除 key, 并累计被删除的旧数据大小
+// 调用前必须持有 db.lock
+func (db *DB) deleteIndex(key []byte) (*data.LogRecordPos, bool) {
+	db.saveSnapshotOverlay(key)
+	db.bumpKeyVersion(key)
+	oldPos, ok := db.index.Delete(key)
 	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+		db.markDead(oldPos)
 	}
-	return nil
+	return oldPos, ok
 }
 
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.lock.Lock()
-	defer db.lock.Unlock()
-	return db.appendLogRecord(logRecord)
+// markDead 将 pos 指向的记录计入可回收空间, 同时累计到所在数据文件的无效数据中
+// 调用前必须持有 db.lock
+func (db *DB) markDead(pos *data.LogRecordPos) {
+	db.reclaimSize += int64(pos.Size)
+	if dataFile := db.getDataFile(pos.Fid); dataFile != nil {
+		dataFile.DeadSize += int64(pos.Size)
+	}
 }
 
 // appendLogRecord 追加写数据到活跃文件中
@@ -566,64 +806,19 @@ func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecor
 //     2.1 若大于,保存活跃文件,然后打开新的活跃文件
 //  4. 向活跃文件中写入内容 Write()
 //  5. 返回内存索引
+//
+// 调用前必须持有 db.lock, 不经过组提交的队列
 func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	// 判断当前活跃数据文件是否存在,因为数据库在没有写入的时候是没有文件生成的
-	// 活跃文件为空则初始化数据文件
-	if db.activeFile == nil {
-		if err := db.setActiveDataFile(); err != nil {
-			return nil, err
-		}
-	}
-
-	// 写入数据编码
-	encodeRecord, size := data.EncodeLogRecord(logRecord)
-
-	// 如果写入数据已经到达了活跃文件的阈值,则关闭活跃文件,并打开新文件
-	if db.activeFile.WriteOff+size > db.options.DataFileSize {
-		// 先持久化数据文件,保证已有的数据持久化到磁盘当中
-		if err := db.activeFile.Sync(); err != nil {
-			return nil, err
-		}
-
-		// 当前活跃文件转换为旧的数据文件
-		db.olderFiles[db.activeFile.FileID] = db.activeFile
-
-		// 打开新的数据文件
-		if err := db.setActiveDataFile(); err != nil {
-			return nil, err
-		}
-	}
-
-	writeOff := db.activeFile.WriteOff
-	if err := db.activeFile.Write(encodeRecord); err != nil {
+	// 写入数据编码, value 按照配置的算法压缩, 配置了密钥时加密
+	records, _, err := db.encodeRecords([]*data.LogRecord{logRecord})
+	if err != nil {
 		return nil, err
 	}
-
-	db.byteWrite += uint(size)
-	// 根据用户配置决定是否每次写入持久化
-	// 如果打开了每次写入持久化, 则根据写入字节的持久化策略就失效
-	needSync := db.options.SyncWrites
-	if !needSync && db.options.BytesPerSync > 0 && db.byteWrite >= db.options.BytesPerSync {
-		needSync = true
-	}
-
-	if needSync {
-		if err := db.activeFile.Sync(); err != nil {
-			return nil, err
-		}
-		// 清空累计值
-		if db.byteWrite > 0 {
-			db.byteWrite = 0
-		}
-	}
-
-	// 构造内存索引信息
-	pos := &data.LogRecordPos{
-		Fid:    db.activeFile.FileID,
-		Offset: writeOff,
-		Size:   uint32(size),
+	positions, err := db.writeRecords(records, false)
+	if err != nil {
+		return nil, err
 	}
-	return pos, nil
+	return positions[0], nil
 }
 
 // setActiveDataFile 设置当前活跃文件
@@ -638,15 +833,22 @@ func (db *DB) setActiveDataFile() error {
 	if db.activeFile != nil {
 		// 活跃文件的 id 为数据库目前的活跃文件 id + 1
 		initialFileId = db.activeFile.FileID + 1
+		// 之前的活跃文件不会再写入, 截断预分配的空间
+		if err := db.activeFile.Trim(); err != nil {
+			return err
+		}
 	}
 	// 打开最新的数据文件
 	// 传入数据库配置中的路径,已经刚才初始化好的文件id
-	// 使用标准文件IO打开数据文件
-	file, err := data.OpenDataFile(db.options.DirPath, initialFileId, fio.StandardFIO)
+	// 使用配置的 IO 类型打开数据文件
+	file, err := data.OpenDataFile(db.options.DirPath, initialFileId, db.options.IOType)
 	if err != nil {
 		return err
 	}
+	file.Cipher = db.cipher
+	file.Preallocate(db.options.DataFileSize)
 	db.activeFile = file
+	db.publishFiles()
 	return nil
 }
 
@@ -658,9 +860,41 @@ func checkOptions(options Options) error {
 	if options.DataFileSize <= 0 {
 		return errors.New("database datafileSize must >= 0")
 	}
+	// 事件序列号中只有 32 位用来保存文件偏移量
+	if options.DataFileSize > math.MaxUint32 {
+		return errors.New("database datafileSize must <= 4GB")
+	}
 	if options.MergeThreshold < 0 || options.MergeThreshold > 1 {
 		return errors.New("database mergeThreshold must >= 0 and <= 1")
 	}
+	if options.CompactMaxFiles < 0 {
+		return errors.New("database compactMaxFiles must >= 0")
+	}
+	if options.MergeCheckInterval < 0 {
+		return errors.New("database mergeCheckInterval must >= 0")
+	}
+	if options.MergeWindowStart < 0 || options.MergeWindowStart >= 24*time.Hour ||
+		options.MergeWindowEnd < 0 || options.MergeWindowEnd >= 24*time.Hour {
+		return errors.New("database merge window must >= 0 and < 24h")
+	}
+	if options.IndexType == Sharded && options.IndexShards <= 0 {
+		return errors.New("database indexShards must > 0")
+	}
+	if options.IOType != StandardIO && options.IOType != MMapIO {
+		return errors.New("database io type must be StandardIO or MMapIO")
+	}
+	if options.RecoveryMode != TolerateCorruptedTail && options.RecoveryMode != SkipCorruptedRecords {
+		return errors.New("database recovery mode is unknown")
+	}
+	if options.Compression > Zstd {
+		return errors.New("database compression type is unknown")
+	}
+	if options.EncryptionKey != nil && options.KeyProvider != nil {
+		return errors.New("database encryptionKey and keyProvider can not be set at the same time")
+	}
+	if n := len(options.EncryptionKey); options.EncryptionKey != nil && n != 16 && n != 24 && n != 32 {
+		return errors.New("database encryptionKey must be 16, 24 or 32 bytes")
+	}
 	return nil
 }
 
@@ -673,30 +907,48 @@ func (db *DB) loadSeqNo() error {
 	if err != nil {
 		return err
 	}
-	record, _, err := seqNoFile.ReadLogRecord(0)
-	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
+	seqNoFile.Cipher = db.cipher
+	// 密钥不对时解密失败, 文件头或者记录不完整时读取失败, 都不会返回记录
+	record, _, err := seqNoFile.ReadLogRecord(seqNoFile.HeaderSize())
 	if err != nil {
+		_ = seqNoFile.Close()
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+	if err = seqNoFile.Close(); err != nil {
 		return err
 	}
+	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
 	db.transactionSeq = seqNo
 	db.seqNoFileExists = true
 
 	return os.Remove(fileName)
 }
 
-// 将数据文件的 IO 类型设置为标准IO
+// startupIOType 启动时加载数据文件使用的 IO 类型, 开启 MMapStartup 时使用只读的 MMap 方式打开数据文件
+func (db *DB) startupIOType() fio.FileIOType {
+	if db.options.MMapStartup {
+		return fio.MemoryMapFIO
+	}
+	return db.options.IOType
+}
+
+// 将数据文件的 IO 类型设置为配置的 IO 类型
 func (db *DB) resetIoType() error {
 	if db.activeFile == nil {
 		return nil
 	}
 	// 重置活跃文件的 IO 类型
-	if err := db.activeFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+	if err := db.activeFile.SetIOManager(db.options.DirPath, db.options.IOType); err != nil {
 		return err
 	}
+	db.activeFile.Preallocate(db.options.DataFileSize)
 
 	// 重置旧的数据文件IO 类型
 	for _, dataFile := range db.olderFiles {
-		if err := dataFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+		if err := dataFile.SetIOManager(db.options.DirPath, db.options.IOType); err != nil {
 			return err
 		}
 	}
@@ -1,6 +1,7 @@
 package GoKeeper
 
 import (
+	"GoKeeper/fio"
 	"GoKeeper/util"
 	"fmt"
 	"github.com/stretchr/testify/assert"
@@ -25,6 +26,7 @@ func destroyDB(db *DB) {
 		if err != nil {
 			panic(err)
 		}
+		_ = os.RemoveAll(db.getMergePath())
 	}
 }
 
@@ -506,3 +508,215 @@ func TestDB_GetPut(t *testing.T) {
 	assert.Nil(t, err)
 	t.Log(string(get))
 }
+
+func TestDB_PutWithTTL(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-ttl")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1. ttl 不合法
+	err = db.PutWithTTL(util.GetRandomKey(1), util.GetRandomValue(10), 0)
+	assert.Equal(t, ErrInvalidTTL, err)
+
+	// 2. 过期之前可以正常读取
+	err = db.PutWithTTL(util.GetRandomKey(1), util.GetRandomValue(10), 100*time.Millisecond)
+	assert.Nil(t, err)
+	err = db.Put(util.GetRandomKey(2), util.GetRandomValue(10))
+	assert.Nil(t, err)
+	val, err := db.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	assert.Equal(t, 2, len(db.ListKeys()))
+
+	// 3. 过期之后 Get, ListKeys, Fold, Iterator 都认为 key 不存在
+	time.Sleep(150 * time.Millisecond)
+	_, err = db.Get(util.GetRandomKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	keys := db.ListKeys()
+	assert.Equal(t, [][]byte{util.GetRandomKey(2)}, keys)
+
+	var folded [][]byte
+	err = db.Fold(func(key []byte, value []byte) bool {
+		folded = append(folded, key)
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{util.GetRandomKey(2)}, folded)
+
+	iterator := db.NewIterator(DefaultIteratorOption)
+	var iterated [][]byte
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		iterated = append(iterated, iterator.Key())
+	}
+	iterator.Close()
+	assert.Equal(t, [][]byte{util.GetRandomKey(2)}, iterated)
+
+	// 4. 重新写入之后不再过期
+	err = db.Put(util.GetRandomKey(1), util.GetRandomValue(10))
+	assert.Nil(t, err)
+	_, err = db.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+
+	// 5. 重启之后过期时间依然有效
+	err = db.PutWithTTL(util.GetRandomKey(3), util.GetRandomValue(10), 100*time.Millisecond)
+	assert.Nil(t, err)
+	err = db.PutWithTTL(util.GetRandomKey(4), util.GetRandomValue(10), time.Hour)
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	time.Sleep(150 * time.Millisecond)
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	_, err = db2.Get(util.GetRandomKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db2.Get(util.GetRandomKey(4))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	_, err = db2.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(db2.ListKeys()))
+}
+
+func TestOpen_MMapIO(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "goKeeper-mmap-io")
+	defer os.RemoveAll(dir)
+	backupDir := dir + "-backup"
+	defer os.RemoveAll(backupDir)
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IOType = MMapIO
+	opts.MMapStartup = false
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(util.GetRandomKey(i), util.GetRandomValue(64)))
+	}
+	val, err := db.Get(util.GetRandomKey(10))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// 1. 运行中拷贝的数据文件末尾有预分配的空间, 和崩溃之后一样, 启动时截断
+	assert.Nil(t, db.Backup(backupDir))
+	assert.Nil(t, db.Close())
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.Empty(t, report.Issues)
+
+	backupOpts := opts
+	backupOpts.DirPath = backupDir
+	backupDB, err := Open(backupOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2000, len(backupDB.ListKeys()))
+	assert.Nil(t, backupDB.Put(util.GetRandomKey(2000), util.GetRandomValue(64)))
+	assert.Nil(t, backupDB.Close())
+	report, err = Verify(backupDir)
+	assert.Nil(t, err)
+	assert.Empty(t, report.Issues)
+
+	// 2. 启动时使用只读的 mmap 加载, 之后切换为可读写的 mmap
+	opts.MMapStartup = true
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(util.GetRandomKey(2000), util.GetRandomValue(64)))
+	assert.Equal(t, 2001, len(db.ListKeys()))
+	assert.Nil(t, db.Close())
+
+	// 3. 只读的 mmap 不能作为启动之后的 IO 类型
+	opts.IOType = fio.MemoryMapFIO
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestOpen_ShardedIndex(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "goKeeper-sharded")
+	defer os.RemoveAll(dir)
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.IndexType = Sharded
+	opts.IndexShards = 0
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+
+	opts.IndexShards = 4
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 999; i >= 0; i-- {
+		assert.Nil(t, db.Put(util.GetRandomKey(i), util.GetRandomValue(16)))
+	}
+	assert.Nil(t, db.Delete(util.GetRandomKey(0)))
+
+	// 所有分片合并之后的 key 是有序的
+	keys := db.ListKeys()
+	assert.Equal(t, 999, len(keys))
+	for i := 1; i < len(keys); i++ {
+		assert.True(t, string(keys[i-1]) < string(keys[i]))
+	}
+	iterator := db.NewIterator(IteratorOption{Reverse: true, Prefix: []byte("GoKeeper-key-99")})
+	var count int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		count++
+	}
+	iterator.Close()
+	assert.Equal(t, 11, count)
+	assert.Nil(t, db.Close())
+
+	// 重启之后从数据文件中重新加载所有分片
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, keys, db.ListKeys())
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_BackupMMapIO(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "goKeeper-backup-mmap")
+	defer os.RemoveAll(dir)
+	backupDir := dir + "-backup"
+	defer os.RemoveAll(backupDir)
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	opts.IOType = MMapIO
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	// 数据文件的数量超过拷贝时的队列长度和 worker 的数量, 每个文件都需要拷贝到备份中
+	expected := make(map[int][]byte)
+	for i := 0; i < 5000; i++ {
+		expected[i] = util.GetRandomValue(64)
+		assert.Nil(t, db.Put(util.GetRandomKey(i), expected[i]))
+	}
+	assert.Greater(t, len(db.olderFiles), 100)
+	assert.Nil(t, db.Backup(backupDir))
+	assert.Nil(t, db.Close())
+
+	// 运行中拷贝的活跃文件末尾只有预分配的空间, 不是损坏的数据
+	report, err := Verify(backupDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(report.Issues))
+	assert.Equal(t, VerifyWarning, report.Issues[0].Severity)
+	assert.Equal(t, "zero-tail", report.Issues[0].Kind)
+
+	backupOpts := opts
+	backupOpts.DirPath = backupDir
+	backupDB, err := Open(backupOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, len(expected), len(backupDB.ListKeys()))
+	checkValues(t, backupDB, expected)
+
+	// 启动时截断预分配的空间之后, 备份和原来的数据库一样完整
+	assert.Nil(t, backupDB.Close())
+	report, err = Verify(backupDir)
+	assert.Nil(t, err)
+	assert.Empty(t, report.Issues)
+	backupDB, err = Open(backupOpts)
+	assert.Nil(t, err)
+	checkValues(t, backupDB, expected)
+	destroyDB(backupDB)
+}
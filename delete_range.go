@@ -0,0 +1,73 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"bytes"
+)
+
+// deleteRangeBatchSize 删除范围内的 key 时每次从索引中取出的 key 的数量
+const deleteRangeBatchSize = 1024
+
+// DeleteRange 删除 [start, end) 范围内所有的 key, start 为空表示没有下界, end 为空表示没有上界
+// 只会写入一条范围删除记录, 范围内的 key 会立即从内存索引中删除, 占用的空间在 Merge 时回收
+func (db *DB) DeleteRange(start []byte, end []byte) error {
+	if len(start) != 0 && len(end) != 0 && bytes.Compare(start, end) >= 0 {
+		return ErrInvalidRange
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	// 构造 LogRecord, key 为范围的下界, value 为范围的上界
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeq(start, nonTransactionKey),
+		Value: end,
+		Type:  data.LogRecordRangeDeleted,
+	}
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	db.markDead(pos)
+
+	db.deleteRangeIndex(start, end)
+	return nil
+}
+
+// DeletePrefix 删除所有以 prefix 为前缀的 key
+func (db *DB) DeletePrefix(prefix []byte) error {
+	if len(prefix) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.DeleteRange(prefix, prefixUpperBound(prefix))
+}
+
+// deleteRangeIndex 从内存索引中删除 [start, end) 范围内所有的 key
+// 每次只取出一批 key, 关闭迭代器之后再删除, 避免一次性占用太多内存
+// 调用前必须持有 db.lock
+func (db *DB) deleteRangeIndex(start []byte, end []byte) {
+	for {
+		keys := make([][]byte, 0, deleteRangeBatchSize)
+		iterator := db.index.RangeIterator(false, start, end)
+		for iterator.Rewind(); iterator.Valid() && len(keys) < deleteRangeBatchSize; iterator.Next() {
+			// B+ 树迭代器返回的 key 在迭代器关闭之后失效, 需要拷贝一份
+			keys = append(keys, bytes.Clone(iterator.Key()))
+		}
+		iterator.Close()
+
+		for _, key := range keys {
+			db.deleteIndex(key)
+		}
+		if len(keys) < deleteRangeBatchSize {
+			return
+		}
+	}
+}
+
+// rangeOverlaps 判断两个范围 [start1, end1) 和 [start2, end2) 是否有交集, 边界为空表示不限制
+func rangeOverlaps(start1, end1, start2, end2 []byte) bool {
+	if len(end1) != 0 && len(start2) != 0 && bytes.Compare(end1, start2) <= 0 {
+		return false
+	}
+	return len(end2) == 0 || len(start1) == 0 || bytes.Compare(start1, end2) < 0
+}
@@ -0,0 +1,111 @@
+package GoKeeper
+
+import (
+	"GoKeeper/util"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_DeleteRange(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-delete-range")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// key 按照数字的顺序排列
+	rangeKey := func(i int) []byte {
+		return []byte(fmt.Sprintf("key-%04d", i))
+	}
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put(rangeKey(i), util.GetRandomValue(16)))
+	}
+	snapshot := db.NewSnapshot()
+
+	// 1. 范围内的 key 立即不可见, 范围外的 key 不受影响
+	reclaimSize := db.Stat().ReclaimableSize
+	assert.Nil(t, db.DeleteRange(rangeKey(1000), rangeKey(2000)))
+	assert.True(t, db.Stat().ReclaimableSize > reclaimSize)
+	_, err = db.Get(rangeKey(1000))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(rangeKey(1999))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(rangeKey(999))
+	assert.Nil(t, err)
+	_, err = db.Get(rangeKey(2000))
+	assert.Nil(t, err)
+	assert.Equal(t, 2000, len(db.ListKeys()))
+
+	iterator := db.NewIterator(IteratorOption{LowerBound: rangeKey(1000), UpperBound: rangeKey(2000)})
+	iterator.Rewind()
+	assert.False(t, iterator.Valid())
+	iterator.Close()
+
+	// 2. 快照中依然可以看到被删除的 key
+	val, err := snapshot.Get(rangeKey(1500))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	snapshot.Release()
+
+	// 3. 范围删除之后重新写入的 key 可见
+	assert.Nil(t, db.Put(rangeKey(1500), []byte("new")))
+
+	// 4. 删除前缀
+	assert.Nil(t, db.Put([]byte("tenant-1:a"), []byte("a")))
+	assert.Nil(t, db.Put([]byte("tenant-1:b"), []byte("b")))
+	assert.Nil(t, db.Put([]byte("tenant-2:a"), []byte("a")))
+	assert.Nil(t, db.DeletePrefix([]byte("tenant-1:")))
+	_, err = db.Get([]byte("tenant-1:a"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get([]byte("tenant-2:a"))
+	assert.Nil(t, err)
+
+	// 5. 非法的参数
+	assert.Equal(t, ErrInvalidRange, db.DeleteRange([]byte("b"), []byte("a")))
+	assert.Equal(t, ErrKeyIsEmpty, db.DeletePrefix(nil))
+
+	// 6. 重启之后范围删除依然有效
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	_, err = db2.Get(rangeKey(1000))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(rangeKey(999))
+	assert.Nil(t, err)
+	val, err = db2.Get(rangeKey(1500))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+	_, err = db2.Get([]byte("tenant-1:b"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get([]byte("tenant-2:a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2002, len(db2.ListKeys()))
+}
+
+func TestDB_DeleteRangeSubscribe(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-delete-range-subscribe")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	sub, err := db.Subscribe([]byte("tenant-1:"), 0)
+	assert.Nil(t, err)
+	defer sub.Close()
+
+	// 和订阅前缀没有交集的范围删除不会投递
+	assert.Nil(t, db.DeletePrefix([]byte("tenant-2:")))
+	assert.Nil(t, db.DeleteRange([]byte("tenant-0"), []byte("tenant-1:m")))
+	events := receiveEvents(sub, 1)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, ChangeDeleteRange, events[0].Type)
+	assert.Equal(t, []byte("tenant-0"), events[0].Key)
+	assert.Equal(t, []byte("tenant-1:m"), events[0].Value)
+}
@@ -0,0 +1,168 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"GoKeeper/util"
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// assertNoPlaintext 检查数据目录中的文件都不包含明文
+func assertNoPlaintext(t *testing.T, dirPath string, plaintext []byte) {
+	entries, err := os.ReadDir(dirPath)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		if entry.Name() == fileLockName {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dirPath, entry.Name()))
+		assert.Nil(t, err)
+		assert.False(t, bytes.Contains(content, plaintext), entry.Name())
+	}
+}
+
+func TestDB_Encryption(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-encryption")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeThreshold = 0
+	key1, key2 := bytes.Repeat([]byte("1"), 16), bytes.Repeat([]byte("2"), 32)
+	opts.EncryptionKey = key1
+
+	secret := []byte("top-secret-value")
+	put := func(db *DB, from, to int, expected map[int][]byte) {
+		for i := from; i < to; i++ {
+			expected[i] = append(append([]byte{}, secret...), util.GetRandomValue(8)...)
+			assert.Nil(t, db.Put(util.GetRandomKey(i), expected[i]))
+		}
+	}
+
+	// 1. 数据文件和事务序列号文件都是加密的
+	expected := make(map[int][]byte)
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	put(db, 0, 500, expected)
+	assert.Nil(t, db.Close())
+	assertNoPlaintext(t, dir, secret)
+
+	// 2. 没有密钥或者密钥不对时无法打开
+	opts.EncryptionKey = nil
+	_, err = Open(opts)
+	assert.Equal(t, ErrEncryptionKeyRequired, err)
+	opts.EncryptionKey = key2
+	_, err = Open(opts)
+	assert.Equal(t, ErrDecryptFailed, err)
+
+	// 3. 轮换密钥之后旧的数据仍然可以读取
+	opts.EncryptionKey = nil
+	opts.KeyProvider = &KeyRing{CurrentID: 2, Keys: map[uint32][]byte{0: key1, 2: key2}}
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checkValues(t, db, expected)
+	put(db, 250, 750, expected)
+	checkValues(t, db, expected)
+
+	// 4. merge 时使用新的密钥重新加密, 之后不再需要旧的密钥
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	opts.KeyProvider = &KeyRing{CurrentID: 2, Keys: map[uint32][]byte{2: key2}}
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checkValues(t, db, expected)
+	assert.Nil(t, db.Close())
+	assertNoPlaintext(t, dir, secret)
+	assertNoPlaintext(t, dir, util.GetRandomKey(1))
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	destroyDB(db)
+}
+
+func TestDB_EncryptionRotateWithinFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-encryption-rotate")
+	opts.DirPath = dir
+	key1, key2 := bytes.Repeat([]byte("1"), 16), bytes.Repeat([]byte("2"), 32)
+	keyRing := &KeyRing{CurrentID: 1, Keys: map[uint32][]byte{1: key1, 2: key2}}
+	opts.KeyProvider = keyRing
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	// 轮换密钥马上生效, 不需要切换到新的数据文件, 同一个数据文件中的记录使用不同的密钥加密
+	expected := make(map[int][]byte)
+	for i := 0; i < 100; i++ {
+		if i == 50 {
+			keyRing.CurrentID = 2
+		}
+		expected[i] = util.GetRandomValue(32)
+		assert.Nil(t, db.Put(util.GetRandomKey(i), expected[i]))
+	}
+	assert.Equal(t, 0, len(db.olderFiles))
+	assert.Nil(t, db.Close())
+
+	// 缺少任意一个密钥都无法读取这个数据文件
+	opts.KeyProvider = &KeyRing{CurrentID: 2, Keys: map[uint32][]byte{2: key2}}
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+	opts.KeyProvider = &KeyRing{CurrentID: 1, Keys: map[uint32][]byte{1: key1}}
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+
+	opts.KeyProvider = &KeyRing{CurrentID: 2, Keys: map[uint32][]byte{1: key1, 2: key2}}
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checkValues(t, db, expected)
+	destroyDB(db)
+}
+
+func TestOpen_EncryptionOptions(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-encryption-opt")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+
+	opts.EncryptionKey = []byte("short")
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+
+	opts.EncryptionKey = bytes.Repeat([]byte("k"), 16)
+	opts.KeyProvider = &KeyRing{Keys: map[uint32][]byte{0: opts.EncryptionKey}}
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_EncryptionWrongKeyBPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-encryption-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	opts.EncryptionKey = bytes.Repeat([]byte("1"), 16)
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("name"), []byte("Sakura")))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.Close())
+
+	// B+树索引不需要扫描数据文件, 密钥不对时在读取事务序列号文件时失败
+	wrongOpts := opts
+	wrongOpts.EncryptionKey = bytes.Repeat([]byte("2"), 16)
+	_, err = Open(wrongOpts)
+	assert.True(t, errors.Is(err, ErrDecryptFailed))
+
+	// 事务序列号文件没有被删除, 使用正确的密钥依然可以打开
+	_, err = os.Stat(filepath.Join(dir, data.SeqNoFileName))
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	val, err := db.Get([]byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Sakura"), val)
+}
@@ -1,6 +1,7 @@
 package GoKeeper
 
 import (
+	"GoKeeper/data"
 	"errors"
 )
 
@@ -13,8 +14,25 @@ var (
 	ErrDataFileNotFound       = errors.New("data file not found")
 	ErrDataCountDeleted       = errors.New("data has deleted")
 	ErrDataDirectoryCorrupted = errors.New("the database directory maybe corrupted")
+	ErrDataFileCorrupted      = errors.New("the data file is corrupted")
 	ErrExceedMaxBatchNum      = errors.New("exceed max batch num")
 	ErrDatabaseIsUsing        = errors.New("database is using by another process")
+	ErrInvalidTTL             = errors.New("the ttl must be greater than 0")
+	ErrSnapshotReleased       = errors.New("the snapshot has been released")
+	ErrDatabaseClosed         = errors.New("the database has been closed")
+	ErrInvalidRange           = errors.New("the start key must be less than the end key")
+)
+
+// Merge Operator Error
+var (
+	ErrMergeOperatorNotSet = errors.New("merge operator is not set in options")
+	ErrInvalidOperand      = errors.New("invalid operand for the merge operator")
+)
+
+// Transaction Error
+var (
+	ErrTxnConflict = errors.New("transaction conflict, the keys it read have been modified")
+	ErrTxnClosed   = errors.New("transaction has been committed or discarded")
 )
 
 // Merge Error
@@ -22,4 +40,29 @@ var (
 	ErrMergeIsRunning          = errors.New("merge is running, try again later")
 	ErrMergeNotExceedThreshold = errors.New("the amount of data does not exceed the threshold")
 	ErrDiskSpaceNotEnough      = errors.New("disk space is not enough")
+	ErrInvalidMergeOptions     = errors.New("merge bytesPerSec must >= 0")
+	ErrMergeNotInstalled       = errors.New("the last merge dropped keys and has not been installed, reopen the database first")
+	ErrMergeFileIdOverflow     = errors.New("merge output needs more data files than the files it replaces, increase DataFileSize and retry")
+)
+
+// Subscribe Error
+var (
+	ErrSubscribeSeqMerged = errors.New("the subscription seq points to data rewritten by merge or compaction, subscribe from 0 or NextSeq instead")
+)
+
+// Repair Error
+var (
+	ErrRepairDirNotEmpty = errors.New("the repair output directory is not empty")
+)
+
+// File Format Error
+var (
+	ErrInvalidFileHeader      = data.ErrInvalidFileHeader
+	ErrUnsupportedFileVersion = data.ErrUnsupportedFileVersion
+)
+
+// Encryption Error
+var (
+	ErrEncryptionKeyRequired = data.ErrEncryptionKeyRequired
+	ErrDecryptFailed         = data.ErrDecryptFailed
 )
@@ -0,0 +1,146 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// fileSet 读取时可见的数据文件, 发布之后不会再修改, 读取时不需要持有 db.lock
+// 活跃文件切换或者数据文件被替换时, 复制一份新的集合重新发布
+// 读取前通过 acquireFiles 增加引用计数, 被替换掉的数据文件等到没有读取还在使用旧的集合之后才关闭
+type fileSet struct {
+	active *data.DataFile
+	older  map[uint32]*data.DataFile
+
+	refs     atomic.Int64     // 正在使用这个集合的读取数量
+	retired  atomic.Bool      // 是否已经发布了新的集合
+	obsolete []*data.DataFile // 发布下一个集合时被替换掉的数据文件, 这个集合以及之前的集合都不再被使用之后关闭
+}
+
+// get 根据文件 id 找到对应的数据文件, 找不到时返回 nil
+func (fs *fileSet) get(fid uint32) *data.DataFile {
+	if fs == nil {
+		return nil
+	}
+	if fs.active != nil && fs.active.FileID == fid {
+		return fs.active
+	}
+	return fs.older[fid]
+}
+
+// publishFiles 发布当前的活跃文件和旧的数据文件, 之后的读取使用新的集合
+// obsolete 是不在新的集合中的数据文件, 还在使用旧的集合的读取结束之后关闭
+// 调用前必须持有 db.lock
+func (db *DB) publishFiles(obsolete ...*data.DataFile) {
+	older := make(map[uint32]*data.DataFile, len(db.olderFiles))
+	for fid, dataFile := range db.olderFiles {
+		older[fid] = dataFile
+	}
+	prev := db.files.Swap(&fileSet{active: db.activeFile, older: older})
+	if prev == nil {
+		for _, dataFile := range obsolete {
+			_ = dataFile.Close()
+		}
+		return
+	}
+
+	db.fileSetLock.Lock()
+	defer db.fileSetLock.Unlock()
+	prev.obsolete = obsolete
+	db.retiredFiles = append(db.retiredFiles, prev)
+	prev.retired.Store(true)
+	db.closeRetiredFiles()
+}
+
+// acquireFiles 取出当前的数据文件集合并增加引用计数, 读取结束之后必须调用 releaseFiles
+func (db *DB) acquireFiles() *fileSet {
+	for {
+		fs := db.files.Load()
+		if fs == nil {
+			return nil
+		}
+		fs.refs.Add(1)
+		// 增加引用计数之前已经发布了新的集合, 旧的集合中的文件可能已经关闭, 重新取出
+		if db.files.Load() == fs {
+			return fs
+		}
+		db.releaseFiles(fs)
+	}
+}
+
+// releaseFiles 减少数据文件集合的引用计数, 最后一个使用旧的集合的读取负责关闭被替换掉的数据文件
+func (db *DB) releaseFiles(fs *fileSet) {
+	if fs == nil {
+		return
+	}
+	if fs.refs.Add(-1) == 0 && fs.retired.Load() {
+		db.fileSetLock.Lock()
+		db.closeRetiredFiles()
+		db.fileSetLock.Unlock()
+	}
+}
+
+// closeRetiredFiles 按照发布的顺序, 关闭已经没有读取在使用的旧集合中被替换掉的数据文件
+// 更早的集合还在被使用时, 之后的集合中被替换掉的文件也不能关闭, 更早的集合中同样包含这些文件
+// 调用前必须持有 db.fileSetLock
+func (db *DB) closeRetiredFiles() {
+	for len(db.retiredFiles) > 0 && db.retiredFiles[0].refs.Load() == 0 {
+		for _, dataFile := range db.retiredFiles[0].obsolete {
+			_ = dataFile.Close()
+		}
+		db.retiredFiles[0] = nil
+		db.retiredFiles = db.retiredFiles[1:]
+	}
+}
+
+// closeAllRetiredFiles 关闭数据库时关闭所有还没有关闭的被替换掉的数据文件
+func (db *DB) closeAllRetiredFiles() {
+	db.fileSetLock.Lock()
+	defer db.fileSetLock.Unlock()
+	for _, fs := range db.retiredFiles {
+		for _, dataFile := range fs.obsolete {
+			_ = dataFile.Close()
+		}
+	}
+	db.retiredFiles = nil
+}
+
+// beginRelocate 开始移动数据, 数据文件被替换并且内存索引更新完之前, 索引中的位置可能指向错误的数据
+// 序列号为奇数时读取需要等待, 调用前必须持有 db.lock
+func (db *DB) beginRelocate() {
+	db.relocateSeq.Add(1)
+}
+
+// endRelocate 移动数据结束, 被替换的数据文件在旧的数据文件集合不再被使用之后关闭
+func (db *DB) endRelocate() {
+	db.relocateSeq.Add(1)
+}
+
+// getValue 不加锁读取 key 的值, pos 是序列号为 seq 时从内存索引中取出的位置
+// 读取前后序列号没有变化时数据一定是正确的, 否则重新从内存索引中取出 key 的位置再读取
+// 追加写入不会移动已有的数据, 只有增量压缩重写数据文件时需要重试
+func (db *DB) getValue(key []byte, pos *data.LogRecordPos, seq uint64) ([]byte, error) {
+	for {
+		if seq%2 == 0 {
+			files := db.acquireFiles()
+			value, err := db.readValue(files.get, pos)
+			db.releaseFiles(files)
+			if db.relocateSeq.Load() == seq {
+				return value, err
+			}
+		}
+
+		// 数据被移动过, 等待移动结束之后重新取出 key 的位置
+		seq = db.relocateSeq.Load()
+		if seq%2 == 1 {
+			runtime.Gosched()
+			continue
+		}
+		pos = db.index.Get(key)
+		if pos == nil || pos.IsExpired(time.Now().UnixNano()) {
+			return nil, ErrKeyNotFound
+		}
+	}
+}
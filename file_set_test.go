@@ -0,0 +1,161 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDB_ReadWithoutLock(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-read")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+
+	// 写入持有 db.lock 时, 读取不会被阻塞
+	db.lock.Lock()
+	value, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+		assert.Equal(t, []byte("value"), value)
+		return true
+	}))
+	iterator := db.NewIterator(DefaultIteratorOption)
+	iterator.Rewind()
+	value, err = iterator.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	iterator.Close()
+	db.lock.Unlock()
+}
+
+func TestDB_ConcurrentRead(t *testing.T) {
+	configs := []struct {
+		ioType    IOType
+		indexType IndexType
+	}{{StandardIO, Btree}, {MMapIO, Btree}, {StandardIO, SkipList}}
+	for _, config := range configs {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "goKeeper-read")
+		opts.DirPath = dir
+		opts.DataFileSize = 32 * 1024
+		opts.MergeThreshold = 0.1
+		opts.IOType = config.ioType
+		opts.IndexType = config.indexType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		// value 以 key 开头, 读取到的 value 和 key 不匹配说明读到了错误的位置
+		const keys = 200
+		key := func(i int) []byte { return []byte(fmt.Sprintf("key-%03d", i)) }
+		value := func(i, n int) []byte { return []byte(fmt.Sprintf("key-%03d-%06d", i, n)) }
+		for i := 0; i < keys; i++ {
+			assert.Nil(t, db.Put(key(i), value(i, 0)))
+		}
+		checkValue := func(k, v []byte) {
+			assert.True(t, bytes.HasPrefix(v, k) && v[len(k)] == '-', "key %s value %s", k, v)
+		}
+
+		// 覆盖写入和增量压缩的同时并发读取, 增量压缩会移动数据并关闭原来的数据文件
+		var stopped atomic.Bool
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 1; n <= 100; n++ {
+				for i := 0; i < keys; i++ {
+					assert.Nil(t, db.Put(key(i), value(i, n)))
+				}
+				err := db.Compact(0)
+				assert.True(t, err == nil || err == ErrMergeNotExceedThreshold || err == ErrMergeIsRunning, err)
+			}
+			stopped.Store(true)
+		}()
+		for r := 0; r < 4; r++ {
+			wg.Add(1)
+			go func(r int) {
+				defer wg.Done()
+				for n := 0; !stopped.Load(); n++ {
+					switch n % 3 {
+					case 0:
+						k := key((n + r) % keys)
+						v, err := db.Get(k)
+						assert.Nil(t, err)
+						checkValue(k, v)
+					case 1:
+						count := 0
+						assert.Nil(t, db.Fold(func(k []byte, v []byte) bool {
+							checkValue(k, v)
+							count++
+							return true
+						}))
+						assert.Equal(t, keys, count)
+					case 2:
+						iterator := db.NewIterator(DefaultIteratorOption)
+						for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+							v, err := iterator.Value()
+							assert.Nil(t, err)
+							checkValue(iterator.Key(), v)
+						}
+						iterator.Close()
+					}
+				}
+			}(r)
+		}
+		wg.Wait()
+		// 读取的过程中确实移动过数据
+		assert.True(t, db.relocateSeq.Load() > 0)
+
+		assert.Nil(t, db.Close())
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestDB_CompactKeepsFilesForReaders(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-read")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeThreshold = 0.1
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	for n := 0; n < 3; n++ {
+		for i := 0; i < 500; i++ {
+			assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%d", n))))
+		}
+	}
+
+	// 读取在增量压缩之前取出了数据文件集合, 压缩之后仍然可以读取旧的数据文件
+	files := db.acquireFiles()
+	assert.Nil(t, db.Compact(0))
+	var replaced []*data.DataFile
+	for fid, dataFile := range files.older {
+		if db.olderFiles[fid] != dataFile {
+			replaced = append(replaced, dataFile)
+		}
+	}
+	assert.NotEmpty(t, replaced)
+	for _, dataFile := range replaced {
+		_, _, err := dataFile.ReadLogRecord(dataFile.HeaderSize())
+		assert.Nil(t, err)
+	}
+
+	// 最后一个使用旧集合的读取结束之后关闭被替换掉的数据文件
+	db.releaseFiles(files)
+	for _, dataFile := range replaced {
+		_, _, err := dataFile.ReadLogRecord(dataFile.HeaderSize())
+		assert.NotNil(t, err)
+	}
+}
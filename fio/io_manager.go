@@ -8,8 +8,10 @@ type FileIOType = byte
 const (
 	// StandardFIO ReadOnly 标准文件IO
 	StandardFIO FileIOType = iota
-	// MemoryMapFIO  内存映射文件IO
+	// MemoryMapFIO  内存映射文件IO, 只读, 用于启动时加载数据文件
 	MemoryMapFIO
+	// MemoryMapRWFIO 可读写的内存映射文件IO
+	MemoryMapRWFIO
 )
 
 // IOManager 抽象IO管理接口,可以接入不同的IO类型
@@ -37,6 +39,8 @@ func NewIOManager(filename string, ioType FileIOType) (IOManager, error) {
 		return NewFileIO(filename)
 	case MemoryMapFIO:
 		return NewMMapIOManager(filename)
+	case MemoryMapRWFIO:
+		return NewMMapRWIOManager(filename)
 	default:
 		panic("unsupported io type")
 	}
@@ -0,0 +1,149 @@
+//go:build !windows
+
+package fio
+
+import (
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"sync"
+)
+
+// MMapRW 可读写的内存文件映射
+// 文件按照预分配的大小映射到内存中, 写入时直接拷贝到映射的内存, 空间不够时扩大文件并重新映射
+// 文件末尾预分配的空间在关闭时截断, 崩溃之后留下的全 0 的数据在启动时截断
+type MMapRW struct {
+	lock    sync.RWMutex
+	fd      *os.File
+	data    []byte // 映射的内存, 长度为文件当前的大小
+	size    int64  // 已经写入的数据的大小
+	reserve int64  // 第一次扩大文件时预分配的大小
+}
+
+// NewMMapRWIOManager 初始化可读写的内存文件映射, 已有的数据全部映射, 第一次写入时再预分配空间
+func NewMMapRWIOManager(fileName string) (*MMapRW, error) {
+	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	m := &MMapRW{fd: fd, size: stat.Size()}
+	if err = m.remap(stat.Size()); err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Preallocate 设置第一次扩大文件时预分配的大小, 活跃文件设置为数据文件的大小, 避免写入过程中频繁地重新映射
+func (m *MMapRW) Preallocate(size int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.reserve = size
+}
+
+func (m *MMapRW) Read(b []byte, offset int64) (int, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if offset >= m.size {
+		return 0, io.EOF
+	}
+	n := copy(b, m.data[offset:m.size])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *MMapRW) Write(b []byte) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if need := m.size + int64(len(b)); need > int64(len(m.data)) {
+		// 至少扩大一倍, 扩大的部分是文件空洞, 不会立即占用磁盘空间
+		capacity := max(need, m.reserve, 2*int64(len(m.data)))
+		if err := m.fd.Truncate(capacity); err != nil {
+			return 0, err
+		}
+		if err := m.remap(capacity); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(m.data[m.size:], b)
+	m.size += int64(n)
+	return n, nil
+}
+
+// Trim 截断文件末尾没有使用的预分配空间, 文件不再写入时调用, 之后仍然可以读取
+func (m *MMapRW) Trim() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if int64(len(m.data)) == m.size {
+		return nil
+	}
+	if err := m.remap(m.size); err != nil {
+		return err
+	}
+	return m.fd.Truncate(m.size)
+}
+
+// Sync 把写入的数据刷到磁盘, 扩大文件之后还需要持久化文件的大小
+func (m *MMapRW) Sync() error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.size == 0 {
+		return nil
+	}
+	if err := unix.Msync(m.data[:m.size], unix.MS_SYNC); err != nil {
+		return err
+	}
+	return m.fd.Sync()
+}
+
+// Close 解除映射, 截断文件末尾没有使用的预分配空间
+// 关闭之后的读取返回 io.EOF, 不会访问已经解除映射的内存
+func (m *MMapRW) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.data != nil {
+		if err := unix.Munmap(m.data); err != nil {
+			return err
+		}
+		m.data = nil
+	}
+	if err := m.fd.Truncate(m.size); err != nil {
+		return err
+	}
+	m.size = 0
+	return m.fd.Close()
+}
+
+// Size 返回已经写入的数据的大小, 不包括预分配的空间
+func (m *MMapRW) Size() (int64, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.size, nil
+}
+
+// remap 按照新的大小重新映射文件, 调用前必须持有写锁
+func (m *MMapRW) remap(size int64) error {
+	if m.data != nil {
+		if err := unix.Munmap(m.data); err != nil {
+			return err
+		}
+		m.data = nil
+	}
+	// 空文件不能映射
+	if size == 0 {
+		return nil
+	}
+	data, err := unix.Mmap(int(m.fd.Fd()), 0, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	m.data = data
+	return nil
+}
@@ -0,0 +1,80 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMMapRW_Write(t *testing.T) {
+	path := filepath.Join("../tmp", "mmap-rw.data")
+	_ = os.MkdirAll("../tmp", os.ModePerm)
+	defer destroyFile(path)
+
+	mmapIO, err := NewMMapRWIOManager(path)
+	assert.Nil(t, err)
+
+	// 1. 文件为空
+	b := make([]byte, 5)
+	n, err := mmapIO.Read(b, 0)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, n)
+
+	// 2. 第一次写入时预分配空间, 读取不会超过写入的位置
+	mmapIO.Preallocate(1024)
+	n, err = mmapIO.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	size, err := mmapIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), size)
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1024), stat.Size())
+	n, err = mmapIO.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(b))
+	n, err = mmapIO.Read(b, 3)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 2, n)
+
+	// 3. 超过预分配的空间时扩大文件重新映射
+	big := make([]byte, 2000)
+	big[len(big)-1] = 'x'
+	_, err = mmapIO.Write(big)
+	assert.Nil(t, err)
+	stat, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2048), stat.Size())
+	assert.Nil(t, mmapIO.Sync())
+
+	// 4. 截断预分配的空间之后仍然可以读取
+	assert.Nil(t, mmapIO.Trim())
+	stat, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2005), stat.Size())
+	n, err = mmapIO.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	// 5. 关闭时截断预分配的空间, 重新打开之后继续追加
+	mmapIO.Preallocate(4096)
+	_, err = mmapIO.Write([]byte("!"))
+	assert.Nil(t, err)
+	assert.Nil(t, mmapIO.Close())
+	stat, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2006), stat.Size())
+
+	mmapIO, err = NewMMapRWIOManager(path)
+	assert.Nil(t, err)
+	_, err = mmapIO.Write([]byte("world"))
+	assert.Nil(t, err)
+	b = make([]byte, 6)
+	n, err = mmapIO.Read(b, 2004)
+	assert.Nil(t, err)
+	assert.Equal(t, "x!worl", string(b))
+	assert.Nil(t, mmapIO.Close())
+}
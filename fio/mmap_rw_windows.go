@@ -0,0 +1,22 @@
+//go:build windows
+
+package fio
+
+import "errors"
+
+var ErrMMapRWNotSupported = errors.New("read-write mmap is not supported on windows")
+
+// MMapRW 可读写的内存文件映射, windows 上不支持
+type MMapRW struct {
+	FileIo
+}
+
+func NewMMapRWIOManager(fileName string) (*MMapRW, error) {
+	return nil, ErrMMapRWNotSupported
+}
+
+func (m *MMapRW) Preallocate(size int64) {}
+
+func (m *MMapRW) Trim() error {
+	return nil
+}
@@ -0,0 +1,206 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+)
+
+// maxGroupCommitSize 一次组提交最多写入的数据量, 避免排在前面的写入等待太久
+const maxGroupCommitSize = 1024 * 1024
+
+// encodedRecord 已经编码好, 等待写入数据文件的记录
+type encodedRecord struct {
+	buf         []byte
+	expire      int64
+	logicalSize int64
+}
+
+// writeRequest 一次等待组提交的写入
+type writeRequest struct {
+	records []*encodedRecord
+	size    int64
+	sync    bool
+
+	// 写入成功之后按照提交的顺序调用, 更新内存索引, 调用时持有 db.lock
+	apply func(positions []*data.LogRecordPos)
+
+	done bool
+	err  error
+}
+
+// encodeRecords 编码记录, value 按照配置的算法压缩, 配置了密钥时加密
+// 不依赖数据库的状态, 并发的写入可以在加锁之前各自编码
+func (db *DB) encodeRecords(logRecords []*data.LogRecord) ([]*encodedRecord, int64, error) {
+	records := make([]*encodedRecord, 0, len(logRecords))
+	var total int64
+	for _, logRecord := range logRecords {
+		logRecord.Compression = db.options.Compression
+		buf, size, err := db.cipher.EncodeLogRecord(logRecord)
+		if err != nil {
+			return nil, 0, err
+		}
+		records = append(records, &encodedRecord{
+			buf:         buf,
+			expire:      logRecord.Expire,
+			logicalSize: data.LogicalRecordSize(logRecord),
+		})
+		total += size
+	}
+	return records, total, nil
+}
+
+// commitWrite 通过组提交写入一批记录
+// 并发的写入在队列中排队, 排在最前面的写入成为 leader, 加锁之后把队列中所有的写入合并成一次写入和一次持久化,
+// 然后按照排队的顺序更新内存索引, 最后唤醒所有的写入. 开启 SyncWrites 时, 并发写入的吞吐量随着写入数量增加
+func (db *DB) commitWrite(logRecords []*data.LogRecord, sync bool, apply func(positions []*data.LogRecordPos)) error {
+	records, size, err := db.encodeRecords(logRecords)
+	if err != nil {
+		return err
+	}
+	req := &writeRequest{records: records, size: size, sync: sync, apply: apply}
+
+	db.writeLock.Lock()
+	db.writers = append(db.writers, req)
+	for !req.done && db.writers[0] != req {
+		db.writeCond.Wait()
+	}
+	if req.done {
+		db.writeLock.Unlock()
+		return req.err
+	}
+	db.writeLock.Unlock()
+
+	// 成为 leader, 先获取 db.lock, 等待的过程中到达的写入也可以合并进来
+	db.lock.Lock()
+	db.writeLock.Lock()
+	group := []*writeRequest{req}
+	total := req.size
+	for _, w := range db.writers[1:] {
+		if total+w.size > maxGroupCommitSize {
+			break
+		}
+		group = append(group, w)
+		total += w.size
+	}
+	db.writeLock.Unlock()
+
+	var groupRecords []*encodedRecord
+	needSync := false
+	for _, w := range group {
+		groupRecords = append(groupRecords, w.records...)
+		needSync = needSync || w.sync
+	}
+	positions, err := db.writeRecords(groupRecords, needSync)
+	if err == nil {
+		for _, w := range group {
+			w.apply(positions[:len(w.records)])
+			positions = positions[len(w.records):]
+		}
+	}
+	db.lock.Unlock()
+
+	// 唤醒这一组的写入, 以及下一组的 leader
+	db.writeLock.Lock()
+	for _, w := range group {
+		w.done, w.err = true, err
+	}
+	db.writers = db.writers[len(group):]
+	db.writeCond.Broadcast()
+	db.writeLock.Unlock()
+	return err
+}
+
+// writeRecords 把编码好的记录合并成一次写入追加到活跃文件中, 活跃文件写满时切换到新的文件
+// 调用前必须持有 db.lock
+func (db *DB) writeRecords(records []*encodedRecord, sync bool) ([]*data.LogRecordPos, error) {
+	// 判断当前活跃数据文件是否存在,因为数据库在没有写入的时候是没有文件生成的
+	// 活跃文件为空则初始化数据文件
+	if db.activeFile == nil {
+		if err := db.setActiveDataFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	positions := make([]*data.LogRecordPos, 0, len(records))
+	var pending [][]byte
+	var pendingSize, logicalSize int64
+	// flush 把缓存的记录合并成一次写入, 只有一条记录时不需要拷贝
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		buf := pending[0]
+		if len(pending) > 1 {
+			buf = make([]byte, 0, pendingSize)
+			for _, b := range pending {
+				buf = append(buf, b...)
+			}
+		}
+		if err := db.activeFile.Write(buf); err != nil {
+			return err
+		}
+		db.activeFile.LogicalSize += logicalSize
+		db.byteWrite += uint(pendingSize)
+		pending, pendingSize, logicalSize = pending[:0], 0, 0
+		return nil
+	}
+
+	for _, record := range records {
+		size := int64(len(record.buf))
+		// 如果写入数据已经到达了活跃文件的阈值,则关闭活跃文件,并打开新文件
+		if db.activeFile.WriteOff+pendingSize+size > db.options.DataFileSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			// 先持久化数据文件,保证已有的数据持久化到磁盘当中
+			if err := db.activeFile.Sync(); err != nil {
+				return nil, err
+			}
+
+			// 当前活跃文件转换为旧的数据文件
+			db.olderFiles[db.activeFile.FileID] = db.activeFile
+
+			// 打开新的数据文件
+			if err := db.setActiveDataFile(); err != nil {
+				return nil, err
+			}
+		}
+
+		// 空的数据文件先写入文件头
+		if len(pending) == 0 {
+			if err := db.activeFile.InitHeader(db.fingerprint); err != nil {
+				return nil, err
+			}
+		}
+		// 构造内存索引信息
+		positions = append(positions, &data.LogRecordPos{
+			Fid:    db.activeFile.FileID,
+			Offset: db.activeFile.WriteOff + pendingSize,
+			Size:   uint32(size),
+			Expire: record.expire,
+		})
+		pending = append(pending, record.buf)
+		pendingSize += size
+		logicalSize += record.logicalSize
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	// 根据用户配置决定是否每次写入持久化
+	// 如果打开了每次写入持久化, 则根据写入字节的持久化策略就失效
+	needSync := sync || db.options.SyncWrites
+	if !needSync && db.options.BytesPerSync > 0 && db.byteWrite >= db.options.BytesPerSync {
+		needSync = true
+	}
+	if needSync {
+		if err := db.activeFile.Sync(); err != nil {
+			return nil, err
+		}
+		// 清空累计值
+		db.byteWrite = 0
+	}
+
+	// 通知订阅者读取新的数据
+	db.notifySubscribers()
+	return positions, nil
+}
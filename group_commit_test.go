@@ -0,0 +1,137 @@
+package GoKeeper
+
+import (
+	"GoKeeper/fio"
+	"GoKeeper/util"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingIO 统计写入和持久化的次数
+type countingIO struct {
+	fio.IOManager
+	writes atomic.Int32
+	syncs  atomic.Int32
+}
+
+func (c *countingIO) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.IOManager.Write(b)
+}
+
+func (c *countingIO) Sync() error {
+	c.syncs.Add(1)
+	return c.IOManager.Sync()
+}
+
+// waitWriters 等待组提交的队列中有 n 个写入
+func waitWriters(t *testing.T, db *DB, n int) {
+	assert.Eventually(t, func() bool {
+		db.writeLock.Lock()
+		defer db.writeLock.Unlock()
+		return len(db.writers) == n
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestDB_GroupCommit(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "goKeeper-group-commit")
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(util.GetRandomKey(0), util.GetRandomValue(16)))
+	counter := &countingIO{IOManager: db.activeFile.IoManager}
+	db.activeFile.IoManager = counter
+
+	// 持有锁的时候并发写入, 所有的写入都在队列中等待
+	db.lock.Lock()
+	const writers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers+1)
+	for i := 1; i <= writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				errs <- db.Put(util.GetRandomKey(i), util.GetRandomValue(16))
+				return
+			}
+			wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+			assert.Nil(t, wb.Put(util.GetRandomKey(i), util.GetRandomValue(16)))
+			assert.Nil(t, wb.Delete(util.GetRandomKey(0)))
+			errs <- wb.Commit()
+		}(i)
+	}
+	waitWriters(t, db, writers)
+	db.lock.Unlock()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.Nil(t, err)
+	}
+
+	// 一次写入, 一次持久化
+	assert.Equal(t, int32(1), counter.writes.Load())
+	assert.Equal(t, int32(1), counter.syncs.Load())
+	assert.Equal(t, writers, len(db.ListKeys()))
+
+	// 删除也经过组提交, 删除不存在的 key 不写入
+	for i := 1; i <= 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(t, db.Delete(util.GetRandomKey(i)))
+		}(i)
+	}
+	wg.Wait()
+	writes := counter.writes.Load()
+	assert.Nil(t, db.Delete(util.GetRandomKey(0)))
+	assert.Equal(t, writes, counter.writes.Load())
+	assert.Equal(t, writers-3, len(db.ListKeys()))
+
+	// 重启之后数据不变
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, writers-3, len(db.ListKeys()))
+	_, err = db.Get(util.GetRandomKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_GroupCommit_Rotate(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "goKeeper-group-commit")
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 一次组提交的数据超过一个数据文件时, 中间切换活跃文件
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(t, db.Put(util.GetRandomKey(i), util.GetRandomValue(128)))
+		}(i)
+	}
+	wg.Wait()
+	assert.Greater(t, len(db.olderFiles), 1)
+	for i := 0; i < 200; i++ {
+		val, err := db.Get(util.GetRandomKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, len(db.ListKeys()))
+}
@@ -4,7 +4,7 @@ import (
 	"GoKeeper/data"
 	"bytes"
 	goart "github.com/plar/go-adaptive-radix-tree"
-	"sort"
+	"math"
 	"sync"
 )
 
@@ -13,6 +13,9 @@ import (
 type AdaptiveRadixTree struct {
 	tree goart.Tree
 	lock *sync.RWMutex
+
+	// maxKey 当前最大的 key, 反向遍历从这里开始, 基数树本身只能返回最大 key 对应的 value
+	maxKey []byte
 }
 
 func NewART() *AdaptiveRadixTree {
@@ -25,6 +28,9 @@ func NewART() *AdaptiveRadixTree {
 func (art *AdaptiveRadixTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	art.lock.Lock()
 	oldValue, _ := art.tree.Insert(key, pos)
+	if art.maxKey == nil || bytes.Compare(key, art.maxKey) > 0 {
+		art.maxKey = key
+	}
 	art.lock.Unlock()
 	if oldValue == nil {
 		return nil
@@ -45,6 +51,14 @@ func (art *AdaptiveRadixTree) Get(key []byte) *data.LogRecordPos {
 func (art *AdaptiveRadixTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	art.lock.Lock()
 	oldValue, deleted := art.tree.Delete(key)
+	if deleted && bytes.Equal(key, art.maxKey) {
+		// 删除的是最大的 key, 它的前一个 key 成为新的最大 key
+		art.maxKey = nil
+		artDescend(art.tree, key, func(node goart.Node) bool {
+			art.maxKey = node.Key()
+			return false
+		})
+	}
 	art.lock.Unlock()
 	if oldValue == nil {
 		return nil, false
@@ -64,92 +78,224 @@ func (art *AdaptiveRadixTree) Close() error {
 }
 
 func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
-	art.lock.RLock()
-	defer art.lock.RUnlock()
-	return newARTIterator(art.tree, reverse)
+	return art.RangeIterator(reverse, nil, nil)
 }
 
-// BTreeIterator  索引迭代器
+// RangeIterator 返回范围迭代器
+// 迭代器每次持有读锁从基数树中读取一小批数据, 遍历期间可以看到其他的写入
+func (art *AdaptiveRadixTree) RangeIterator(reverse bool, lowerBound []byte, upperBound []byte) Iterator {
+	return newARTIterator(art, reverse, lowerBound, upperBound)
+}
+
+// artIterator  索引迭代器
+// 按批次从基数树中读取数据, 不会一次性把所有数据复制出来
 type artIterator struct {
-	// 记录遍历到了哪个位置
-	currIndex int
+	*batchIterator
+}
 
-	// 是否反向遍历
-	reverse bool
+// newARTIterator 创建基数树迭代器, 只遍历 [lowerBound, upperBound) 范围内的数据, 边界为空表示不限制
+func newARTIterator(art *AdaptiveRadixTree, reverse bool, lowerBound []byte, upperBound []byte) *artIterator {
+	fetch := func(start []byte, inclusive bool, n int) []*Item {
+		items := make([]*Item, 0, n)
+		saveValues := func(node goart.Node) bool {
+			if !inclusive && bytes.Equal(node.Key(), start) {
+				return true
+			}
+			items = append(items, &Item{
+				key: node.Key(),
+				pos: node.Value().(*data.LogRecordPos),
+			})
+			return len(items) < n
+		}
 
-	// key + 位置索引信息
-	values []*Item
+		art.lock.RLock()
+		defer art.lock.RUnlock()
+		if reverse {
+			if start == nil {
+				if art.maxKey == nil {
+					return items
+				}
+				start, inclusive = art.maxKey, true
+			}
+			artDescend(art.tree, start, saveValues)
+		} else {
+			artAscend(art.tree, start, saveValues)
+		}
+		return items
+	}
+	return &artIterator{
+		batchIterator: newBatchIterator(reverse, lowerBound, upperBound, fetch),
+	}
 }
 
-func newARTIterator(tree goart.Tree, reverse bool) *artIterator {
-	var idx int
-	if reverse {
-		idx = tree.Size() - 1
+// artScanSize Seek 时在每一层的子树中最多跳过或者暂存的 key 的数量, 反向遍历时不超过这个数量的子树直接整体读取出来再倒序
+// 超过之后改为逐个字节查找子树, 不会在很大的子树中从头遍历
+const artScanSize = 256
+
+// artForEachLeaf 按照 key 的顺序遍历以 prefix 为前缀的所有叶子节点, prefix 为空时遍历整棵树
+func artForEachLeaf(tree goart.Tree, prefix []byte, callback goart.Callback) {
+	if len(prefix) == 0 {
+		tree.ForEach(callback)
+		return
 	}
-	values := make([]*Item, tree.Size())
-	// 将所有数据存放到数组中
-	saveValues := func(node goart.Node) bool {
-		item := &Item{
-			key: node.Key(),
-			pos: node.Value().(*data.LogRecordPos),
+	tree.ForEachPrefix(prefix, func(node goart.Node) bool {
+		// ForEachPrefix 会同时遍历到内部节点, 只处理叶子节点
+		if node.Kind() != goart.Leaf {
+			return true
 		}
-		values[idx] = item
-		if reverse {
-			idx--
-		} else {
-			idx++
+		return callback(node)
+	})
+}
+
+// artAscend 从 start 开始(包含)按照 key 的顺序遍历基数树, callback 返回 false 时终止遍历
+// 不需要从头遍历小于 start 的 key: 先遍历以 start 为前缀的 key,
+// 然后从 start 的最后一个字节开始逐层回退, 每一层按顺序遍历一次以 start[:i] 为前缀的子树,
+// 跳过第 i 个字节不大于 start[i] 的 key, 剩下的 key 都比 start 大
+func artAscend(tree goart.Tree, start []byte, callback goart.Callback) {
+	if len(start) == 0 {
+		tree.ForEach(callback)
+		return
+	}
+
+	cont := true
+	visit := func(node goart.Node) bool {
+		cont = callback(node)
+		return cont
+	}
+	artForEachLeaf(tree, start, visit)
+
+	for i := len(start) - 1; i >= 0 && cont; i-- {
+		if artAscendLevel(tree, start, i, visit) {
+			continue
+		}
+		// 需要跳过的 key 太多, 逐个字节查找这一层上比 start 大的子树
+		prefix := bytes.Clone(start[:i+1])
+		for b := int(start[i]) + 1; b <= math.MaxUint8 && cont; b++ {
+			prefix[i] = byte(b)
+			artForEachLeaf(tree, prefix, visit)
+		}
+	}
+}
+
+// artAscendLevel 按顺序遍历第 i 层上比 start 大的子树, 需要跳过的 key 超过 artScanSize 时放弃并返回 false
+func artAscendLevel(tree goart.Tree, start []byte, i int, visit goart.Callback) bool {
+	var skipped int
+	completed := true
+	artForEachLeaf(tree, start[:i], func(node goart.Node) bool {
+		if key := node.Key(); len(key) > i && key[i] > start[i] {
+			return visit(node)
+		}
+		// start[:i] 本身以及第 i 个字节更小的 key 比 start 小, 第 i 个字节相同的 key 已经在下一层处理过了
+		if skipped++; skipped > artScanSize {
+			completed = false
+			return false
 		}
 		return true
+	})
+	return completed
+}
+
+// artDescend 从 start 开始(包含)按照 key 的倒序遍历基数树
+// callback 返回 false 时终止遍历
+// 基数树只能正向遍历, 所以按照子树从大到小的顺序处理:
+// 先处理 start 本身, 然后从 start 的最后一个字节开始逐层回退, 按顺序取出这一层上比 start 小的子树中的 key
+// 以及这一层的前缀本身(前缀比以它开头的所有 key 都小), 再倒序处理
+func artDescend(tree goart.Tree, start []byte, callback goart.Callback) {
+	if value, found := tree.Search(start); found {
+		if !callback(artLeaf{key: bytes.Clone(start), value: value}) {
+			return
+		}
 	}
-	// 传入回调函数
-	tree.ForEach(saveValues)
+	for i := len(start) - 1; i >= 0; i-- {
+		if leaves, ok := artDescendLevel(tree, start, i); ok {
+			for j := len(leaves) - 1; j >= 0; j-- {
+				if !callback(leaves[j]) {
+					return
+				}
+			}
+			continue
+		}
 
-	return &artIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		values:    values,
+		// 这一层上比 start 小的 key 太多, 逐个字节倒序处理这一层上的子树
+		prefix := bytes.Clone(start[:i+1])
+		for b := int(start[i]) - 1; b >= 0; b-- {
+			prefix[i] = byte(b)
+			if !artDescendPrefix(tree, prefix, callback) {
+				return
+			}
+		}
+		if i == 0 {
+			break
+		}
+		if value, found := tree.Search(start[:i]); found {
+			if !callback(artLeaf{key: bytes.Clone(start[:i]), value: value}) {
+				return
+			}
+		}
 	}
 }
 
-// Rewind 重置迭代器
-func (arti *artIterator) Rewind() {
-	arti.currIndex = 0
+// artDescendLevel 按顺序取出第 i 层上比 start 小的子树中的 key, 包括 start[:i] 本身
+// 数量超过 artScanSize 时放弃并返回 false
+func artDescendLevel(tree goart.Tree, start []byte, i int) ([]goart.Node, bool) {
+	var leaves []goart.Node
+	artForEachLeaf(tree, start[:i], func(node goart.Node) bool {
+		// 之后的 key 的第 i 个字节都不小于 start[i], 已经在下一层处理过了
+		if key := node.Key(); len(key) > i && key[i] >= start[i] {
+			return false
+		}
+		leaves = append(leaves, node)
+		return len(leaves) <= artScanSize
+	})
+	return leaves, len(leaves) <= artScanSize
 }
 
-// Seek 根据传入的key 查找到第一个大于(或小于)等于的目标 key, 根据从这key开始遍历
-func (arti *artIterator) Seek(key []byte) {
-	if arti.reverse {
-		arti.currIndex = sort.Search(len(arti.values), func(i int) bool {
-			return bytes.Compare(arti.values[i].key, key) <= 0
-		})
-	} else {
-		arti.currIndex = sort.Search(len(arti.values), func(i int) bool {
-			return bytes.Compare(arti.values[i].key, key) >= 0
-		})
+// artDescendPrefix 倒序遍历以 prefix 为前缀的所有 key, 返回是否需要继续遍历
+func artDescendPrefix(tree goart.Tree, prefix []byte, callback goart.Callback) bool {
+	// 子树比较小时直接读取出来倒序处理
+	var leaves []goart.Node
+	artForEachLeaf(tree, prefix, func(node goart.Node) bool {
+		leaves = append(leaves, node)
+		return len(leaves) <= artScanSize
+	})
+	if len(leaves) <= artScanSize {
+		for i := len(leaves) - 1; i >= 0; i-- {
+			if !callback(leaves[i]) {
+				return false
+			}
+		}
+		return true
 	}
-}
 
-// Next 跳转到下一个 key
-func (arti *artIterator) Next() {
-	arti.currIndex++
+	// 子树比较大时按照下一个字节从大到小拆分成更小的子树
+	child := make([]byte, len(prefix)+1)
+	copy(child, prefix)
+	for b := math.MaxUint8; b >= 0; b-- {
+		child[len(prefix)] = byte(b)
+		if !artDescendPrefix(tree, child, callback) {
+			return false
+		}
+	}
+	if value, found := tree.Search(prefix); found {
+		return callback(artLeaf{key: bytes.Clone(prefix), value: value})
+	}
+	return true
 }
 
-// Valid 判断是否还有下一个 key
-func (arti *artIterator) Valid() bool {
-	return arti.currIndex < len(arti.values)
+// artLeaf 通过 Search 找到的 key, 包装成叶子节点交给回调函数处理
+type artLeaf struct {
+	key   []byte
+	value goart.Value
 }
 
-// Key 返回当前 key
-func (arti *artIterator) Key() []byte {
-	return arti.values[arti.currIndex].key
+func (l artLeaf) Kind() goart.Kind {
+	return goart.Leaf
 }
 
-// Value 返回当前 key 对应的 value
-func (arti *artIterator) Value() *data.LogRecordPos {
-	return arti.values[arti.currIndex].pos
+func (l artLeaf) Key() goart.Key {
+	return l.key
 }
 
-// Close 关闭迭代器
-func (arti *artIterator) Close() {
-	arti.values = nil
+func (l artLeaf) Value() goart.Value {
+	return l.value
 }
@@ -2,7 +2,11 @@ package index
 
 import (
 	"GoKeeper/data"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
+	"sync"
 	"testing"
 )
 
@@ -102,3 +106,92 @@ func TestAdaptiveRadixTree_Iterator(t *testing.T) {
 		)
 	}
 }
+
+func TestAdaptiveRadixTree_RangeIterator(t *testing.T) {
+	testRangeIterator(t, NewART())
+}
+
+func TestAdaptiveRadixTree_IteratorConcurrentWrite(t *testing.T) {
+	art := NewART()
+	for i := 0; i < 1000; i++ {
+		art.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// 一边写入一边遍历, 没有被修改过的 key 都能按顺序遍历到
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1000; i < 2000; i++ {
+			art.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+	}()
+
+	for _, reverse := range []bool{false, true} {
+		iterator := art.RangeIterator(reverse, nil, []byte("key-1000"))
+		var count int
+		var prev []byte
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			if prev != nil {
+				assert.Equal(t, reverse, string(iterator.Key()) < string(prev))
+			}
+			prev = iterator.Key()
+			count++
+		}
+		iterator.Close()
+		assert.Equal(t, 1000, count)
+	}
+	wg.Wait()
+}
+
+func TestAdaptiveRadixTree_IteratorSeekLargeSubtree(t *testing.T) {
+	// 长 key 并且每一层的子树都超过 artScanSize, 覆盖逐个字节查找子树的情况
+	art := NewART()
+	var keys []string
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("key-%060d", i*7)
+		keys = append(keys, key)
+		art.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	sort.Strings(keys)
+
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 200; n++ {
+		seek := fmt.Sprintf("key-%060d", r.Intn(5000*7+10))
+		for _, reverse := range []bool{false, true} {
+			i := sort.SearchStrings(keys, seek)
+			if reverse && (i == len(keys) || keys[i] != seek) {
+				i--
+			}
+			iterator := art.Iterator(reverse)
+			iterator.Seek([]byte(seek))
+			for c := 0; c < 100 && i >= 0 && i < len(keys); c++ {
+				assert.True(t, iterator.Valid())
+				assert.Equal(t, keys[i], string(iterator.Key()))
+				iterator.Next()
+				if reverse {
+					i--
+				} else {
+					i++
+				}
+			}
+			iterator.Close()
+		}
+	}
+
+	// 删除最大的 key 之后反向遍历从新的最大 key 开始
+	for _, key := range keys[len(keys)-3:] {
+		art.Delete([]byte(key))
+	}
+	iterator := art.Iterator(true)
+	assert.True(t, iterator.Valid())
+	assert.Equal(t, keys[len(keys)-4], string(iterator.Key()))
+	iterator.Close()
+
+	for _, key := range keys[:len(keys)-3] {
+		art.Delete([]byte(key))
+	}
+	iterator = art.Iterator(true)
+	assert.False(t, iterator.Valid())
+	iterator.Close()
+}
@@ -2,12 +2,14 @@ package index
 
 import (
 	"GoKeeper/data"
+	"bytes"
 	"go.etcd.io/bbolt"
 	"log"
 	"path/filepath"
 )
 
-const bptreeIndexFileName = "bptree-index"
+// BPlusTreeIndexFileName B+ 树索引文件的名称
+const BPlusTreeIndexFileName = "bptree-index"
 
 var indexBucketName = []byte("goKeeper-index")
 
@@ -21,7 +23,7 @@ type BPlusTree struct {
 func NewBPlusTree(dirPath string, syncWrite bool) *BPlusTree {
 	opts := bbolt.DefaultOptions
 	opts.NoSync = !syncWrite
-	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opts)
+	bptree, err := bbolt.Open(filepath.Join(dirPath, BPlusTreeIndexFileName), 0644, opts)
 	if err != nil {
 		log.Println(err)
 		panic("failed to open bptree")
@@ -110,7 +112,11 @@ func (bpt *BPlusTree) Close() error {
 }
 
 func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
-	return newBpTreeIterator(bpt.tree, reverse)
+	return bpt.RangeIterator(reverse, nil, nil)
+}
+
+func (bpt *BPlusTree) RangeIterator(reverse bool, lowerBound []byte, upperBound []byte) Iterator {
+	return newBpTreeIterator(bpt.tree, reverse, lowerBound, upperBound)
 }
 
 // B+树迭代器
@@ -118,36 +124,74 @@ type bpTreeIterator struct {
 	tx           *bbolt.Tx
 	cursor       *bbolt.Cursor
 	reverse      bool
+	lowerBound   []byte
+	upperBound   []byte
 	currentKey   []byte
 	currentValue []byte
 }
 
-// newBpTreeIterator 创建一个迭代器
-func newBpTreeIterator(tree *bbolt.DB, reverse bool) *bpTreeIterator {
+// newBpTreeIterator 创建一个迭代器, 只遍历 [lowerBound, upperBound) 范围内的 key
+func newBpTreeIterator(tree *bbolt.DB, reverse bool, lowerBound []byte, upperBound []byte) *bpTreeIterator {
 	tx, err := tree.Begin(false)
 	if err != nil {
 		panic("failed to begin transaction")
 	}
 	// 初始化
 	bpi := &bpTreeIterator{
-		tx:      tx,
-		cursor:  tx.Bucket(indexBucketName).Cursor(),
-		reverse: reverse,
+		tx:         tx,
+		cursor:     tx.Bucket(indexBucketName).Cursor(),
+		reverse:    reverse,
+		lowerBound: lowerBound,
+		upperBound: upperBound,
 	}
 	bpi.Rewind()
 	return bpi
 }
 
 func (bpTi *bpTreeIterator) Rewind() {
-	if bpTi.reverse {
+	switch {
+	case bpTi.reverse && len(bpTi.upperBound) != 0:
+		bpTi.seekBefore(bpTi.upperBound)
+	case bpTi.reverse:
 		bpTi.currentKey, bpTi.currentValue = bpTi.cursor.Last()
-	} else {
+	case len(bpTi.lowerBound) != 0:
+		bpTi.currentKey, bpTi.currentValue = bpTi.cursor.Seek(bpTi.lowerBound)
+	default:
 		bpTi.currentKey, bpTi.currentValue = bpTi.cursor.First()
 	}
 }
 
 func (bpTi *bpTreeIterator) Seek(key []byte) {
+	if !bpTi.reverse {
+		// 小于下界时直接从下界开始
+		if len(bpTi.lowerBound) != 0 && bytes.Compare(key, bpTi.lowerBound) < 0 {
+			key = bpTi.lowerBound
+		}
+		bpTi.currentKey, bpTi.currentValue = bpTi.cursor.Seek(key)
+		return
+	}
+
+	// 反向遍历找到第一个小于等于 key 的位置, 不能超过上界
+	if len(bpTi.upperBound) != 0 && bytes.Compare(key, bpTi.upperBound) >= 0 {
+		bpTi.seekBefore(bpTi.upperBound)
+		return
+	}
 	bpTi.currentKey, bpTi.currentValue = bpTi.cursor.Seek(key)
+	if bpTi.currentKey == nil {
+		bpTi.currentKey, bpTi.currentValue = bpTi.cursor.Last()
+	} else if !bytes.Equal(bpTi.currentKey, key) {
+		bpTi.currentKey, bpTi.currentValue = bpTi.cursor.Prev()
+	}
+}
+
+// seekBefore 定位到第一个小于 key 的位置
+func (bpTi *bpTreeIterator) seekBefore(key []byte) {
+	bpTi.currentKey, bpTi.currentValue = bpTi.cursor.Seek(key)
+	if bpTi.currentKey == nil {
+		bpTi.currentKey, bpTi.currentValue = bpTi.cursor.Last()
+	} else {
+		bpTi.currentKey, bpTi.currentValue = bpTi.cursor.Prev()
+	}
 }
 
 func (bpTi *bpTreeIterator) Next() {
@@ -159,9 +203,8 @@ func (bpTi *bpTreeIterator) Next() {
 }
 
 func (bpTi *bpTreeIterator) Valid() bool {
-	return len(bpTi.currentKey) != 0
+	return len(bpTi.currentKey) != 0 && inRange(bpTi.currentKey, bpTi.lowerBound, bpTi.upperBound)
 }
-
 func (bpTi *bpTreeIterator) Key() []byte {
 	return bpTi.currentKey
 }
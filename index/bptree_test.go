@@ -157,3 +157,9 @@ func TestBPlusTree_Iterator(t *testing.T) {
 
 	iter.Close()
 }
+
+func TestBPlusTree_RangeIterator(t *testing.T) {
+	tree := NewBPlusTree(t.TempDir(), false)
+	defer tree.Close()
+	testRangeIterator(t, tree)
+}
@@ -4,7 +4,6 @@ import (
 	"GoKeeper/data"
 	"bytes"
 	"github.com/google/btree"
-	"sort"
 	"sync"
 )
 
@@ -39,6 +38,8 @@ func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	it := &Item{key: key}
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	if item := bt.tree.Get(it); item == nil {
 		return nil
 	} else {
@@ -67,91 +68,59 @@ func (bt *BTree) Close() error {
 }
 
 func (bt *BTree) Iterator(reverse bool) Iterator {
+	return bt.RangeIterator(reverse, nil, nil)
+}
+
+// RangeIterator 返回范围迭代器
+// 迭代器遍历的是 BTree 写时复制的克隆, 创建克隆的代价是 O(1) 的, 之后的写入不会影响迭代器
+func (bt *BTree) RangeIterator(reverse bool, lowerBound []byte, upperBound []byte) Iterator {
 	if bt.tree == nil {
 		return nil
 	}
-	bt.lock.RLock()
-	defer bt.lock.RUnlock()
-	return NewBTreeIterator(bt.tree, reverse)
+	// Clone 会修改原来的树, 不能和其他操作并发执行
+	bt.lock.Lock()
+	tree := bt.tree.Clone()
+	bt.lock.Unlock()
+	return NewBTreeIterator(tree, reverse, lowerBound, upperBound)
 }
 
 // BTreeIterator  索引迭代器
+// 按批次从 BTree 中读取数据, 不会一次性把所有数据复制出来
 type BTreeIterator struct {
-	// 记录遍历到了哪个位置
-	currIndex int
-
-	// 是否反向遍历
-	reverse bool
-
-	// key + 位置索引信息
-	values []*Item
-}
-
-func NewBTreeIterator(tree *btree.BTree, reverse bool) *BTreeIterator {
-	var idx int
-	values := make([]*Item, tree.Len())
-
-	// 将所有数据存放到数组中
-	saveValues := func(item btree.Item) bool {
-		values[idx] = item.(*Item)
-		idx++
-		return true
+	*batchIterator
+}
+
+// NewBTreeIterator 创建 BTree 迭代器, 只遍历 [lowerBound, upperBound) 范围内的数据, 边界为空表示不限制
+// 迭代器会直接读取 tree, 调用方需要保证 tree 在遍历期间不会被修改
+func NewBTreeIterator(tree *btree.BTree, reverse bool, lowerBound []byte, upperBound []byte) *BTreeIterator {
+	fetch := func(start []byte, inclusive bool, n int) []*Item {
+		items := make([]*Item, 0, n)
+		saveValues := func(item btree.Item) bool {
+			it := item.(*Item)
+			if !inclusive && bytes.Equal(it.key, start) {
+				return true
+			}
+			items = append(items, it)
+			return len(items) < n
+		}
+
+		switch {
+		case reverse && start == nil:
+			// [Last, First]
+			tree.Descend(saveValues)
+		case reverse:
+			// [start, First]
+			tree.DescendLessOrEqual(&Item{key: start}, saveValues)
+		case start == nil:
+			// [First, Last]
+			tree.Ascend(saveValues)
+		default:
+			// [start, Last]
+			tree.AscendGreaterOrEqual(&Item{key: start}, saveValues)
+		}
+		return items
 	}
-
-	if reverse {
-		// [Last, First] 为 BTree 中的每个元素执行上面的逻辑
-		tree.Descend(saveValues)
-	} else {
-		// [First, Last]
-		tree.Ascend(saveValues)
-	}
-
 	return &BTreeIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		values:    values,
+		batchIterator: newBatchIterator(reverse, lowerBound, upperBound, fetch),
 	}
 }
-
-// Rewind 重置迭代器
-func (bti *BTreeIterator) Rewind() {
-	bti.currIndex = 0
-}
-
-// Seek 根据传入的key 查找到第一个大于(或小于)等于的目标 key, 根据从这key开始遍历
-func (bti *BTreeIterator) Seek(key []byte) {
-	if bti.reverse {
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) <= 0
-		})
-	} else {
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) >= 0
-		})
-	}
-}
-
-// Next 跳转到下一个 key
-func (bti *BTreeIterator) Next() {
-	bti.currIndex++
-}
-
-// Valid 判断是否还有下一个 key
-func (bti *BTreeIterator) Valid() bool {
-	return bti.currIndex < len(bti.values)
-}
-
-// Key 返回当前 key
-func (bti *BTreeIterator) Key() []byte {
-	return bti.values[bti.currIndex].key
-}
-
-// Value 返回当前 key 对应的 value
-func (bti *BTreeIterator) Value() *data.LogRecordPos {
-	return bti.values[bti.currIndex].pos
-}
-
-// Close 关闭迭代器
-func (bti *BTreeIterator) Close() {
-	bti.values = nil
-}
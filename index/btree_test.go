@@ -2,6 +2,7 @@ package index
 
 import (
 	"GoKeeper/data"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"strconv"
 	"strings"
@@ -241,3 +242,30 @@ func TestBTree_Iterator(t *testing.T) {
 		t.Log(string(iterator3.Key()))
 	}
 }
+
+func TestBTree_RangeIterator(t *testing.T) {
+	testRangeIterator(t, NewBTree())
+}
+
+func TestBTree_IteratorClone(t *testing.T) {
+	bt := NewBTree()
+	for i := 0; i < 1000; i++ {
+		bt.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// 创建迭代器之后的写入对迭代器不可见
+	iterator := bt.Iterator(false)
+	defer iterator.Close()
+	for i := 0; i < 1000; i += 2 {
+		bt.Delete([]byte(fmt.Sprintf("key-%04d", i)))
+	}
+	bt.Put([]byte("key-9999"), &data.LogRecordPos{Fid: 1, Offset: 9999})
+
+	var count int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%04d", count)), iterator.Key())
+		count++
+	}
+	assert.Equal(t, 1000, count)
+	assert.Equal(t, 501, bt.Size())
+}
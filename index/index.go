@@ -24,6 +24,10 @@ type Index interface {
 	// Iterator 返回迭代器
 	Iterator(reverse bool) Iterator
 
+	// RangeIterator 返回只遍历 [lowerBound, upperBound) 范围内 key 的迭代器
+	// 边界为空表示不限制, 迭代器直接定位到边界, 超出范围之后停止遍历
+	RangeIterator(reverse bool, lowerBound []byte, upperBound []byte) Iterator
+
 	// Close 关闭索引
 	Close() error
 }
@@ -40,9 +44,16 @@ const (
 
 	// BPTree B+Tree 索引
 	BPTree
+
+	// Sharded 按照 key 的哈希值分片的 BTree 索引
+	Sharded
+
+	// SkipList 并发跳表索引
+	SkipList
 )
 
-func NewIndexer(indexType IndexType, dirPath string, sync bool) Index {
+// NewIndexer 创建索引, shards 为分片索引的分片数量
+func NewIndexer(indexType IndexType, dirPath string, sync bool, shards int) Index {
 	switch indexType {
 	case Btree:
 		return NewBTree()
@@ -52,10 +63,13 @@ func NewIndexer(indexType IndexType, dirPath string, sync bool) Index {
 	case BPTree:
 		//return nil
 		return NewBPlusTree(dirPath, sync)
+	case Sharded:
+		return NewShardedIndex(shards)
+	case SkipList:
+		return NewSkipList()
 	default:
 		panic("unsupported index type")
 	}
-	return nil
 }
 
 // Item 因为BTree insert,get,delete需要Item,所以自己定义一个Item
@@ -69,6 +83,14 @@ func (i Item) Less(bi btree.Item) bool {
 	return bytes.Compare(i.key, bi.(*Item).key) == -1
 }
 
+// inRange 判断 key 是否在 [lowerBound, upperBound) 范围内, 边界为空表示不限制
+func inRange(key []byte, lowerBound []byte, upperBound []byte) bool {
+	if len(lowerBound) != 0 && bytes.Compare(key, lowerBound) < 0 {
+		return false
+	}
+	return len(upperBound) == 0 || bytes.Compare(key, upperBound) < 0
+}
+
 // Iterator  通用的所有迭代器的接口
 type Iterator interface {
 	// Rewind  重新回到迭代器的起点,即第一个数据
@@ -0,0 +1,159 @@
+package index
+
+import (
+	"GoKeeper/data"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"testing"
+)
+
+// testRangeIterator 对比索引迭代器和排好序的 key 列表, 检查范围遍历和 Seek 的结果
+func testRangeIterator(t *testing.T, idx Index) {
+	// key 中包含互为前缀的 key 和 0xff 字节, 覆盖基数树回退的边界情况
+	keySet := map[string]struct{}{}
+	r := rand.New(rand.NewSource(1))
+	for len(keySet) < 500 {
+		key := make([]byte, 1+r.Intn(4))
+		for i := range key {
+			key[i] = []byte{'a', 'b', 'c', 0x00, 0xff}[r.Intn(5)]
+		}
+		keySet[string(key)] = struct{}{}
+	}
+	var keys [][]byte
+	for key := range keySet {
+		keys = append(keys, []byte(key))
+		idx.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: int64(len(keys))})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+
+	// expected 按照遍历顺序返回 [lowerBound, upperBound) 范围内并且从 seek 开始的 key
+	expected := func(reverse bool, lowerBound, upperBound, seek []byte) [][]byte {
+		var result [][]byte
+		for _, key := range keys {
+			if !inRange(key, lowerBound, upperBound) {
+				continue
+			}
+			if seek != nil && ((!reverse && bytes.Compare(key, seek) < 0) || (reverse && bytes.Compare(key, seek) > 0)) {
+				continue
+			}
+			result = append(result, key)
+		}
+		if reverse {
+			for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+				result[i], result[j] = result[j], result[i]
+			}
+		}
+		return result
+	}
+	collect := func(iter Iterator, seek []byte) [][]byte {
+		var result [][]byte
+		if seek != nil {
+			iter.Seek(seek)
+		} else {
+			iter.Rewind()
+		}
+		for ; iter.Valid(); iter.Next() {
+			result = append(result, iter.Key())
+		}
+		return result
+	}
+
+	bounds := [][]byte{nil, []byte("a"), []byte("ab"), []byte("b\xff"), []byte("c\x00"), []byte("\xff\xff"), []byte("zz")}
+	for _, reverse := range []bool{false, true} {
+		for _, lowerBound := range bounds {
+			for _, upperBound := range bounds {
+				iter := idx.RangeIterator(reverse, lowerBound, upperBound)
+				assert.Equal(t, expected(reverse, lowerBound, upperBound, nil), collect(iter, nil))
+				for _, seek := range bounds[1:] {
+					assert.Equal(t, expected(reverse, lowerBound, upperBound, seek), collect(iter, seek))
+				}
+				iter.Close()
+			}
+		}
+	}
+}
+
+// benchIndexKeys 索引 benchmark 预先写入的 key 的数量
+const benchIndexKeys = 100000
+
+// BenchmarkIndex 比较内存索引的读写和遍历性能
+// 可以通过 -cpu 1,4,16 比较并发写入时不同索引上锁的竞争
+func BenchmarkIndex(b *testing.B) {
+	indexes := []struct {
+		name string
+		new  func() Index
+	}{
+		{"BTree", func() Index { return NewBTree() }},
+		{"ART", func() Index { return NewART() }},
+		{"Sharded", func() Index { return NewShardedIndex(16) }},
+		{"SkipList", func() Index { return NewSkipList() }},
+	}
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key-%09d", i)) }
+	// 64 字节的长 key, 遍历时 Seek 的代价和 key 的长度有关
+	key64 := func(i int) []byte { return []byte(fmt.Sprintf("key-%060d", i)) }
+	pos := &data.LogRecordPos{Fid: 1, Offset: 1}
+
+	for _, idx := range indexes {
+		prepare := func(key func(int) []byte) Index {
+			index := idx.new()
+			for i := 0; i < benchIndexKeys; i++ {
+				index.Put(key(i), pos)
+			}
+			return index
+		}
+		iterate := func(key func(int) []byte, reverse bool) func(b *testing.B) {
+			return func(b *testing.B) {
+				index := prepare(key)
+				b.ResetTimer()
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					// 每次遍历 100 个 key, 包括创建迭代器和 Seek 的代价
+					iter := index.Iterator(reverse)
+					iter.Seek(key(rand.Intn(benchIndexKeys)))
+					for n := 0; n < 100 && iter.Valid(); n++ {
+						iter.Next()
+					}
+					iter.Close()
+				}
+			}
+		}
+
+		b.Run(idx.name+"/Put", func(b *testing.B) {
+			index := idx.new()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				index.Put(key(i), pos)
+			}
+		})
+		b.Run(idx.name+"/PutParallel", func(b *testing.B) {
+			index := idx.new()
+			var seq atomic.Int64
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					index.Put(key(int(seq.Add(1))), pos)
+				}
+			})
+		})
+		b.Run(idx.name+"/GetParallel", func(b *testing.B) {
+			index := prepare(key)
+			b.ResetTimer()
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewSource(rand.Int63()))
+				for pb.Next() {
+					index.Get(key(r.Intn(benchIndexKeys)))
+				}
+			})
+		})
+		b.Run(idx.name+"/Iterate", iterate(key, false))
+		b.Run(idx.name+"/IterateKey64", iterate(key64, false))
+		b.Run(idx.name+"/ReverseIterateKey64", iterate(key64, true))
+	}
+}
@@ -0,0 +1,120 @@
+package index
+
+import (
+	"GoKeeper/data"
+)
+
+// iteratorBatchSize 迭代器每次从索引中读取的数据量
+const iteratorBatchSize = 64
+
+// batchIterator 按批次从索引中读取数据的迭代器
+// 每次只读取当前位置之后的一小批数据, 内存占用和索引中 key 的数量无关
+type batchIterator struct {
+	// 是否反向遍历
+	reverse bool
+
+	// 遍历范围 [lowerBound, upperBound)
+	lowerBound []byte
+	upperBound []byte
+
+	// fetch 从 start 开始按照遍历顺序读取最多 n 条数据
+	// start 为 nil 表示从头(反向时从尾)开始, inclusive 表示是否包含 start 本身
+	fetch func(start []byte, inclusive bool, n int) []*Item
+
+	// 当前批次的数据以及遍历到的位置
+	items     []*Item
+	currIndex int
+
+	// 索引中已经没有更多的数据
+	exhausted bool
+}
+
+func newBatchIterator(reverse bool, lowerBound []byte, upperBound []byte,
+	fetch func(start []byte, inclusive bool, n int) []*Item) *batchIterator {
+	if len(lowerBound) == 0 {
+		lowerBound = nil
+	}
+	if len(upperBound) == 0 {
+		upperBound = nil
+	}
+	bi := &batchIterator{
+		reverse:    reverse,
+		lowerBound: lowerBound,
+		upperBound: upperBound,
+		fetch:      fetch,
+	}
+	bi.Rewind()
+	return bi
+}
+
+// Rewind 重新回到迭代器的起点, 有边界时直接定位到边界
+func (bi *batchIterator) Rewind() {
+	if bi.reverse {
+		bi.load(bi.upperBound, false)
+	} else {
+		bi.load(bi.lowerBound, true)
+	}
+}
+
+// Seek 根据传入的 key 查找到第一个大于(或小于)等于的目标 key, 超出边界时从边界开始
+func (bi *batchIterator) Seek(key []byte) {
+	switch {
+	case bi.reverse && bi.upperBound != nil && !inRange(key, nil, bi.upperBound):
+		bi.load(bi.upperBound, false)
+	case !bi.reverse && bi.lowerBound != nil && inRange(key, nil, bi.lowerBound):
+		bi.load(bi.lowerBound, true)
+	default:
+		bi.load(key, true)
+	}
+}
+
+// Next 跳转到下一个 key, 当前批次遍历完之后从最后一个 key 之后继续读取
+func (bi *batchIterator) Next() {
+	if bi.currIndex >= len(bi.items) {
+		return
+	}
+	bi.currIndex++
+	if bi.currIndex == len(bi.items) && !bi.exhausted {
+		bi.load(bi.items[len(bi.items)-1].key, false)
+	}
+}
+
+// Valid 是否有效,即是否已经遍历完了范围内所有的 key
+func (bi *batchIterator) Valid() bool {
+	return bi.currIndex < len(bi.items)
+}
+
+// Key 当前遍历位置的 key
+func (bi *batchIterator) Key() []byte {
+	return bi.items[bi.currIndex].key
+}
+
+// Value 当前遍历位置的位置信息
+func (bi *batchIterator) Value() *data.LogRecordPos {
+	return bi.items[bi.currIndex].pos
+}
+
+// Close 关闭迭代器
+func (bi *batchIterator) Close() {
+	bi.items, bi.currIndex, bi.exhausted = nil, 0, true
+	bi.fetch = nil
+}
+
+// load 从 start 开始读取一批数据, 超出遍历范围的数据直接丢弃
+func (bi *batchIterator) load(start []byte, inclusive bool) {
+	bi.currIndex = 0
+	if bi.fetch == nil {
+		bi.items, bi.exhausted = nil, true
+		return
+	}
+	bi.items = bi.fetch(start, inclusive, iteratorBatchSize)
+	bi.exhausted = len(bi.items) < iteratorBatchSize
+
+	// 数据是按照遍历顺序排列的, 第一个超出范围的 key 之后都超出了范围
+	for i, item := range bi.items {
+		if !inRange(item.key, bi.lowerBound, bi.upperBound) {
+			bi.items, bi.exhausted = bi.items[:i], true
+			break
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package index
+
+import (
+	"GoKeeper/data"
+	"bytes"
+	"container/heap"
+)
+
+// ShardedIndex 分片索引
+// key 按照哈希值分散到多个 BTree 分片中, 每个分片有自己的锁, 不同分片的写入不会互相阻塞
+// 遍历时把所有分片的迭代器合并起来, 保持全局的 key 顺序
+type ShardedIndex struct {
+	shards []Index
+}
+
+// NewShardedIndex 创建分片数量为 n 的分片索引
+func NewShardedIndex(n int) *ShardedIndex {
+	if n <= 0 {
+		n = 1
+	}
+	shards := make([]Index, n)
+	for i := range shards {
+		shards[i] = NewBTree()
+	}
+	return &ShardedIndex{shards: shards}
+}
+
+// shard 根据 key 的 FNV-1a 哈希值找到 key 所在的分片
+func (si *ShardedIndex) shard(key []byte) Index {
+	hash := uint32(2166136261)
+	for _, b := range key {
+		hash ^= uint32(b)
+		hash *= 16777619
+	}
+	return si.shards[hash%uint32(len(si.shards))]
+}
+
+func (si *ShardedIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	return si.shard(key).Put(key, pos)
+}
+
+func (si *ShardedIndex) Get(key []byte) *data.LogRecordPos {
+	return si.shard(key).Get(key)
+}
+
+func (si *ShardedIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	return si.shard(key).Delete(key)
+}
+
+func (si *ShardedIndex) Size() int {
+	var size int
+	for _, shard := range si.shards {
+		size += shard.Size()
+	}
+	return size
+}
+
+func (si *ShardedIndex) Close() error {
+	for _, shard := range si.shards {
+		if err := shard.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (si *ShardedIndex) Iterator(reverse bool) Iterator {
+	return si.RangeIterator(reverse, nil, nil)
+}
+
+// RangeIterator 返回合并了所有分片的范围迭代器
+// 每个分片的迭代器单独创建, 不同分片看到的不是同一时刻的数据
+func (si *ShardedIndex) RangeIterator(reverse bool, lowerBound []byte, upperBound []byte) Iterator {
+	iters := make([]Iterator, len(si.shards))
+	for i, shard := range si.shards {
+		iters[i] = shard.RangeIterator(reverse, lowerBound, upperBound)
+	}
+	return newMergeIterator(iters, reverse)
+}
+
+// mergeIterator 多路归并迭代器, 按照遍历顺序合并多个 key 不重叠的迭代器
+type mergeIterator struct {
+	iters []Iterator
+	heap  iteratorHeap
+}
+
+func newMergeIterator(iters []Iterator, reverse bool) *mergeIterator {
+	mi := &mergeIterator{
+		iters: iters,
+		heap:  iteratorHeap{reverse: reverse},
+	}
+	mi.init()
+	return mi
+}
+
+// init 把所有有效的迭代器放入堆中, 堆顶是遍历顺序中的下一个 key
+func (mi *mergeIterator) init() {
+	mi.heap.iters = mi.heap.iters[:0]
+	for _, iter := range mi.iters {
+		if iter.Valid() {
+			mi.heap.iters = append(mi.heap.iters, iter)
+		}
+	}
+	heap.Init(&mi.heap)
+}
+
+func (mi *mergeIterator) Rewind() {
+	for _, iter := range mi.iters {
+		iter.Rewind()
+	}
+	mi.init()
+}
+
+func (mi *mergeIterator) Seek(key []byte) {
+	for _, iter := range mi.iters {
+		iter.Seek(key)
+	}
+	mi.init()
+}
+
+func (mi *mergeIterator) Next() {
+	if !mi.Valid() {
+		return
+	}
+	top := mi.heap.iters[0]
+	top.Next()
+	if top.Valid() {
+		heap.Fix(&mi.heap, 0)
+	} else {
+		heap.Pop(&mi.heap)
+	}
+}
+
+func (mi *mergeIterator) Valid() bool {
+	return len(mi.heap.iters) > 0
+}
+
+func (mi *mergeIterator) Key() []byte {
+	return mi.heap.iters[0].Key()
+}
+
+func (mi *mergeIterator) Value() *data.LogRecordPos {
+	return mi.heap.iters[0].Value()
+}
+
+func (mi *mergeIterator) Close() {
+	for _, iter := range mi.iters {
+		iter.Close()
+	}
+	mi.heap.iters = nil
+}
+
+// iteratorHeap 按照当前 key 排序的迭代器堆, 反向遍历时 key 大的在前
+type iteratorHeap struct {
+	iters   []Iterator
+	reverse bool
+}
+
+func (h *iteratorHeap) Len() int {
+	return len(h.iters)
+}
+
+func (h *iteratorHeap) Less(i, j int) bool {
+	cmp := bytes.Compare(h.iters[i].Key(), h.iters[j].Key())
+	if h.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func (h *iteratorHeap) Swap(i, j int) {
+	h.iters[i], h.iters[j] = h.iters[j], h.iters[i]
+}
+
+func (h *iteratorHeap) Push(x any) {
+	h.iters = append(h.iters, x.(Iterator))
+}
+
+func (h *iteratorHeap) Pop() any {
+	last := h.iters[len(h.iters)-1]
+	h.iters = h.iters[:len(h.iters)-1]
+	return last
+}
@@ -0,0 +1,71 @@
+package index
+
+import (
+	"GoKeeper/data"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestShardedIndex_PutGetDelete(t *testing.T) {
+	si := NewShardedIndex(4)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, si.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)}))
+	}
+	assert.Equal(t, 100, si.Size())
+	// key 分散到了所有的分片中
+	for _, shard := range si.shards {
+		assert.True(t, shard.Size() > 0)
+	}
+
+	old := si.Put([]byte("key-001"), &data.LogRecordPos{Fid: 2, Offset: 1})
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 1}, old)
+	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 1}, si.Get([]byte("key-001")))
+
+	pos, ok := si.Delete([]byte("key-002"))
+	assert.True(t, ok)
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 2}, pos)
+	assert.Nil(t, si.Get([]byte("key-002")))
+	_, ok = si.Delete([]byte("key-002"))
+	assert.False(t, ok)
+	assert.Equal(t, 99, si.Size())
+}
+
+func TestShardedIndex_RangeIterator(t *testing.T) {
+	testRangeIterator(t, NewShardedIndex(7))
+	// 只有一个分片时和 BTree 一样
+	testRangeIterator(t, NewShardedIndex(1))
+}
+
+func TestShardedIndex_ConcurrentWrite(t *testing.T) {
+	si := NewShardedIndex(8)
+	wg := new(sync.WaitGroup)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := []byte(fmt.Sprintf("key-%d-%04d", w, i))
+				si.Put(key, &data.LogRecordPos{Fid: uint32(w), Offset: int64(i)})
+				assert.NotNil(t, si.Get(key))
+			}
+		}(w)
+	}
+	wg.Wait()
+	assert.Equal(t, 8000, si.Size())
+
+	// 合并之后的迭代器保持全局的 key 顺序
+	iterator := si.Iterator(true)
+	defer iterator.Close()
+	var count int
+	var prev []byte
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if prev != nil {
+			assert.True(t, string(iterator.Key()) < string(prev))
+		}
+		prev = iterator.Key()
+		count++
+	}
+	assert.Equal(t, 8000, count)
+}
@@ -0,0 +1,263 @@
+package index
+
+import (
+	"GoKeeper/data"
+	"bytes"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// skipListMaxLevel 跳表的最大层数, 每层的节点数量是下一层的 1/4, 足够容纳 4^16 个 key
+	skipListMaxLevel = 16
+
+	// skipListBranching 节点升高一层的概率为 1/skipListBranching
+	skipListBranching = 4
+)
+
+// skipListNode 跳表节点, key 不会修改, 位置信息和每层的后继节点通过原子操作读写
+type skipListNode struct {
+	key  []byte
+	pos  atomic.Pointer[data.LogRecordPos]
+	next []atomic.Pointer[skipListNode]
+}
+
+func newSkipListNode(key []byte, pos *data.LogRecordPos, level int) *skipListNode {
+	node := &skipListNode{
+		key:  key,
+		next: make([]atomic.Pointer[skipListNode], level),
+	}
+	node.pos.Store(pos)
+	return node
+}
+
+// ConcurrentSkipList 并发跳表索引
+// 写入之间通过互斥锁串行执行, 读取和遍历不加锁, 可以和写入并发执行
+// 新节点先设置好所有的后继节点再原子地链接到前驱节点上, 删除的节点保留原来的后继节点,
+// 正在访问这个节点的读取可以继续向后遍历
+type ConcurrentSkipList struct {
+	head   *skipListNode
+	height atomic.Int32 // 当前使用的层数
+	size   atomic.Int64
+	lock   sync.Mutex // 串行化写入
+}
+
+func NewSkipList() *ConcurrentSkipList {
+	sl := &ConcurrentSkipList{
+		head: newSkipListNode(nil, nil, skipListMaxLevel),
+	}
+	sl.height.Store(1)
+	return sl
+}
+
+// randomLevel 随机生成新节点的层数
+func randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.IntN(skipListBranching) == 0 {
+		level++
+	}
+	return level
+}
+
+// findGreaterOrEqual 找到第一个大于等于 key 的节点, 不存在时返回 nil
+// prevs 不为 nil 时记录每一层中最后一个小于 key 的节点
+func (sl *ConcurrentSkipList) findGreaterOrEqual(key []byte, prevs []*skipListNode) *skipListNode {
+	x := sl.head
+	for level := int(sl.height.Load()) - 1; level >= 0; level-- {
+		next := x.next[level].Load()
+		for next != nil && bytes.Compare(next.key, key) < 0 {
+			x = next
+			next = x.next[level].Load()
+		}
+		if prevs != nil {
+			prevs[level] = x
+		}
+		if level == 0 {
+			return next
+		}
+	}
+	return nil
+}
+
+// findLess 找到最后一个小于 key 的节点, orEqual 为 true 时包括等于 key 的节点, 不存在时返回 nil
+func (sl *ConcurrentSkipList) findLess(key []byte, orEqual bool) *skipListNode {
+	x := sl.head
+	for level := int(sl.height.Load()) - 1; level >= 0; level-- {
+		for next := x.next[level].Load(); next != nil; next = x.next[level].Load() {
+			cmp := bytes.Compare(next.key, key)
+			if cmp > 0 || (cmp == 0 && !orEqual) {
+				break
+			}
+			x = next
+		}
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
+
+// findLast 找到最后一个节点, 跳表为空时返回 nil
+func (sl *ConcurrentSkipList) findLast() *skipListNode {
+	x := sl.head
+	for level := int(sl.height.Load()) - 1; level >= 0; level-- {
+		for next := x.next[level].Load(); next != nil; next = x.next[level].Load() {
+			x = next
+		}
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
+
+func (sl *ConcurrentSkipList) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	var prevs [skipListMaxLevel]*skipListNode
+	if node := sl.findGreaterOrEqual(key, prevs[:]); node != nil && bytes.Equal(node.key, key) {
+		return node.pos.Swap(pos)
+	}
+
+	level := randomLevel()
+	if height := int(sl.height.Load()); level > height {
+		for i := height; i < level; i++ {
+			prevs[i] = sl.head
+		}
+		// 读取看到新的层数时, 头节点在新的层上可能还没有后继节点, 相当于这一层是空的
+		sl.height.Store(int32(level))
+	}
+	node := newSkipListNode(key, pos, level)
+	for i := 0; i < level; i++ {
+		node.next[i].Store(prevs[i].next[i].Load())
+		prevs[i].next[i].Store(node)
+	}
+	sl.size.Add(1)
+	return nil
+}
+
+func (sl *ConcurrentSkipList) Get(key []byte) *data.LogRecordPos {
+	node := sl.findGreaterOrEqual(key, nil)
+	if node == nil || !bytes.Equal(node.key, key) {
+		return nil
+	}
+	return node.pos.Load()
+}
+
+func (sl *ConcurrentSkipList) Delete(key []byte) (*data.LogRecordPos, bool) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	var prevs [skipListMaxLevel]*skipListNode
+	node := sl.findGreaterOrEqual(key, prevs[:])
+	if node == nil || !bytes.Equal(node.key, key) {
+		return nil, false
+	}
+	// 从上到下摘除节点, 节点自己的后继节点保持不变
+	for i := len(node.next) - 1; i >= 0; i-- {
+		prevs[i].next[i].Store(node.next[i].Load())
+	}
+	sl.size.Add(-1)
+	return node.pos.Load(), true
+}
+
+func (sl *ConcurrentSkipList) Size() int {
+	return int(sl.size.Load())
+}
+
+func (sl *ConcurrentSkipList) Close() error {
+	return nil
+}
+
+func (sl *ConcurrentSkipList) Iterator(reverse bool) Iterator {
+	return sl.RangeIterator(reverse, nil, nil)
+}
+
+// RangeIterator 返回范围迭代器
+// 迭代器直接在跳表上遍历, 不需要复制数据, 遍历过程中的写入可能看得到也可能看不到
+func (sl *ConcurrentSkipList) RangeIterator(reverse bool, lowerBound []byte, upperBound []byte) Iterator {
+	if len(lowerBound) == 0 {
+		lowerBound = nil
+	}
+	if len(upperBound) == 0 {
+		upperBound = nil
+	}
+	iter := &skipListIterator{
+		list:       sl,
+		reverse:    reverse,
+		lowerBound: lowerBound,
+		upperBound: upperBound,
+	}
+	iter.Rewind()
+	return iter
+}
+
+// skipListIterator 跳表迭代器
+// 正向遍历沿着最底层的链表向后移动, 反向遍历时每次查找最后一个小于当前 key 的节点
+type skipListIterator struct {
+	list    *ConcurrentSkipList
+	node    *skipListNode
+	reverse bool
+
+	// 遍历范围 [lowerBound, upperBound)
+	lowerBound []byte
+	upperBound []byte
+}
+
+// Rewind 重新回到迭代器的起点, 有边界时直接定位到边界
+func (it *skipListIterator) Rewind() {
+	switch {
+	case it.reverse && it.upperBound != nil:
+		it.node = it.list.findLess(it.upperBound, false)
+	case it.reverse:
+		it.node = it.list.findLast()
+	case it.lowerBound != nil:
+		it.node = it.list.findGreaterOrEqual(it.lowerBound, nil)
+	default:
+		it.node = it.list.head.next[0].Load()
+	}
+}
+
+// Seek 根据传入的 key 查找到第一个大于(或小于)等于的目标 key, 超出边界时从边界开始
+func (it *skipListIterator) Seek(key []byte) {
+	switch {
+	case it.reverse && it.upperBound != nil && !inRange(key, nil, it.upperBound):
+		it.node = it.list.findLess(it.upperBound, false)
+	case it.reverse:
+		it.node = it.list.findLess(key, true)
+	case it.lowerBound != nil && inRange(key, nil, it.lowerBound):
+		it.node = it.list.findGreaterOrEqual(it.lowerBound, nil)
+	default:
+		it.node = it.list.findGreaterOrEqual(key, nil)
+	}
+}
+
+func (it *skipListIterator) Next() {
+	if !it.Valid() {
+		return
+	}
+	if it.reverse {
+		it.node = it.list.findLess(it.node.key, false)
+	} else {
+		it.node = it.node.next[0].Load()
+	}
+}
+
+// Valid 是否有效,即是否已经遍历完了范围内所有的 key
+func (it *skipListIterator) Valid() bool {
+	return it.node != nil && inRange(it.node.key, it.lowerBound, it.upperBound)
+}
+
+func (it *skipListIterator) Key() []byte {
+	return it.node.key
+}
+
+func (it *skipListIterator) Value() *data.LogRecordPos {
+	return it.node.pos.Load()
+}
+
+func (it *skipListIterator) Close() {
+	it.node = nil
+}
@@ -0,0 +1,99 @@
+package index
+
+import (
+	"GoKeeper/data"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestSkipList_PutGetDelete(t *testing.T) {
+	sl := NewSkipList()
+	assert.Nil(t, sl.Get([]byte("key")))
+	_, ok := sl.Delete([]byte("key"))
+	assert.False(t, ok)
+
+	// 空的 key 和普通的 key 一样可以写入
+	assert.Nil(t, sl.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 0}))
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, sl.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)}))
+	}
+	assert.Equal(t, 1001, sl.Size())
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 0}, sl.Get(nil))
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 10}, sl.Get([]byte("key-0010")))
+
+	old := sl.Put([]byte("key-0010"), &data.LogRecordPos{Fid: 2, Offset: 10})
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 10}, old)
+	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 10}, sl.Get([]byte("key-0010")))
+	assert.Equal(t, 1001, sl.Size())
+
+	for i := 0; i < 1000; i += 2 {
+		pos, ok := sl.Delete([]byte(fmt.Sprintf("key-%04d", i)))
+		assert.True(t, ok)
+		assert.NotNil(t, pos)
+	}
+	assert.Nil(t, sl.Get([]byte("key-0010")))
+	assert.NotNil(t, sl.Get([]byte("key-0011")))
+	assert.Equal(t, 501, sl.Size())
+
+	// 反向遍历可以走到空的 key 并结束
+	iterator := sl.Iterator(true)
+	var count int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		count++
+	}
+	iterator.Close()
+	assert.Equal(t, 501, count)
+}
+
+func TestSkipList_RangeIterator(t *testing.T) {
+	testRangeIterator(t, NewSkipList())
+}
+
+func TestSkipList_ConcurrentReadWrite(t *testing.T) {
+	sl := NewSkipList()
+	for i := 0; i < 1000; i++ {
+		sl.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// 并发地写入, 删除和读取, 没有被修改过的 key 一直可以读到, 遍历时保持顺序
+	wg := new(sync.WaitGroup)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 1000 + w; i < 3000; i += 4 {
+				key := []byte(fmt.Sprintf("key-%04d", i))
+				sl.Put(key, &data.LogRecordPos{Fid: 2, Offset: int64(i)})
+				if i%2 == 0 {
+					_, ok := sl.Delete(key)
+					assert.True(t, ok)
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(reverse bool) {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: int64(n)}, sl.Get([]byte(fmt.Sprintf("key-%04d", n))))
+				iterator := sl.RangeIterator(reverse, nil, []byte("key-1000"))
+				var count int
+				var prev []byte
+				for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+					if prev != nil {
+						assert.Equal(t, reverse, string(iterator.Key()) < string(prev))
+					}
+					prev = iterator.Key()
+					count++
+				}
+				iterator.Close()
+				assert.Equal(t, 1000, count)
+			}
+		}(r%2 == 0)
+	}
+	wg.Wait()
+	assert.Equal(t, 2000, sl.Size())
+}
@@ -3,22 +3,28 @@ package GoKeeper
 import (
 	"GoKeeper/index"
 	"bytes"
+	"time"
 )
 
 // Iterator 面向用户的接口
 type Iterator struct {
 	indexIter index.Iterator // 迭代器接口
 	db        *DB
+	snapshot  *Snapshot // 不为 nil 时表示遍历的是快照
 	options   IteratorOption
+	seq       uint64 // 创建迭代器时数据移动的序列号, 用来判断索引中的位置是否还有效
 }
 
 // NewIterator 初始化迭代器
 func (db *DB) NewIterator(options IteratorOption) *Iterator {
-	iterator := db.index.Iterator(options.Reverse)
+	lowerBound, upperBound := options.bounds()
+	seq := db.relocateSeq.Load()
+	iterator := db.index.RangeIterator(options.Reverse, lowerBound, upperBound)
 	return &Iterator{
 		indexIter: iterator,
 		db:        db,
 		options:   options,
+		seq:       seq,
 	}
 }
 
@@ -47,21 +53,65 @@ func (i *Iterator) Key() []byte {
 
 func (i *Iterator) Value() ([]byte, error) {
 	logRecord := i.indexIter.Value()
-	return i.db.getValueByPosition(logRecord)
+	if i.snapshot != nil {
+		return i.snapshot.getValueByPosition(logRecord)
+	}
+	return i.db.getValue(i.Key(), logRecord, i.seq)
 }
 
 func (i *Iterator) Close() {
 	i.indexIter.Close()
 }
 
+// skipToNext 跳过已经过期的 key
+// 前缀已经转换成了遍历范围, 索引迭代器只会返回前缀匹配的 key
 func (i *Iterator) skipToNext() {
-	prefixLen := len(i.options.Prefix)
-	if prefixLen == 0 {
-		return
+	now := time.Now().UnixNano()
+	if i.snapshot != nil {
+		now = i.snapshot.readTime
 	}
 	for ; i.indexIter.Valid(); i.indexIter.Next() {
-		if bytes.HasPrefix(i.indexIter.Key(), i.options.Prefix) {
-			break
+		if i.indexIter.Value().IsExpired(now) {
+			continue
 		}
+		break
+	}
+}
+
+// bounds 把前缀和上下界合并成一个遍历范围 [lowerBound, upperBound)
+func (o IteratorOption) bounds() ([]byte, []byte) {
+	lowerBound, upperBound := o.LowerBound, o.UpperBound
+	if len(o.Prefix) == 0 {
+		return lowerBound, upperBound
+	}
+	if bytes.Compare(o.Prefix, lowerBound) > 0 {
+		lowerBound = o.Prefix
+	}
+	if prefixEnd := prefixUpperBound(o.Prefix); prefixEnd != nil &&
+		(len(upperBound) == 0 || bytes.Compare(prefixEnd, upperBound) < 0) {
+		upperBound = prefixEnd
+	}
+	return lowerBound, upperBound
+}
+
+// prefixUpperBound 返回比所有以 prefix 为前缀的 key 都大的最小 key
+// prefix 全部由 0xff 组成时不存在这样的 key, 返回 nil
+func prefixUpperBound(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := make([]byte, i+1)
+			copy(end, prefix)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
+// inRange 判断 key 是否在 [lowerBound, upperBound) 范围内, 边界为空表示不限制
+func inRange(key []byte, lowerBound []byte, upperBound []byte) bool {
+	if len(lowerBound) != 0 && bytes.Compare(key, lowerBound) < 0 {
+		return false
 	}
+	return len(upperBound) == 0 || bytes.Compare(key, upperBound) < 0
 }
@@ -133,3 +133,56 @@ func TestDB_Iterator_Prefix(t *testing.T) {
 		t.Log(string(iterator.Key()))
 	}
 }
+
+func TestDB_Iterator_Bounds(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-iterator-bounds")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for _, key := range []string{"a1", "a2", "b1", "b2", "b3", "c1"} {
+		assert.Nil(t, db.Put([]byte(key), []byte(key)))
+	}
+	collect := func(iterator *Iterator) []string {
+		defer iterator.Close()
+		var keys []string
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			keys = append(keys, string(iterator.Key()))
+		}
+		return keys
+	}
+
+	// 1. 下界包含, 上界不包含
+	keys := collect(db.NewIterator(IteratorOption{LowerBound: []byte("a2"), UpperBound: []byte("b3")}))
+	assert.Equal(t, []string{"a2", "b1", "b2"}, keys)
+
+	// 2. 反向遍历同样遵守边界
+	keys = collect(db.NewIterator(IteratorOption{LowerBound: []byte("a2"), UpperBound: []byte("b3"), Reverse: true}))
+	assert.Equal(t, []string{"b2", "b1", "a2"}, keys)
+
+	// 3. 前缀和边界一起使用
+	keys = collect(db.NewIterator(IteratorOption{Prefix: []byte("b"), UpperBound: []byte("b3")}))
+	assert.Equal(t, []string{"b1", "b2"}, keys)
+	keys = collect(db.NewIterator(IteratorOption{Prefix: []byte("b"), Reverse: true}))
+	assert.Equal(t, []string{"b3", "b2", "b1"}, keys)
+
+	// 4. Seek 超出边界时从边界开始
+	iterator := db.NewIterator(IteratorOption{UpperBound: []byte("b2"), Reverse: true})
+	iterator.Seek([]byte("z"))
+	assert.True(t, iterator.Valid())
+	assert.Equal(t, []byte("b1"), iterator.Key())
+	iterator.Close()
+
+	// 5. 快照遍历同样遵守边界
+	snapshot := db.NewSnapshot()
+	defer snapshot.Release()
+	assert.Nil(t, db.Delete([]byte("b1")))
+	assert.Nil(t, db.Put([]byte("b0"), []byte("b0")))
+	keys = collect(snapshot.NewIterator(IteratorOption{LowerBound: []byte("a2"), UpperBound: []byte("b3"), Reverse: true}))
+	assert.Equal(t, []string{"b2", "b1", "a2"}, keys)
+	keys = collect(snapshot.NewIterator(IteratorOption{Prefix: []byte("b")}))
+	assert.Equal(t, []string{"b1", "b2", "b3"}, keys)
+}
@@ -2,77 +2,97 @@ package GoKeeper
 
 import (
 	"GoKeeper/data"
+	"GoKeeper/index"
 	"GoKeeper/util"
+	"context"
+	"encoding/binary"
 	"io"
 	"os"
-	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
-	mergeSuffixName   = "-merge"
-	mergerFinishedKey = "merge.finished"
+	mergeSuffixName     = "-merge"
+	mergerFinishedKey   = "merge.finished"
+	mergeLogicalSizeKey = "merge.logical-size"
 )
 
 // Merge 清理无效数据生成 Hint 文件
 func (db *DB) Merge() error {
+	return db.MergeWithOptions(context.Background(), DefaultMergeOptions)
+}
+
+// MergeWithOptions 按照配置进行 merge, 可以限制 merge 的速度, 也可以通过 ctx 取消 merge
+// 取消或者失败时会删除 merge 目录, 不会留下只完成了一半的 merge 结果
+func (db *DB) MergeWithOptions(ctx context.Context, options MergeOptions) (err error) {
+	if options.BytesPerSec < 0 {
+		return ErrInvalidMergeOptions
+	}
+	db.lock.Lock()
 	// 活跃文件为空,直接返回
 	if db.activeFile == nil {
+		db.lock.Unlock()
 		return nil
 	}
-	db.lock.Lock()
 	if db.isMerging {
 		// 正在 merge 中
 		// 释放锁
 		db.lock.Unlock()
 		return ErrMergeIsRunning
 	}
-
-	// 查看可以 merge 的数据量是否达到了阈值
-	size, err := util.DirSize(db.options.DirPath)
-	if err != nil {
+	// 上一次 merge 通过压缩过滤器删除了 key, 结果安装之前不能被覆盖
+	if db.mergeDropped && db.isMergePending() {
 		db.lock.Unlock()
-		return err
+		return ErrMergeNotInstalled
 	}
-	if float32(size)/float32(db.options.DataFileSize) < db.options.MergeThreshold {
+
+	// 查看可以 merge 回收的数据量占总数据量的比例是否达到了阈值
+	size := db.dataFilesSize()
+	if size == 0 || float32(db.reclaimSize)/float32(size) < db.options.MergeThreshold {
 		// 数据量未达到阈值,直接返回
 		db.lock.Unlock()
 		return ErrMergeNotExceedThreshold
 	}
 
 	// 查看剩余空间容量是否可以容纳 merge 之后的数据量
-	// todo 获取剩余磁盘空间的方法没法跨平台,暂时不是先
-	//availableDiskSize, err := util.AvailableDiskSize()
-	//if err != nil {
-	//	db.lock.Unlock()
-	//	return err
-	//}
-	//if uint64(size-db.reclaimSize) >= availableDiskSize {
-	//	db.lock.Unlock()
-	//	return ErrDiskSpaceNotEnough
-	//}
+	availableDiskSize, err := util.AvailableDiskSize(db.options.DirPath)
+	if err != nil {
+		db.lock.Unlock()
+		return err
+	}
+	if uint64(size-db.reclaimSize) >= availableDiskSize {
+		db.lock.Unlock()
+		return ErrDiskSpaceNotEnough
+	}
 
 	db.isMerging = true
 	defer func() {
+		db.lock.Lock()
 		db.isMerging = false
+		db.lock.Unlock()
 	}()
 
 	// 正式开始 merge 流程
 
 	// 持久化当前活跃文件
-	if err := db.activeFile.Sync(); err != nil {
+	if err = db.activeFile.Sync(); err != nil {
+		db.lock.Unlock()
 		return err
 	}
 	// 将当前活跃文件转换为旧的数据文件
 	db.olderFiles[db.activeFile.FileID] = db.activeFile
 	// 打开新的活跃文件
-	if err := db.setActiveDataFile(); err != nil {
+	if err = db.setActiveDataFile(); err != nil {
+		db.lock.Unlock()
 		return err
 	}
 	// 记录最近没有参与 merge 的文件id
 	nonMergeFileId := db.activeFile.FileID
+	db.mergeBoundary = nonMergeFileId
 
 	// 取出所有需要 merge 的文件
 	mergeFiles := make([]*data.DataFile, 0, 10) // 预先分配空间
@@ -87,8 +107,29 @@ func (db *DB) Merge() error {
 		return mergeFiles[i].FileID < mergeFiles[j].FileID
 	})
 
+	// 记录 merge 的进度
+	status := MergeStatus{Running: true, TotalFiles: len(mergeFiles), StartTime: time.Now()}
+	db.updateMergeStatus(status, options.Progress)
+	defer func() {
+		status.Running = false
+		if err != nil {
+			status.Error = err.Error()
+		}
+		db.updateMergeStatus(status, options.Progress)
+	}()
+
 	// 获取 mergePath
 	mergePath := db.getMergePath()
+	// 失败或者被取消时删除 merge 目录, 此时 merge 目录中没有完成标识文件, 即使删除之前崩溃也会在启动时丢弃
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(mergePath)
+			// 没有等待安装的 merge 结果了
+			db.lock.Lock()
+			db.mergeBoundary = 0
+			db.lock.Unlock()
+		}
+	}()
 	// 如果目录存在,说明发生过 merge, 删除掉
 	if _, err := os.Stat(mergePath); err == nil {
 		if err = os.RemoveAll(mergePath); err != nil {
@@ -105,33 +146,97 @@ func (db *DB) Merge() error {
 	mergeOptions.DirPath = mergePath
 	// 打开每次都 Sync, merge 速度会下降
 	mergeOptions.SyncWrites = false
+	// 临时实例不需要后台 merge
+	mergeOptions.MergeCheckInterval = 0
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = mergeDB.Close()
+	}()
 	// 打开 Hint 文件,存储索引
 	hintFile, err := data.OpenHintFile(mergePath)
 	if err != nil {
 		return err
 	}
+	hintFile.Cipher = db.cipher
+	defer func() {
+		_ = hintFile.Close()
+	}()
+	if err = hintFile.InitHeader(db.fingerprint); err != nil {
+		return err
+	}
+	// 操作数链表只会指向更早的数据文件, 都在参与 merge 的文件中
+	mergeFileMap := make(map[uint32]*data.DataFile, len(mergeFiles))
+	for _, file := range mergeFiles {
+		mergeFileMap[file.FileID] = file
+	}
+	getMergeFile := func(fid uint32) *data.DataFile {
+		return mergeFileMap[fid]
+	}
+
+	// 被压缩过滤器删除和改写的 key, merge 完成之后再更新内存中的数据
+	var dropped []droppedKey
+	var replaced []replacedKey
+
 	// 遍历每个数据文件,读取每一条记录
+	now := time.Now().UnixNano()
+	limiter := newMergeLimiter(options.BytesPerSec)
 	for _, dataFile := range mergeFiles {
-		var offset int64 = 0
+		offset := dataFile.HeaderSize()
 		for {
+			// 检查是否被取消, 并按照配置的速度限流
+			if err := limiter.wait(ctx, status.BytesRead); err != nil {
+				return err
+			}
 			record, n, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
 				if err == io.EOF {
 					break
 				}
-				return err
+				// 损坏的记录不再重写
+				next, err := db.skipCorruptedRecord(dataFile, offset, n, err)
+				if err != nil {
+					return err
+				}
+				if next < 0 {
+					break
+				}
+				status.BytesRead += n
+				offset = next
+				continue
 			}
+			status.BytesRead += n
 			// 解析拿到实际的 Key
 			realKey, _ := parseLogRecordKey(record.Key)
 			logRecordPos := db.index.Get(realKey)
-			// 和内存中的索引位置
+			// 和内存中的索引位置, 已经过期的数据不再重写
 			if logRecordPos != nil &&
 				logRecordPos.Fid == dataFile.FileID &&
-				logRecordPos.Offset == offset {
+				logRecordPos.Offset == offset &&
+				!logRecordPos.IsExpired(now) {
+				// 操作数合并成一条普通记录
+				if record.Type == data.LogRecordOperand {
+					value, err := db.foldOperands(getMergeFile, record)
+					if err != nil {
+						return err
+					}
+					record.Value, record.Type = value, data.LogRecordNormal
+				}
+				// 压缩过滤器决定删除或者改写这条记录
+				if filter := db.options.CompactionFilter; filter != nil {
+					decision, newValue := filter.Filter(realKey, record.Value)
+					if decision == CompactionDrop {
+						dropped = append(dropped, droppedKey{key: realKey, pos: logRecordPos})
+						offset += n
+						continue
+					}
+					if decision == CompactionReplace {
+						record.Value = newValue
+						replaced = append(replaced, replacedKey{key: realKey, pos: logRecordPos, value: newValue})
+					}
+				}
 				// 清除事务标记
 				record.Key = logRecordKeyWithSeq(realKey, nonTransactionKey)
 				// 将数据重写到数据文件中
@@ -139,14 +244,27 @@ func (db *DB) Merge() error {
 				if err != nil {
 					return err
 				}
+				// merge 生成的数据文件会按照原来的文件 id 移动到数据目录, 不能覆盖没有参与 merge 的数据文件
+				if pos.Fid >= nonMergeFileId {
+					return ErrMergeFileIdOverflow
+				}
 				// 将位置索引写到 hint 文件中
 				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
 					return err
 				}
+				status.BytesRewritten += int64(pos.Size)
 			}
 			// 移动到下一条记录
 			offset += n
 		}
+		status.FilesDone++
+		status.BytesReclaimed = status.BytesRead - status.BytesRewritten
+		db.updateMergeStatus(status, options.Progress)
+	}
+
+	// 写入完成标识文件之前最后检查一次是否被取消
+	if err = ctx.Err(); err != nil {
+		return err
 	}
 
 	// 持久化 hint 文件和数据文件
@@ -162,13 +280,31 @@ func (db *DB) Merge() error {
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = mergeFinishFile.Close()
+	}()
+	if err = mergeFinishFile.InitHeader(db.fingerprint); err != nil {
+		return err
+	}
 	mergeFinishRecord := &data.LogRecord{
 		Key:   []byte(mergerFinishedKey),
 		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
 	}
 
-	encodeRecord, _ := data.EncodeLogRecord(mergeFinishRecord)
-	if err = mergeFinishFile.Write(encodeRecord); err != nil {
+	// 同时记录 merge 之后每个数据文件不压缩时的大小, 从 hint 文件加载索引时不会读取这些数据文件
+	logicalSizeRecord := &data.LogRecord{
+		Key:   []byte(mergeLogicalSizeKey),
+		Value: encodeLogicalSizes(mergeDB),
+	}
+	encodeRecord, _, err := db.cipher.EncodeLogRecord(mergeFinishRecord)
+	if err != nil {
+		return err
+	}
+	encodeSizeRecord, _, err := db.cipher.EncodeLogRecord(logicalSizeRecord)
+	if err != nil {
+		return err
+	}
+	if err = mergeFinishFile.Write(append(encodeRecord, encodeSizeRecord...)); err != nil {
 		return err
 	}
 
@@ -176,57 +312,72 @@ func (db *DB) Merge() error {
 		return err
 	}
 
+	// merge 的结果已经持久化, 改写的值写入活跃文件, 失败时丢弃 merge 的结果, 重启之前和之后读到的值保持一致
+	if err = db.putReplacedKeys(replaced); err != nil {
+		return err
+	}
+	// 被删除的 key 在重启之后也不会出现, 现在可以从内存索引中删除
+	db.deleteDroppedKeys(dropped)
+
 	return nil
 }
 
+// dataFilesSize 所有数据文件中已经写入的数据量
+// 不包括内存映射文件预分配的空间, 以及 hint 文件, 索引文件等不参与回收的文件, 调用前必须持有 db.lock
+func (db *DB) dataFilesSize() int64 {
+	var size int64
+	if db.activeFile != nil {
+		size += db.activeFile.WriteOff
+	}
+	for _, dataFile := range db.olderFiles {
+		size += dataFile.WriteOff
+	}
+	return size
+}
+
 // 拿到数据目录的路径
 // eg. 数据目录 /tmp/goKeeper
 //
-//	merge目录 /tmp/gooKeeper-merge
+//	merge目录 /tmp/goKeeper-merge
 func (db *DB) getMergePath() string {
 	// 1.首先清理路径中的冗余部分
-	// 2.其次获取数据目录的父级路径
-	dir := path.Dir(path.Clean(db.options.DirPath))
-	base := path.Base(dir)
-	return filepath.Join(dir, base, mergeSuffixName)
+	// 2.其次获取数据目录的父级路径和数据目录的名称
+	dirPath := filepath.Clean(db.options.DirPath)
+	return filepath.Join(filepath.Dir(dirPath), filepath.Base(dirPath)+mergeSuffixName)
+}
+
+// isMergePending 判断是否有已经完成但是还没有在启动时加载的 merge 结果
+func (db *DB) isMergePending() bool {
+	_, err := os.Stat(filepath.Join(db.getMergePath(), data.MergeFinishedFileName))
+	return err == nil
 }
 
-// 加载 merge 目录
+// 加载 merge 目录, 用 merge 生成的数据文件替换掉参与 merge 的旧数据文件
+// 每一步都可以重复执行, 中途崩溃之后下次启动会继续完成安装:
+//  1. 删除数据目录中旧的 hint 文件, merge 完成标识文件, B+ 树索引文件以及参与 merge 的数据文件
+//  2. 将 hint 文件移动到数据目录, 表示第 1 步已经完成
+//  3. 将 merge 生成的数据文件移动到数据目录
+//  4. 将 merge 完成标识文件移动到数据目录, 表示安装完成
+//  5. 删除 merge 目录
+//
+// merge 目录中没有完成标识文件, 说明 merge 被中断了或者已经安装完成, 直接删除
+// merge 生成的数据文件会覆盖没有参与 merge 的数据文件时, 在第 1 步之前丢弃 merge 的结果
 func (db *DB) loadMergeFiles() error {
 	mergePath := db.getMergePath()
 	// 判断目录是否存在,不存在则直接返回
 	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
 		return nil
 	}
-	defer func() {
-		_ = os.RemoveAll(mergePath)
-	}()
-	// 读取目录中的所有文件
-	dir, err := os.ReadDir(mergePath)
-	if err != nil {
-		return err
+	if !db.isMergePending() {
+		return os.RemoveAll(mergePath)
 	}
 
-	// 查找 merge 完成的文件,判断 merge 是否处理完毕
-	var isMergeFinished bool
-	mergeFileNames := make([]string, 0, 20)
-	for _, entry := range dir {
-		if entry.Name() == data.MergeFinishedFileName {
-			isMergeFinished = true
-		}
-		// 没必要拿到事务序列号文件
-		if entry.Name() == data.SeqNoFileName {
-			continue
+	afterStep := func(step string) error {
+		if db.options.mergeInstallHook != nil {
+			return db.options.mergeInstallHook(step)
 		}
-		mergeFileNames = append(mergeFileNames, entry.Name())
-	}
-	// merge没有完成则直接返回
-	// todo 这里之后可以优化在控制台打印数据,或将数据写入日志文件
-	if !isMergeFinished {
 		return nil
 	}
-	// merge 完成的处理
-	// 将旧的数据文件删除,用merge目录下的文件替代
 
 	// 获取没有参与 Merge 的文件ID
 	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
@@ -234,30 +385,84 @@ func (db *DB) loadMergeFiles() error {
 		return err
 	}
 
-	// 删除它文件id小的文件
-	var fileId uint32 = 0
-	for ; fileId < nonMergeFileId; fileId++ {
-		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err = os.Stat(fileName); err == nil {
-			if err = os.Remove(fileName); err != nil {
+	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
+	finishedFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	_, hintErr := os.Stat(hintFileName)
+	_, finishedErr := os.Stat(finishedFileName)
+	// 数据目录中有 hint 文件但是没有完成标识文件, 说明第 1 步已经完成, 不能再删除数据文件
+	installing := hintErr != nil || finishedErr == nil
+
+	entries, err := os.ReadDir(mergePath)
+	if err != nil {
+		return err
+	}
+	// merge 生成的数据文件 id 不小于 nonMergeFileId 时会覆盖没有参与 merge 的数据文件
+	// 还没有删除任何旧的数据文件时直接丢弃这次 merge 的结果
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.DataFileNameSuffix))
+		if err != nil {
+			return ErrDataDirectoryCorrupted
+		}
+		if uint32(fileId) >= nonMergeFileId {
+			if installing {
+				return os.RemoveAll(mergePath)
+			}
+			return ErrMergeFileIdOverflow
+		}
+	}
+
+	if installing {
+		// hint 文件要先于完成标识文件删除, 否则崩溃之后会误以为第 1 步已经完成
+		staleFiles := []string{hintFileName, finishedFileName}
+		if db.options.IndexType == BPlusTree {
+			// B+ 树索引中的位置信息已经失效, 启动时从 hint 文件和数据文件中重新构建
+			staleFiles = append(staleFiles, filepath.Join(db.options.DirPath, index.BPlusTreeIndexFileName))
+		}
+		for fileId := uint32(0); fileId < nonMergeFileId; fileId++ {
+			staleFiles = append(staleFiles, data.GetDataFileName(db.options.DirPath, fileId))
+		}
+		for _, fileName := range staleFiles {
+			if err = os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err = afterStep("remove " + filepath.Base(fileName)); err != nil {
 				return err
 			}
-		} else if os.IsNotExist(err) {
-			return err
 		}
 
+		if err = os.Rename(filepath.Join(mergePath, data.HintFileName), hintFileName); err != nil {
+			return err
+		}
+		if err = afterStep("move " + data.HintFileName); err != nil {
+			return err
+		}
 	}
 
-	// 将新的数据文件移动到数据目录
-	for _, fileName := range mergeFileNames {
-		srcPath := filepath.Join(mergePath, fileName)
-		dstPath := filepath.Join(db.options.DirPath, fileName)
+	// 将新的数据文件移动到数据目录, 事务序列号文件, 文件锁和索引文件不需要
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			continue
+		}
+		srcPath := filepath.Join(mergePath, entry.Name())
+		dstPath := filepath.Join(db.options.DirPath, entry.Name())
 		if err = os.Rename(srcPath, dstPath); err != nil {
 			return err
 		}
+		if err = afterStep("move " + entry.Name()); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	if err = os.Rename(filepath.Join(mergePath, data.MergeFinishedFileName), finishedFileName); err != nil {
+		return err
+	}
+	if err = afterStep("move " + data.MergeFinishedFileName); err != nil {
+		return err
+	}
+	return os.RemoveAll(mergePath)
 }
 
 // 获取没有参与 Merge 的文件ID
@@ -266,7 +471,11 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	if err != nil {
 		return 0, err
 	}
-	record, _, err := mergeFinishFile.ReadLogRecord(0)
+	mergeFinishFile.Cipher = db.cipher
+	defer func() {
+		_ = mergeFinishFile.Close()
+	}()
+	record, _, err := mergeFinishFile.ReadLogRecord(mergeFinishFile.HeaderSize())
 	if err != nil {
 		return 0, err
 	}
@@ -280,18 +489,27 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 
 // 从 hint 文件中加载索引
 func (db *DB) loadIndexFromHintFile() error {
-	// 查看 hint 索引文件是否存在
+	// 查看 hint 索引文件是否存在, 只有 merge 完成标识文件也存在时 hint 文件才是有效的
 	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
 	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
 		return nil
 	}
+	finishedFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(finishedFileName); os.IsNotExist(err) {
+		return nil
+	}
 	// 打开 hint 索引文件
 	hintFile, err := data.OpenHintFile(db.options.DirPath)
 	if err != nil {
 		return err
 	}
+	hintFile.Cipher = db.cipher
+	defer func() {
+		_ = hintFile.Close()
+	}()
 	// 加载索引
-	var offset int64 = 0
+	offset := hintFile.HeaderSize()
+	now := time.Now().UnixNano()
 	for {
 		record, n, err := hintFile.ReadLogRecord(offset)
 		if err != nil {
@@ -302,10 +520,122 @@ func (db *DB) loadIndexFromHintFile() error {
 		}
 		// 拿到位置索引
 		pos := data.DecodeLogRecordPos(record.Value)
-		// 加入到内存索引中
-		db.index.Put(record.Key, pos)
+		// 加入到内存索引中, 已经过期的 key 不再加载
+		if !pos.IsExpired(now) {
+			db.index.Put(record.Key, pos)
+		}
 		// 移动到下一条记录
 		offset += n
 	}
+	db.loadMergeLogicalSizes()
 	return nil
 }
+
+// encodeLogicalSizes 编码 merge 生成的每个数据文件不压缩时的大小
+//
+//	+--------+--------------+--------+--------------+-----+
+//	| 文件id | 不压缩的大小 | 文件id | 不压缩的大小 | ... |
+//	+--------+--------------+--------+--------------+-----+
+//	  变长       变长
+func encodeLogicalSizes(mergeDB *DB) []byte {
+	files := make([]*data.DataFile, 0, len(mergeDB.olderFiles)+1)
+	for _, dataFile := range mergeDB.olderFiles {
+		files = append(files, dataFile)
+	}
+	if mergeDB.activeFile != nil {
+		files = append(files, mergeDB.activeFile)
+	}
+	buf := make([]byte, 0, len(files)*binary.MaxVarintLen64*2)
+	for _, dataFile := range files {
+		buf = binary.AppendUvarint(buf, uint64(dataFile.FileID))
+		buf = binary.AppendUvarint(buf, uint64(dataFile.LogicalSize))
+	}
+	return buf
+}
+
+// loadMergeLogicalSizes 从 merge 完成标识文件中加载数据文件不压缩时的大小
+// 旧版本的标识文件中没有这条记录, 这些数据文件不压缩时的大小按照未知处理
+func (db *DB) loadMergeLogicalSizes() {
+	mergeFinishFile, err := data.OpenFinishedFileName(db.options.DirPath)
+	if err != nil {
+		return
+	}
+	mergeFinishFile.Cipher = db.cipher
+	defer func() {
+		_ = mergeFinishFile.Close()
+	}()
+	offset := mergeFinishFile.HeaderSize()
+	_, n, err := mergeFinishFile.ReadLogRecord(offset)
+	if err != nil {
+		return
+	}
+	record, _, err := mergeFinishFile.ReadLogRecord(offset + n)
+	if err != nil || string(record.Key) != mergeLogicalSizeKey {
+		return
+	}
+	for value := record.Value; len(value) > 0; {
+		fid, n := binary.Uvarint(value)
+		if n <= 0 {
+			return
+		}
+		value = value[n:]
+		size, n := binary.Uvarint(value)
+		if n <= 0 {
+			return
+		}
+		value = value[n:]
+		if dataFile, ok := db.olderFiles[uint32(fid)]; ok {
+			dataFile.LogicalSize = int64(size)
+		}
+	}
+}
+
+// updateMergeStatus 更新 merge 的进度, 并通知进度回调
+func (db *DB) updateMergeStatus(status MergeStatus, progress func(status MergeStatus)) {
+	db.mergeStatusLock.Lock()
+	db.mergeStatus = status
+	db.mergeStatusLock.Unlock()
+	if progress != nil {
+		progress(status)
+	}
+}
+
+// MergeStatus 返回最近一次 merge 的进度
+func (db *DB) MergeStatus() MergeStatus {
+	db.mergeStatusLock.Lock()
+	defer db.mergeStatusLock.Unlock()
+	return db.mergeStatus
+}
+
+// mergeLimiter 限制 merge 读取数据文件的速度
+type mergeLimiter struct {
+	bytesPerSec int64
+	startTime   time.Time
+}
+
+func newMergeLimiter(bytesPerSec int64) *mergeLimiter {
+	return &mergeLimiter{bytesPerSec: bytesPerSec, startTime: time.Now()}
+}
+
+// wait 已经读取了 bytesRead 字节, 如果读取得太快则等待, 等待期间 ctx 被取消时返回 ctx 的错误
+func (l *mergeLimiter) wait(ctx context.Context, bytesRead int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if l.bytesPerSec <= 0 {
+		return nil
+	}
+	expected := time.Duration(float64(bytesRead) / float64(l.bytesPerSec) * float64(time.Second))
+	delay := expected - time.Since(l.startTime)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
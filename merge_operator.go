@@ -0,0 +1,201 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"encoding/binary"
+	"strconv"
+	"time"
+)
+
+// maxOperandChainDepth 一个 key 最多累积的操作数个数
+// 超过之后在写入时直接把操作数合并成一条普通记录, 避免读取时链路过长
+const maxOperandChainDepth = 32
+
+// MergeOperator 合并操作符
+// 通过 DB.Apply 写入的操作数不会立即和已有的值合并,
+// 而是在读取或者 Merge 时, 由合并操作符把已有的值和所有操作数按写入顺序合并成最终的值
+type MergeOperator interface {
+	// Name 合并操作符的名称
+	Name() string
+
+	// FullMerge 把已有的值和操作数合并成最终的值
+	// existing 为 nil 表示 key 之前不存在, operands 按照写入顺序排列
+	FullMerge(key []byte, existing []byte, operands [][]byte) ([]byte, error)
+}
+
+// Int64AddOperator 整数累加操作符
+// 值和操作数都是十进制的整数字符串, key 不存在时按照 0 处理
+type Int64AddOperator struct{}
+
+func (Int64AddOperator) Name() string {
+	return "int64-add"
+}
+
+func (Int64AddOperator) FullMerge(key []byte, existing []byte, operands [][]byte) ([]byte, error) {
+	var sum int64
+	if existing != nil {
+		n, err := strconv.ParseInt(string(existing), 10, 64)
+		if err != nil {
+			return nil, ErrInvalidOperand
+		}
+		sum = n
+	}
+	for _, operand := range operands {
+		n, err := strconv.ParseInt(string(operand), 10, 64)
+		if err != nil {
+			return nil, ErrInvalidOperand
+		}
+		sum += n
+	}
+	return []byte(strconv.FormatInt(sum, 10)), nil
+}
+
+// AppendOperator 追加操作符
+// 把操作数依次追加到已有的值后面, Delimiter 不为空时用它分隔每一段
+type AppendOperator struct {
+	Delimiter []byte
+}
+
+func (AppendOperator) Name() string {
+	return "append"
+}
+
+func (op AppendOperator) FullMerge(key []byte, existing []byte, operands [][]byte) ([]byte, error) {
+	result := make([]byte, 0, len(existing))
+	result = append(result, existing...)
+	for i, operand := range operands {
+		if (existing != nil || i > 0) && len(op.Delimiter) > 0 {
+			result = append(result, op.Delimiter...)
+		}
+		result = append(result, operand...)
+	}
+	return result, nil
+}
+
+// Apply 写入一个操作数, 由 Options.MergeOperator 在读取时和 key 已有的值合并
+// 写入时不需要读取旧值, 例如配合 Int64AddOperator 可以实现原子计数器
+// key 已有的过期时间会被保留
+func (db *DB) Apply(key []byte, operand []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if db.options.MergeOperator == nil {
+		return ErrMergeOperatorNotSet
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	// 找到 key 当前的位置, 作为操作数链表的上一个节点
+	var prevPos *data.LogRecordPos
+	var depth uint64
+	var expire int64
+	if pos := db.index.Get(key); pos != nil && !pos.IsExpired(time.Now().UnixNano()) {
+		prevPos, expire = pos, pos.Expire
+		logRecord, err := readLogRecord(db.getDataFile(pos.Fid), pos)
+		if err != nil {
+			return err
+		}
+		if logRecord.Type == data.LogRecordOperand {
+			depth, _ = binary.Uvarint(logRecord.Value)
+		}
+	}
+
+	// 操作数链表过长, 或者上一个节点所在的文件参与了 merge, 安装之后位置会失效, 合并成一条普通记录
+	if depth+1 > maxOperandChainDepth || (prevPos != nil && prevPos.Fid < db.mergeBoundary) {
+		existing, err := db.getValueByPosition(prevPos)
+		if err != nil {
+			return err
+		}
+		value, err := db.options.MergeOperator.FullMerge(key, existing, [][]byte{operand})
+		if err != nil {
+			return err
+		}
+		return db.putLocked(key, value, expire)
+	}
+
+	logRecord := &data.LogRecord{
+		Key:    logRecordKeyWithSeq(key, nonTransactionKey),
+		Value:  encodeOperand(depth+1, prevPos, operand),
+		Type:   data.LogRecordOperand,
+		Expire: expire,
+	}
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+
+	// 之前的记录还在操作数链表中, 不能计入可回收空间
+	db.saveSnapshotOverlay(key)
+	db.bumpKeyVersion(key)
+	db.index.Put(key, pos)
+	return nil
+}
+
+// foldOperands 沿着操作数链表向前读取, 直到遇到普通记录或者链表的起点, 然后合并出最终的值
+func (db *DB) foldOperands(getDataFile func(fid uint32) *data.DataFile, logRecord *data.LogRecord) ([]byte, error) {
+	if db.options.MergeOperator == nil {
+		return nil, ErrMergeOperatorNotSet
+	}
+	key, _ := parseLogRecordKey(logRecord.Key)
+
+	var operands [][]byte
+	var existing []byte
+	for {
+		prevPos, operand := decodeOperand(logRecord.Value)
+		operands = append(operands, operand)
+		if prevPos == nil {
+			break
+		}
+
+		var err error
+		logRecord, err = readLogRecord(getDataFile(prevPos.Fid), prevPos)
+		if err != nil {
+			return nil, err
+		}
+		if logRecord.Type == data.LogRecordNormal {
+			existing = logRecord.Value
+			break
+		}
+		if logRecord.Type != data.LogRecordOperand {
+			break
+		}
+	}
+
+	// 链表是从新到旧读取的, 合并时需要按照写入顺序
+	for i, j := 0, len(operands)-1; i < j; i, j = i+1, j-1 {
+		operands[i], operands[j] = operands[j], operands[i]
+	}
+	return db.options.MergeOperator.FullMerge(key, existing, operands)
+}
+
+// encodeOperand 编码操作数记录的 value
+//
+//	+-----------+---------------+----------------+----------+
+//	| 链表深度  | 上一个位置长度 | 上一个记录位置 |  操作数  |
+//	+-----------+---------------+----------------+----------+
+//	  变长          变长            变长
+func encodeOperand(depth uint64, prevPos *data.LogRecordPos, operand []byte) []byte {
+	var encPos []byte
+	if prevPos != nil {
+		encPos = data.EncodeLogRecordPos(prevPos)
+	}
+	buf := make([]byte, binary.MaxVarintLen64*2, binary.MaxVarintLen64*2+len(encPos)+len(operand))
+	index := binary.PutUvarint(buf, depth)
+	index += binary.PutUvarint(buf[index:], uint64(len(encPos)))
+	buf = append(buf[:index], encPos...)
+	return append(buf, operand...)
+}
+
+// decodeOperand 解码操作数记录的 value, 返回上一个记录的位置和操作数
+func decodeOperand(value []byte) (*data.LogRecordPos, []byte) {
+	_, n := binary.Uvarint(value)
+	index := n
+	posSize, n := binary.Uvarint(value[index:])
+	index += n
+
+	var prevPos *data.LogRecordPos
+	if posSize > 0 {
+		prevPos = data.DecodeLogRecordPos(value[index : index+int(posSize)])
+	}
+	return prevPos, value[index+int(posSize):]
+}
@@ -0,0 +1,112 @@
+package GoKeeper
+
+import (
+	"GoKeeper/util"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestDB_Apply(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-apply")
+	opts.DirPath = dir
+	opts.MergeOperator = Int64AddOperator{}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1. key 不存在时从 0 开始累加
+	assert.Nil(t, db.Apply(util.GetRandomKey(1), []byte("5")))
+	assert.Nil(t, db.Apply(util.GetRandomKey(1), []byte("-2")))
+	val, err := db.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("3"), val)
+
+	// 2. 在已有的值上累加
+	assert.Nil(t, db.Put(util.GetRandomKey(2), []byte("100")))
+	assert.Nil(t, db.Apply(util.GetRandomKey(2), []byte("1")))
+	val, err = db.Get(util.GetRandomKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("101"), val)
+
+	// 3. 删除之后重新开始
+	assert.Nil(t, db.Delete(util.GetRandomKey(2)))
+	assert.Nil(t, db.Apply(util.GetRandomKey(2), []byte("7")))
+	val, err = db.Get(util.GetRandomKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("7"), val)
+
+	// 4. 操作数非法
+	assert.Nil(t, db.Apply(util.GetRandomKey(3), []byte("abc")))
+	_, err = db.Get(util.GetRandomKey(3))
+	assert.Equal(t, ErrInvalidOperand, err)
+
+	// 5. 并发累加, 超过链表深度之后会合并成普通记录
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				assert.Nil(t, db.Apply([]byte("counter"), []byte("1")))
+			}
+		}()
+	}
+	wg.Wait()
+	val, err = db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("400"), val)
+
+	// 6. 重启之后从数据文件中恢复
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	val, err = db2.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("3"), val)
+	val, err = db2.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("400"), val)
+
+	// 7. 没有设置合并操作符
+	db2.options.MergeOperator = nil
+	assert.Equal(t, ErrMergeOperatorNotSet, db2.Apply(util.GetRandomKey(1), []byte("1")))
+}
+
+func TestDB_ApplyAppend(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-apply-append")
+	opts.DirPath = dir
+	opts.MergeOperator = AppendOperator{Delimiter: []byte(",")}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Apply(util.GetRandomKey(1), []byte("a")))
+	snapshot := db.NewSnapshot()
+	defer snapshot.Release()
+	assert.Nil(t, db.Apply(util.GetRandomKey(1), []byte("b")))
+	assert.Nil(t, db.Apply(util.GetRandomKey(1), []byte("c")))
+
+	val, err := db.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a,b,c"), val)
+
+	// 快照中只能看到创建时的操作数
+	val, err = snapshot.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+
+	// 迭代器读取合并之后的值
+	iterator := db.NewIterator(DefaultIteratorOption)
+	defer iterator.Close()
+	iterator.Rewind()
+	assert.True(t, iterator.Valid())
+	val, err = iterator.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a,b,c"), val)
+}
@@ -0,0 +1,72 @@
+package GoKeeper
+
+import (
+	"log"
+	"time"
+)
+
+// startMergeScheduler 启动后台 merge, 每隔 MergeCheckInterval 检查一次是否需要 merge
+func (db *DB) startMergeScheduler() {
+	if db.options.MergeCheckInterval <= 0 {
+		return
+	}
+	db.mergeStop = make(chan struct{})
+	db.mergeWg.Add(1)
+	go db.runMergeScheduler(db.mergeStop)
+}
+
+// stopMergeScheduler 停止后台 merge, 等待正在进行的 merge 结束之后返回
+func (db *DB) stopMergeScheduler() {
+	if db.mergeStop == nil {
+		return
+	}
+	close(db.mergeStop)
+	db.mergeWg.Wait()
+	db.mergeStop = nil
+}
+
+func (db *DB) runMergeScheduler(stop chan struct{}) {
+	defer db.mergeWg.Done()
+
+	ticker := time.NewTicker(db.options.MergeCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			// 不在允许的时间窗口内
+			if !db.inMergeWindow(now) {
+				continue
+			}
+			// 回收比例和磁盘空间的检查在 Merge 和 Compact 中进行
+			var err error
+			if db.options.CompactMaxFiles > 0 {
+				err = db.Compact(db.options.CompactMaxFiles)
+			} else if !db.isMergePending() {
+				// 上一次 merge 的结果还没有在启动时加载, 再次 merge 不能回收更多的空间
+				err = db.Merge()
+			}
+			switch err {
+			case nil, ErrMergeNotExceedThreshold, ErrMergeIsRunning, ErrDiskSpaceNotEnough:
+			default:
+				log.Println("background merge failed:", err)
+			}
+		}
+	}
+}
+
+// inMergeWindow 判断 t 是否在允许后台 merge 的时间窗口内
+func (db *DB) inMergeWindow(t time.Time) bool {
+	start, end := db.options.MergeWindowStart, db.options.MergeWindowEnd
+	if start == end {
+		return true
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	if start < end {
+		return offset >= start && offset < end
+	}
+	// 跨越零点的时间窗口
+	return offset >= start || offset < end
+}
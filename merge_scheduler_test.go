@@ -0,0 +1,142 @@
+package GoKeeper
+
+import (
+	"GoKeeper/util"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// waitMergeFinished 等待后台 merge 完成, 超时返回 false
+func waitMergeFinished(db *DB, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if db.isMergePending() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestDB_MergeScheduler(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-merge-scheduler")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeCheckInterval = 20 * time.Millisecond
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1. 无效数据没有达到阈值, 不会 merge
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(util.GetRandomKey(i), util.GetRandomValue(64)))
+	}
+	assert.False(t, waitMergeFinished(db, 200*time.Millisecond))
+
+	// 2. 重复写入同样的 key, 无效数据超过阈值之后自动 merge
+	for n := 0; n < 3; n++ {
+		for i := 0; i < 500; i++ {
+			assert.Nil(t, db.Put(util.GetRandomKey(i), util.GetRandomValue(64)))
+		}
+	}
+	assert.True(t, waitMergeFinished(db, 5*time.Second))
+
+	// 3. merge 期间的数据依然可以正常读取
+	for i := 0; i < 500; i++ {
+		_, err := db.Get(util.GetRandomKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 4. 关闭数据库会停止后台 merge
+	assert.Nil(t, db.Close())
+	assert.Nil(t, db.mergeStop)
+}
+
+func TestDB_MergeSchedulerMMapIO(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-merge-scheduler")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024 * 1024
+	opts.IOType = MMapIO
+	opts.MergeCheckInterval = 20 * time.Millisecond
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 回收比例按照数据文件中写入的数据计算, 不包括内存映射文件预分配的空间
+	for n := 0; n < 4; n++ {
+		for i := 0; i < 500; i++ {
+			assert.Nil(t, db.Put(util.GetRandomKey(i), util.GetRandomValue(64)))
+		}
+	}
+	size, err := util.DirSize(dir)
+	assert.Nil(t, err)
+	db.lock.RLock()
+	assert.Greater(t, size, 10*db.dataFilesSize())
+	db.lock.RUnlock()
+	assert.True(t, waitMergeFinished(db, 5*time.Second))
+}
+
+func TestDB_MergeSchedulerWindow(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-merge-scheduler-window")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeCheckInterval = 20 * time.Millisecond
+	// 时间窗口不包含当前时间
+	now := time.Now()
+	offset := now.Sub(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()))
+	opts.MergeWindowStart = (offset + 2*time.Hour) % (24 * time.Hour)
+	opts.MergeWindowEnd = (offset + 3*time.Hour) % (24 * time.Hour)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for n := 0; n < 4; n++ {
+		for i := 0; i < 500; i++ {
+			assert.Nil(t, db.Put(util.GetRandomKey(i), util.GetRandomValue(64)))
+		}
+	}
+	assert.False(t, waitMergeFinished(db, 300*time.Millisecond))
+}
+
+func TestDB_InMergeWindow(t *testing.T) {
+	db := &DB{}
+	at := func(hour int) time.Time {
+		return time.Date(2024, 1, 1, hour, 30, 0, 0, time.Local)
+	}
+
+	// 不限制时间窗口
+	assert.True(t, db.inMergeWindow(at(12)))
+
+	// 当天的时间窗口 02:00 ~ 06:00
+	db.options.MergeWindowStart, db.options.MergeWindowEnd = 2*time.Hour, 6*time.Hour
+	assert.False(t, db.inMergeWindow(at(1)))
+	assert.True(t, db.inMergeWindow(at(2)))
+	assert.True(t, db.inMergeWindow(at(5)))
+	assert.False(t, db.inMergeWindow(at(6)))
+
+	// 跨越零点的时间窗口 22:00 ~ 06:00
+	db.options.MergeWindowStart, db.options.MergeWindowEnd = 22*time.Hour, 6*time.Hour
+	assert.True(t, db.inMergeWindow(at(23)))
+	assert.True(t, db.inMergeWindow(at(0)))
+	assert.False(t, db.inMergeWindow(at(6)))
+	assert.False(t, db.inMergeWindow(at(12)))
+}
+
+func TestOpen_MergeOptions(t *testing.T) {
+	opts := DefaultOptions
+	opts.MergeCheckInterval = -time.Second
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+
+	opts = DefaultOptions
+	opts.MergeWindowStart = 24 * time.Hour
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
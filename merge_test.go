@@ -0,0 +1,304 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"GoKeeper/util"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var errInjectedCrash = errors.New("injected crash")
+
+// prepareMergeDB 写入数据之后进行 merge, merge 之后继续写入, 然后关闭数据库, merge 的结果等待下次启动时安装
+// 返回每个 key 期望的值, nil 表示 key 已经被删除
+func prepareMergeDB(t *testing.T, opts Options) map[string][]byte {
+	opts.MergeThreshold = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	expected := make(map[string][]byte)
+	put := func(i int, value []byte) {
+		assert.Nil(t, db.Put(util.GetRandomKey(i), value))
+		expected[string(util.GetRandomKey(i))] = value
+	}
+	del := func(i int) {
+		assert.Nil(t, db.Delete(util.GetRandomKey(i)))
+		expected[string(util.GetRandomKey(i))] = nil
+	}
+
+	for i := 0; i < 1000; i++ {
+		put(i, util.GetRandomValue(64))
+	}
+	for i := 0; i < 500; i++ {
+		put(i, util.GetRandomValue(64))
+	}
+	for i := 500; i < 600; i++ {
+		del(i)
+	}
+	assert.Nil(t, db.Apply([]byte("counter"), []byte("1")))
+	assert.Nil(t, db.Apply([]byte("counter"), []byte("2")))
+	expected["counter"] = []byte("3")
+
+	assert.Nil(t, db.Merge())
+	assert.True(t, db.isMergePending())
+
+	// merge 之后的写入在没有参与 merge 的文件中
+	for i := 1000; i < 1100; i++ {
+		put(i, util.GetRandomValue(64))
+	}
+	for i := 0; i < 10; i++ {
+		del(i)
+	}
+	assert.Nil(t, db.Apply([]byte("counter"), []byte("4")))
+	expected["counter"] = []byte("7")
+	assert.Nil(t, db.Close())
+	return expected
+}
+
+// checkMergedDB 检查 merge 安装完成之后的数据
+func checkMergedDB(t *testing.T, db *DB, expected map[string][]byte) {
+	var keyNum int
+	for key, value := range expected {
+		val, err := db.Get([]byte(key))
+		if value == nil {
+			assert.Equal(t, ErrKeyNotFound, err)
+			continue
+		}
+		keyNum++
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	assert.Equal(t, keyNum, len(db.ListKeys()))
+
+	_, err := os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(db.options.DirPath, data.HintFileName))
+	assert.Nil(t, err)
+}
+
+func TestDB_Merge(t *testing.T) {
+	for _, indexType := range []IndexType{Btree, ART, BPlusTree, Sharded, SkipList} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "goKeeper-merge")
+		opts.DirPath = dir
+		opts.DataFileSize = 32 * 1024
+		opts.IndexType = indexType
+		opts.MergeOperator = Int64AddOperator{}
+		expected := prepareMergeDB(t, opts)
+
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		checkMergedDB(t, db, expected)
+		// 参与 merge 的无效数据都被清理了
+		assert.True(t, len(db.olderFiles) < 8)
+
+		// 安装之后可以继续写入, 重启之后依然有效
+		assert.Nil(t, db.Put([]byte("after-merge"), []byte("v")))
+		expected["after-merge"] = []byte("v")
+		assert.Nil(t, db.Close())
+		db2, err := Open(opts)
+		assert.Nil(t, err)
+		checkMergedDB(t, db2, expected)
+		destroyDB(db2)
+	}
+}
+
+func TestDB_MergeInterrupted(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-merge-interrupted")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeOperator = Int64AddOperator{}
+	expected := prepareMergeDB(t, opts)
+
+	// 没有完成标识文件的 merge 目录说明 merge 被中断了, 启动时直接丢弃
+	db := &DB{options: opts}
+	assert.Nil(t, os.Remove(filepath.Join(db.getMergePath(), data.MergeFinishedFileName)))
+
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for key, value := range expected {
+		val, err := db.Get([]byte(key))
+		if value == nil {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Equal(t, value, val)
+		}
+	}
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, data.HintFileName))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDB_MergeFileIdOverflow(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-merge-overflow")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeThreshold = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(util.GetRandomKey(i), util.GetRandomValue(64)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 调小数据文件大小之后, merge 的结果需要的数据文件比参与 merge 的数据文件更多
+	opts.DataFileSize = 4 * 1024
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Equal(t, ErrMergeFileIdOverflow, db.Merge())
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	for i := 0; i < 1000; i++ {
+		_, err := db.Get(util.GetRandomKey(i))
+		assert.Nil(t, err)
+	}
+}
+
+func TestDB_MergeInstallFileIdOverflow(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-merge-overflow")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeOperator = Int64AddOperator{}
+	expected := prepareMergeDB(t, opts)
+
+	// merge 目录中的数据文件会覆盖没有参与 merge 的数据文件时, 启动时丢弃 merge 的结果
+	db := &DB{options: opts}
+	mergePath := db.getMergePath()
+	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Rename(data.GetDataFileName(mergePath, 0), data.GetDataFileName(mergePath, nonMergeFileId)))
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	for key, value := range expected {
+		val, err := db.Get([]byte(key))
+		if value == nil {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Equal(t, value, val)
+		}
+	}
+	_, err = os.Stat(mergePath)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, data.HintFileName))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDB_MergeInstallCrash(t *testing.T) {
+	for _, indexType := range []IndexType{Btree, BPlusTree} {
+		// 依次在安装的每一步之后崩溃, 重新启动之后继续完成安装
+		for crashAt := 1; ; crashAt++ {
+			opts := DefaultOptions
+			dir, _ := os.MkdirTemp("", "goKeeper-merge-crash")
+			opts.DirPath = dir
+			opts.DataFileSize = 32 * 1024
+			opts.IndexType = indexType
+			opts.MergeOperator = Int64AddOperator{}
+			expected := prepareMergeDB(t, opts)
+
+			var step int
+			var crashedStep string
+			crashOpts := opts
+			crashOpts.mergeInstallHook = func(name string) error {
+				if step++; step == crashAt {
+					crashedStep = name
+					return errInjectedCrash
+				}
+				return nil
+			}
+			_, err := Open(crashOpts)
+			if err == nil {
+				// 所有的步骤都已经覆盖到了
+				assert.True(t, crashAt > 5)
+				_ = os.RemoveAll(dir)
+				break
+			}
+			assert.Equal(t, errInjectedCrash, err)
+
+			db, err := Open(opts)
+			assert.Nil(t, err, crashedStep)
+			checkMergedDB(t, db, expected)
+			destroyDB(db)
+		}
+	}
+}
+
+func TestDB_MergeWithOptions(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-merge-options")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeThreshold = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for n := 0; n < 2; n++ {
+		for i := 0; i < 1000; i++ {
+			assert.Nil(t, db.Put(util.GetRandomKey(i), util.GetRandomValue(64)))
+		}
+	}
+	dataSize := db.Stat().DiskSize
+
+	// 1. 非法的参数
+	assert.Equal(t, ErrInvalidMergeOptions, db.MergeWithOptions(context.Background(), MergeOptions{BytesPerSec: -1}))
+
+	// 2. 取消的 merge 不会留下 merge 目录
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, db.MergeWithOptions(ctx, DefaultMergeOptions))
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, uint32(0), db.mergeBoundary)
+	assert.False(t, db.Stat().Merge.Running)
+	assert.Equal(t, context.Canceled.Error(), db.Stat().Merge.Error)
+
+	// 3. 限速的 merge 在完成之前超时
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	err = db.MergeWithOptions(ctx, MergeOptions{BytesPerSec: dataSize})
+	cancel()
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.False(t, db.isMergePending())
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	// 4. 进度回调
+	var statuses []MergeStatus
+	start := time.Now()
+	err = db.MergeWithOptions(context.Background(), MergeOptions{
+		BytesPerSec: dataSize * 4,
+		Progress: func(status MergeStatus) {
+			statuses = append(statuses, status)
+		},
+	})
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) > 200*time.Millisecond)
+	assert.True(t, db.isMergePending())
+
+	first, last := statuses[0], statuses[len(statuses)-1]
+	assert.True(t, first.Running)
+	assert.Equal(t, 0, first.FilesDone)
+	assert.False(t, last.Running)
+	assert.Empty(t, last.Error)
+	assert.Equal(t, last.TotalFiles, last.FilesDone)
+	assert.Equal(t, last.TotalFiles+2, len(statuses))
+	assert.True(t, last.BytesRewritten > 0)
+	assert.True(t, last.BytesReclaimed > last.BytesRewritten/2)
+	assert.Equal(t, last.BytesRead, last.BytesRewritten+last.BytesReclaimed)
+	assert.Equal(t, last, db.Stat().Merge)
+}
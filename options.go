@@ -1,7 +1,10 @@
 package GoKeeper
 
 import (
+	"GoKeeper/data"
+	"GoKeeper/fio"
 	"os"
+	"time"
 )
 
 var DefaultOptions = Options{
@@ -11,7 +14,9 @@ var DefaultOptions = Options{
 	SyncWrites:     false,             // 默认关闭每次操作进行同步
 	BytesPerSync:   0,
 	IndexType:      Btree,
+	IndexShards:    16,
 	MMapStartup:    true,
+	IOType:         StandardIO,
 	MergeThreshold: 0.5,
 }
 
@@ -32,11 +37,59 @@ type Options struct {
 	// 索引类型(Btree,ART....)
 	IndexType IndexType
 
+	// 分片索引(Sharded)的分片数量, 每个分片有自己的锁, 写入较多时减少索引上锁的竞争
+	// Default: 16
+	IndexShards int
+
 	// 是否在启动时进行 mmap 的加载
 	MMapStartup bool
 
+	// 启动之后读写数据文件使用的 IO 类型
+	// Default: StandardIO 表示使用标准文件 IO
+	IOType IOType
+
 	// 数据文件合并的阈值,无效数组占总数据的多少
 	MergeThreshold float32
+
+	// 合并操作符, 用来合并 Apply 写入的操作数
+	// Default: nil 表示不能使用 Apply
+	MergeOperator MergeOperator
+
+	// 后台检查是否需要 merge 的时间间隔
+	// Default: 0 表示不开启后台 merge
+	MergeCheckInterval time.Duration
+
+	// 允许后台 merge 的时间窗口, 为距离当天零点的时间, 取值范围 [0, 24h)
+	// 开始时间等于结束时间表示不限制, 开始时间大于结束时间表示跨越零点, 例如 22:00 ~ 06:00
+	MergeWindowStart time.Duration
+	MergeWindowEnd   time.Duration
+
+	// 后台 merge 时每次最多增量压缩的数据文件数量
+	// Default: 0 表示重写所有的旧数据文件
+	CompactMaxFiles int
+
+	// 压缩过滤器, Merge 重写有效的记录时决定保留, 删除还是改写
+	// Default: nil 表示保留所有有效的记录
+	CompactionFilter CompactionFilter
+
+	// 写入时 value 的压缩算法, 压缩算法记录在每条记录中, 修改之后旧的数据仍然可以读取
+	// Default: NoCompression 表示不压缩
+	Compression CompressionType
+
+	// 数据文件加密使用的密钥, 长度为 16, 24 或者 32 字节, 密钥 id 为 0
+	// Default: nil 表示不加密
+	EncryptionKey []byte
+
+	// 需要轮换密钥时通过 KeyProvider 提供密钥, 不能和 EncryptionKey 同时设置
+	// 每条记录都保存了密钥 id, 轮换之后旧的数据仍然可以读取, merge 时使用新的密钥重新加密
+	KeyProvider KeyProvider
+
+	// 启动时遇到损坏的记录的处理方式, 最后一个数据文件末尾不完整的记录总是会被截断
+	// Default: TolerateCorruptedTail 表示其他数据文件中的记录损坏时启动失败
+	RecoveryMode RecoveryMode
+
+	// mergeInstallHook 测试时用来在启动安装 merge 目录的每一步之后注入崩溃
+	mergeInstallHook func(step string) error
 }
 
 // IteratorOption 索引迭代器的配置项
@@ -47,6 +100,12 @@ type IteratorOption struct {
 	// 是否反向迭代
 	// 默认正向迭代
 	Reverse bool
+
+	// 遍历范围的下界(包含), 默认为空表示不限制
+	LowerBound []byte
+
+	// 遍历范围的上界(不包含), 默认为空表示不限制
+	UpperBound []byte
 }
 
 var DefaultIteratorOption = IteratorOption{
@@ -82,4 +141,71 @@ const (
 
 	// BPlusTree 索引
 	BPlusTree
+
+	// Sharded 分片索引, key 按照哈希值分散到 IndexShards 个 BTree 中
+	Sharded
+
+	// SkipList 并发跳表索引, 读取和遍历不加锁, 遍历时不需要复制索引
+	SkipList
 )
+
+// IOType 读写数据文件的 IO 类型
+type IOType = fio.FileIOType
+
+const (
+	// StandardIO 标准文件 IO, 每次读写都是一次系统调用
+	StandardIO = fio.StandardFIO
+
+	// MMapIO 可读写的内存映射, 活跃文件按照 DataFileSize 预分配空间, 读写不需要系统调用
+	// 关闭时截断预分配的空间, 崩溃之后末尾全 0 的数据在启动时截断
+	MMapIO = fio.MemoryMapRWFIO
+)
+
+// RecoveryMode 启动时遇到损坏的记录的处理方式
+type RecoveryMode = int8
+
+const (
+	// TolerateCorruptedTail 只容忍最后一个数据文件末尾的损坏, 写入过程中崩溃会留下不完整的记录
+	// 其他数据文件中的记录损坏时启动失败
+	TolerateCorruptedTail RecoveryMode = iota
+
+	// SkipCorruptedRecords 跳过旧数据文件中损坏的记录, 无法确定记录长度时跳过文件中剩余的数据
+	// 跳过的数据计入可回收空间, merge 之后不再出现
+	SkipCorruptedRecords
+)
+
+// CompressionType value 的压缩算法
+type CompressionType = data.CompressionType
+
+const (
+	// NoCompression 不压缩
+	NoCompression = data.NoCompression
+
+	// Snappy 压缩, 速度快
+	Snappy = data.SnappyCompression
+
+	// Zstd 压缩, 压缩率高
+	Zstd = data.ZstdCompression
+)
+
+// KeyProvider 提供数据文件加密使用的密钥
+type KeyProvider = data.KeyProvider
+
+// KeyRing 保存在内存中的一组密钥, CurrentID 为写入新数据时使用的密钥 id
+type KeyRing = data.KeyRing
+
+// MergeOptions merge 的配置项
+type MergeOptions struct {
+	// 每秒最多读取的数据文件字节数
+	// Default: 0 表示不限制
+	BytesPerSec int64
+
+	// 进度回调, merge 开始, 每完成一个数据文件以及 merge 结束时调用
+	// Default: nil 表示不需要回调
+	Progress func(status MergeStatus)
+}
+
+// DefaultMergeOptions 默认配置
+var DefaultMergeOptions = MergeOptions{
+	BytesPerSec: 0,
+}
@@ -0,0 +1,164 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"GoKeeper/fio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// isCorruption 判断读取记录时的错误是否是数据损坏造成的
+// 解密失败等其他错误和数据本身无关, 不能截断或者跳过
+func isCorruption(err error) bool {
+	return errors.Is(err, data.ErrInvalidCRC) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// readDataFile 依次读取数据文件中的每一条有效记录, 按照 RecoveryMode 处理损坏的记录
+// 写入过程中崩溃会在最后一个数据文件末尾留下不完整的记录, 截断到最后一条有效记录之后的位置
+// 损坏的位置之后还有有效的记录时不是末尾, 不能截断
+// 返回文件中最后一条有效记录之后的位置
+func (db *DB) readDataFile(dataFile *data.DataFile, fn func(logRecord *data.LogRecord, pos *data.LogRecordPos)) (int64, error) {
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return 0, err
+	}
+	offset := dataFile.HeaderSize()
+	for offset < fileSize {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err == nil {
+			dataFile.LogicalSize += data.LogicalRecordSize(logRecord)
+			fn(logRecord, &data.LogRecordPos{
+				Fid:    dataFile.FileID,
+				Offset: offset,
+				Size:   uint32(size),
+				Expire: logRecord.Expire,
+			})
+			offset += size
+			continue
+		}
+		if !isCorruption(err) {
+			return 0, err
+		}
+
+		// 最后一个数据文件, 截断末尾损坏的数据
+		if dataFile == db.activeFile {
+			// 损坏的位置之后还有有效的记录, 说明不是写入过程中崩溃留下的末尾, 和旧的数据文件一样处理
+			if next := findNextRecord(dataFile, offset, fileSize); next < fileSize {
+				if db.options.RecoveryMode != SkipCorruptedRecords {
+					return 0, fmt.Errorf("%w: data file %d at offset %d: %v", ErrDataFileCorrupted, dataFile.FileID, offset, err)
+				}
+				log.Printf("skip the corrupted data in data file %d from offset %d to %d", dataFile.FileID, offset, next)
+				db.markDead(&data.LogRecordPos{Fid: dataFile.FileID, Offset: offset, Size: uint32(next - offset)})
+				offset = next
+				continue
+			}
+			fileName := data.GetDataFileName(db.options.DirPath, dataFile.FileID)
+			// 先关闭文件再截断, 内存映射的文件关闭时会截断为映射时的大小, 重新打开之后才能得到截断之后的大小
+			if err = dataFile.IoManager.Close(); err != nil {
+				return 0, err
+			}
+			if err = os.Truncate(fileName, offset); err != nil {
+				return 0, err
+			}
+			if dataFile.IoManager, err = fio.NewIOManager(fileName, db.startupIOType()); err != nil {
+				return 0, err
+			}
+			dataFile.Preallocate(db.options.DataFileSize)
+			log.Printf("truncate the corrupted tail of data file %d at offset %d, %d bytes dropped",
+				dataFile.FileID, offset, fileSize-offset)
+			return offset, nil
+		}
+		// 旧的数据文件读取完毕
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		next, err := db.skipCorruptedRecord(dataFile, offset, size, err)
+		if err != nil {
+			return 0, err
+		}
+		if next < 0 {
+			db.markDead(&data.LogRecordPos{Fid: dataFile.FileID, Offset: offset, Size: uint32(fileSize - offset)})
+			break
+		}
+		db.markDead(&data.LogRecordPos{Fid: dataFile.FileID, Offset: offset, Size: uint32(size)})
+		offset = next
+	}
+	return offset, nil
+}
+
+// truncateTornHeader 创建数据文件之后写入文件头的过程中崩溃, 文件中只有不完整的文件头, 清空文件
+func (db *DB) truncateTornHeader(fid uint32) error {
+	fileName := data.GetDataFileName(db.options.DirPath, fid)
+	stat, err := os.Stat(fileName)
+	if err != nil || stat.Size() >= data.FileHeaderSize {
+		return err
+	}
+	content, err := os.ReadFile(fileName)
+	if err != nil || !data.IsTornHeader(content) {
+		return err
+	}
+	if err = os.Truncate(fileName, 0); err != nil {
+		return err
+	}
+	log.Printf("truncate the torn header of data file %d, %d bytes dropped", fid, len(content))
+	return nil
+}
+
+// skipCorruptedRecord 处理旧数据文件中损坏的记录, 只有 SkipCorruptedRecords 模式下可以跳过
+// 返回下一条记录的位置, 记录的长度无法确定时返回 -1, 表示跳过文件中剩余的数据
+func (db *DB) skipCorruptedRecord(dataFile *data.DataFile, offset, size int64, err error) (int64, error) {
+	if !isCorruption(err) {
+		return 0, err
+	}
+	if db.options.RecoveryMode != SkipCorruptedRecords {
+		return 0, fmt.Errorf("%w: data file %d at offset %d: %v", ErrDataFileCorrupted, dataFile.FileID, offset, err)
+	}
+	if errors.Is(err, data.ErrInvalidCRC) && size > 0 {
+		log.Printf("skip the corrupted record in data file %d at offset %d, %d bytes dropped", dataFile.FileID, offset, size)
+		return offset + size, nil
+	}
+	log.Printf("skip the corrupted data in data file %d from offset %d to the end", dataFile.FileID, offset)
+	return -1, nil
+}
+
+// findNextRecord 从损坏的位置逐字节向后查找下一条 crc 校验通过的记录, 找不到时返回文件的大小
+// 剩余的数据一次读取到内存中, 每个位置直接从内存中解码和校验, 不需要重新读取文件
+// 有效的记录中至少有一个非 0 的字节, 只需要查找到最后一个非 0 的字节, 之后预分配的空间不需要读取, 保持为 0 即可
+func findNextRecord(dataFile *data.DataFile, offset, fileSize int64) int64 {
+	end := lastNonZeroOffset(dataFile, offset, fileSize)
+	if end <= offset {
+		return fileSize
+	}
+	buf := make([]byte, fileSize-offset)
+	if _, err := dataFile.IoManager.Read(buf[:end-offset+1], offset); err != nil {
+		return fileSize
+	}
+	for next := int64(1); next <= end-offset; next++ {
+		if data.IsRecordAt(buf[next:]) {
+			return offset + next
+		}
+	}
+	return fileSize
+}
+
+// lastNonZeroOffset 返回 [offset, fileSize) 中最后一个非 0 字节的位置, 全部是 0 时返回 offset
+func lastNonZeroOffset(dataFile *data.DataFile, offset, fileSize int64) int64 {
+	buf := make([]byte, 64*1024)
+	for end := fileSize; end > offset; {
+		start := max(offset, end-int64(len(buf)))
+		chunk := buf[:end-start]
+		if _, err := dataFile.IoManager.Read(chunk, start); err != nil {
+			return fileSize
+		}
+		for i := len(chunk) - 1; i >= 0; i-- {
+			if chunk[i] != 0 {
+				return start + int64(i)
+			}
+		}
+		end = start
+	}
+	return offset
+}
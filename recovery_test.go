@@ -0,0 +1,234 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"GoKeeper/fio"
+	"GoKeeper/util"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+// appendToFile 在文件末尾追加数据, 模拟写入过程中崩溃留下的不完整记录
+func appendToFile(t *testing.T, fileName string, buf []byte) {
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write(buf)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+}
+
+func TestOpen_TruncateCorruptedTail(t *testing.T) {
+	record, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq([]byte("torn"), nonTransactionKey),
+		Value: util.GetRandomValue(128),
+	})
+	tails := map[string][]byte{
+		"short record": record[:len(record)/2],
+		"short header": record[:3],
+		"bad crc":      append(append([]byte{}, record[:len(record)-1]...), record[len(record)-1]+1),
+		"zeros":        make([]byte, 64),
+	}
+	for _, indexType := range []IndexType{Btree, BPlusTree} {
+		for name, tail := range tails {
+			opts := DefaultOptions
+			dir, _ := os.MkdirTemp("", "goKeeper-recovery")
+			opts.DirPath = dir
+			opts.IndexType = indexType
+			db, err := Open(opts)
+			assert.Nil(t, err)
+			expected := make(map[int][]byte)
+			for i := 0; i < 100; i++ {
+				expected[i] = util.GetRandomValue(32)
+				assert.Nil(t, db.Put(util.GetRandomKey(i), expected[i]))
+			}
+			assert.Nil(t, db.Close())
+
+			fileName := data.GetDataFileName(dir, 0)
+			stat, err := os.Stat(fileName)
+			assert.Nil(t, err)
+			appendToFile(t, fileName, tail)
+
+			// 启动时截断末尾不完整的记录, 之后可以正常写入
+			db, err = Open(opts)
+			assert.Nil(t, err, name)
+			truncated, err := os.Stat(fileName)
+			assert.Nil(t, err)
+			assert.Equal(t, stat.Size(), truncated.Size(), name)
+			_, err = db.Get([]byte("torn"))
+			assert.Equal(t, ErrKeyNotFound, err)
+			for i := 100; i < 200; i++ {
+				expected[i] = util.GetRandomValue(32)
+				assert.Nil(t, db.Put(util.GetRandomKey(i), expected[i]))
+			}
+			assert.Nil(t, db.Close())
+
+			db, err = Open(opts)
+			assert.Nil(t, err)
+			checkValues(t, db, expected)
+			destroyDB(db)
+		}
+	}
+}
+
+func TestOpen_TruncateTornHeader(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-recovery-header")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("name"), []byte("Sakura")))
+	assert.Nil(t, db.Close())
+
+	// 新的数据文件只写入了一部分文件头
+	content, err := os.ReadFile(data.GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(data.GetDataFileName(dir, 1), content[:10], 0644))
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err := db.Get([]byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Sakura"), val)
+	assert.Nil(t, db.Put([]byte("city"), []byte("Tokyo")))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err = db.Get([]byte("city"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Tokyo"), val)
+	destroyDB(db)
+}
+
+func TestOpen_CorruptedOlderFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-recovery-older")
+	opts.DirPath = dir
+	opts.DataFileSize = 8 * 1024
+	opts.MergeThreshold = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	expected := make(map[int][]byte)
+	for i := 0; i < 500; i++ {
+		expected[i] = util.GetRandomValue(64)
+		assert.Nil(t, db.Put(util.GetRandomKey(i), expected[i]))
+	}
+	pos := db.index.Get(util.GetRandomKey(10))
+	assert.Equal(t, uint32(0), pos.Fid)
+	assert.Nil(t, db.Close())
+
+	// 修改第一个数据文件中一条记录的 value
+	fileName := data.GetDataFileName(dir, 0)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	content[pos.Offset+int64(pos.Size)-1]++
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+
+	// 1. 默认启动失败, 不会修改旧的数据文件
+	_, err = Open(opts)
+	assert.ErrorIs(t, err, ErrDataFileCorrupted)
+	after, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, content, after)
+
+	// 2. 跳过损坏的记录, 其他数据不受影响
+	opts.RecoveryMode = SkipCorruptedRecords
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	expected[10] = nil
+	checkValues(t, db, expected)
+	assert.Greater(t, db.Stat().ReclaimableSize, int64(pos.Size-1))
+
+	// 3. merge 之后损坏的记录被清理, 默认的模式也可以启动
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	opts.RecoveryMode = TolerateCorruptedTail
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checkValues(t, db, expected)
+	destroyDB(db)
+}
+
+func TestOpen_CorruptedActiveFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-recovery-active")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	expected := make(map[int][]byte)
+	for i := 0; i < 100; i++ {
+		expected[i] = util.GetRandomValue(64)
+		assert.Nil(t, db.Put(util.GetRandomKey(i), expected[i]))
+	}
+	pos := db.index.Get(util.GetRandomKey(10))
+	assert.Equal(t, db.activeFile.FileID, pos.Fid)
+	assert.Nil(t, db.Close())
+
+	// 修改最后一个数据文件中间的一条记录, 之后还有有效的记录
+	fileName := data.GetDataFileName(dir, pos.Fid)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	content[pos.Offset+int64(pos.Size)-1]++
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+
+	// 1. 默认启动失败, 不会截断损坏的位置之后的记录
+	_, err = Open(opts)
+	assert.ErrorIs(t, err, ErrDataFileCorrupted)
+	after, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, content, after)
+
+	// 2. 只跳过损坏的记录, 之后可以继续写入
+	opts.RecoveryMode = SkipCorruptedRecords
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	expected[10] = nil
+	checkValues(t, db, expected)
+	for i := 100; i < 150; i++ {
+		expected[i] = util.GetRandomValue(64)
+		assert.Nil(t, db.Put(util.GetRandomKey(i), expected[i]))
+	}
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checkValues(t, db, expected)
+	destroyDB(db)
+}
+
+func TestOpen_RecoveryModeOption(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-recovery-opt")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.RecoveryMode = 5
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestFindNextRecord(t *testing.T) {
+	dir := t.TempDir()
+	dataFile, err := data.OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	// 一大段损坏的数据之后是一条有效的记录, 再之后是预分配的空间
+	garbage := make([]byte, 4*1024*1024)
+	_, _ = rand.New(rand.NewSource(1)).Read(garbage)
+	record, _ := data.EncodeLogRecord(&data.LogRecord{Key: []byte("name"), Value: []byte("Sakura\x00\x00")})
+	assert.Nil(t, dataFile.Write(garbage))
+	assert.Nil(t, dataFile.Write(record))
+	assert.Nil(t, dataFile.Write(make([]byte, 1024)))
+	fileSize := dataFile.WriteOff
+
+	// value 末尾的 0 和预分配的空间连在一起, 依然可以找到这条记录
+	start := time.Now()
+	assert.Equal(t, int64(len(garbage)), findNextRecord(dataFile, 0, fileSize))
+	assert.Less(t, time.Since(start), 10*time.Second)
+
+	// 之后没有有效的记录时返回文件的大小
+	assert.Equal(t, fileSize, findNextRecord(dataFile, int64(len(garbage)), fileSize))
+}
@@ -0,0 +1,360 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"GoKeeper/fio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RepairReport 修复数据目录的报告, 可以直接编码成 JSON
+type RepairReport struct {
+	SourceDir    string       `json:"source_dir"`
+	OutputDir    string       `json:"output_dir"`
+	Records      int          `json:"records"`              // 读取到的有效记录数
+	Keys         int          `json:"keys"`                 // 写入新目录的 key 的数量
+	CorruptBytes int64        `json:"corrupt_bytes"`        // 跳过的损坏数据的大小
+	DroppedTxns  int          `json:"dropped_transactions"` // 没有完成标记而被丢弃的事务
+	LostRanges   []*LostRange `json:"lost_ranges"`
+}
+
+// LostRange 一段损坏的数据
+// 损坏的数据中有哪些 key 已经无法知道, 用前后最近的有效记录的 key 表示可能丢失的范围,
+// 如果 hint 文件中的索引指向这段数据, 对应的 key 一定丢失了, 记录在 Keys 中
+type LostRange struct {
+	File    string   `json:"file"`
+	Start   int64    `json:"start"`
+	End     int64    `json:"end"`
+	PrevKey []byte   `json:"prev_key"` // 损坏的数据之前的最后一个 key, 为空表示之前没有有效的记录
+	NextKey []byte   `json:"next_key"` // 损坏的数据之后的第一个 key, 为空表示之后没有有效的记录
+	Keys    [][]byte `json:"keys"`
+
+	fid uint32
+}
+
+// Repair 从损坏的数据目录中尽可能多地恢复数据, 写入 options.DirPath 指定的新目录, 不会修改原来的目录
+// options.DirPath 必须不存在或者为空, 读取加密的数据使用 options 中的密钥, 写入时使用 options 中的配置
+// 流程:
+//  1. 按照写入的顺序逐条读取每个数据文件中的记录, 遇到损坏的数据时逐字节向后查找下一条 crc 校验通过的记录
+//  2. 和启动时加载索引一样重放记录, 丢弃没有完成标记的事务, 合并操作数
+//  3. 把所有有效的 key 按照顺序写入新目录, 已经过期的 key 不再写入
+func Repair(dirPath string, options Options) (*RepairReport, error) {
+	if entries, err := os.ReadDir(options.DirPath); err == nil && len(entries) > 0 {
+		return nil, ErrRepairDirNotEmpty
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	keyProvider := options.KeyProvider
+	if options.EncryptionKey != nil {
+		keyProvider = &KeyRing{Keys: map[uint32][]byte{0: options.EncryptionKey}}
+	}
+	r := &repairer{
+		report:    &RepairReport{SourceDir: dirPath, OutputDir: options.DirPath},
+		dirPath:   dirPath,
+		cipher:    data.NewCipher(keyProvider),
+		operator:  options.MergeOperator,
+		dataFiles: make(map[uint32]*data.DataFile),
+		entries:   make(map[string]*repairEntry),
+		txns:      make(map[uint64][]*data.TransactionRecord),
+	}
+	defer r.close()
+
+	var fileIds []int
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.DataFileNameSuffix))
+		if err != nil {
+			continue
+		}
+		fileIds = append(fileIds, fileId)
+	}
+	sort.Ints(fileIds)
+
+	for _, fid := range fileIds {
+		if err = r.scanDataFile(uint32(fid)); err != nil {
+			return nil, err
+		}
+	}
+	r.report.DroppedTxns = len(r.txns)
+	r.findLostKeys()
+
+	// 新目录只用来写入, 不需要后台 merge
+	options.MergeCheckInterval = 0
+	db, err := Open(options)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.writeTo(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err = db.Close(); err != nil {
+		return nil, err
+	}
+	return r.report, nil
+}
+
+// repairEntry key 重放之后的状态, 普通记录只保存位置, 合并过操作数的保存合并之后的值
+type repairEntry struct {
+	pos    *data.LogRecordPos
+	value  []byte
+	expire int64
+}
+
+type repairer struct {
+	report    *RepairReport
+	dirPath   string
+	cipher    *data.Cipher
+	operator  MergeOperator
+	dataFiles map[uint32]*data.DataFile
+	entries   map[string]*repairEntry
+	txns      map[uint64][]*data.TransactionRecord // 还没有读取到完成标记的事务
+
+	prevKey []byte     // 最后一条有效记录的 key
+	pending *LostRange // 还没有找到之后第一个 key 的损坏数据
+}
+
+func (r *repairer) close() {
+	for _, dataFile := range r.dataFiles {
+		_ = dataFile.Close()
+	}
+}
+
+// openDataFile 只读打开数据文件, 文件头损坏时从文件的开头查找有效的记录
+func (r *repairer) openDataFile(fid uint32) (*data.DataFile, int64, error) {
+	fileName := data.GetDataFileName(r.dirPath, fid)
+	ioManager, err := fio.NewIOManager(fileName, fio.MemoryMapFIO)
+	if err != nil {
+		return nil, 0, err
+	}
+	fileSize, err := ioManager.Size()
+	if err != nil {
+		_ = ioManager.Close()
+		return nil, 0, err
+	}
+	dataFile := &data.DataFile{FileID: fid, IoManager: ioManager, Cipher: r.cipher}
+	header := make([]byte, min(fileSize, data.FileHeaderSize))
+	if _, err = ioManager.Read(header, 0); err != nil && err != io.EOF {
+		_ = ioManager.Close()
+		return nil, 0, err
+	}
+	dataFile.Header, err = data.DecodeFileHeader(header)
+	if errors.Is(err, data.ErrUnsupportedFileVersion) {
+		_ = ioManager.Close()
+		return nil, 0, err
+	}
+	return dataFile, fileSize, nil
+}
+
+// scanDataFile 读取数据文件中的每一条有效记录, 跳过损坏的数据
+func (r *repairer) scanDataFile(fid uint32) error {
+	dataFile, fileSize, err := r.openDataFile(fid)
+	if err != nil {
+		return err
+	}
+	r.dataFiles[fid] = dataFile
+
+	offset := dataFile.HeaderSize()
+	for offset < fileSize {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		switch {
+		case err == nil:
+			pos := &data.LogRecordPos{Fid: fid, Offset: offset, Size: uint32(size), Expire: logRecord.Expire}
+			if err = r.replay(logRecord, pos); err != nil {
+				return err
+			}
+			offset += size
+		case errors.Is(err, data.ErrEncryptionKeyRequired) || errors.Is(err, data.ErrDecryptFailed):
+			return err
+		case isCorruption(err):
+			next := findNextRecord(dataFile, offset, fileSize)
+			r.addLostRange(dataFile, offset, next)
+			offset = next
+		default:
+			// crc 校验通过但是无法解压, 只丢弃这一条记录
+			r.addLostRange(dataFile, offset, offset+size)
+			offset += size
+		}
+	}
+	return nil
+}
+
+// addLostRange 记录一段损坏的数据, 全部是 0 的数据是预分配的空间, 不算作丢失
+func (r *repairer) addLostRange(dataFile *data.DataFile, start, end int64) {
+	buf := make([]byte, end-start)
+	if _, err := dataFile.IoManager.Read(buf, start); err == nil && bytes.Count(buf, []byte{0}) == len(buf) {
+		return
+	}
+	lostRange := &LostRange{
+		File:    filepath.Base(data.GetDataFileName(r.dirPath, dataFile.FileID)),
+		Start:   start,
+		End:     end,
+		PrevKey: r.prevKey,
+		fid:     dataFile.FileID,
+	}
+	r.report.LostRanges = append(r.report.LostRanges, lostRange)
+	r.report.CorruptBytes += end - start
+	r.pending = lostRange
+}
+
+// replay 和启动时加载索引一样处理一条有效的记录
+func (r *repairer) replay(logRecord *data.LogRecord, pos *data.LogRecordPos) error {
+	r.report.Records++
+	realKey, seqNo := parseLogRecordKey(logRecord.Key)
+	if logRecord.Type != data.LogRecordFinished {
+		r.prevKey = realKey
+		if r.pending != nil {
+			r.pending.NextKey = realKey
+			r.pending = nil
+		}
+	}
+
+	if seqNo == nonTransactionKey {
+		return r.apply(realKey, logRecord, pos)
+	}
+	if logRecord.Type == data.LogRecordFinished {
+		for _, txRecord := range r.txns[seqNo] {
+			if err := r.apply(txRecord.Record.Key, txRecord.Record, txRecord.Pos); err != nil {
+				return err
+			}
+		}
+		delete(r.txns, seqNo)
+		return nil
+	}
+	logRecord.Key = realKey
+	r.txns[seqNo] = append(r.txns[seqNo], &data.TransactionRecord{Pos: pos, Record: logRecord})
+	return nil
+}
+
+func (r *repairer) apply(key []byte, logRecord *data.LogRecord, pos *data.LogRecordPos) error {
+	switch logRecord.Type {
+	case data.LogRecordDeleted:
+		delete(r.entries, string(key))
+	case data.LogRecordRangeDeleted:
+		for k := range r.entries {
+			if (len(key) == 0 || k >= string(key)) && (len(logRecord.Value) == 0 || k < string(logRecord.Value)) {
+				delete(r.entries, k)
+			}
+		}
+	case data.LogRecordOperand:
+		if r.operator == nil {
+			return ErrMergeOperatorNotSet
+		}
+		// 按照写入的顺序逐个合并操作数, 链表中更早的部分损坏时从剩下的值开始合并
+		var existing []byte
+		if entry := r.entries[string(key)]; entry != nil {
+			value, err := r.value(entry)
+			if err != nil {
+				return err
+			}
+			existing = value
+		}
+		_, operand := decodeOperand(logRecord.Value)
+		value, err := r.operator.FullMerge(key, existing, [][]byte{operand})
+		if err != nil {
+			return err
+		}
+		r.entries[string(key)] = &repairEntry{value: value, expire: logRecord.Expire}
+	default:
+		r.entries[string(key)] = &repairEntry{pos: pos, expire: logRecord.Expire}
+	}
+	return nil
+}
+
+// value 读取 key 重放之后的值
+func (r *repairer) value(entry *repairEntry) ([]byte, error) {
+	if entry.pos == nil {
+		return entry.value, nil
+	}
+	logRecord, _, err := r.dataFiles[entry.pos.Fid].ReadLogRecord(entry.pos.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return logRecord.Value, nil
+}
+
+// findLostKeys 查找 hint 文件中指向损坏数据, 并且没有被恢复的 key
+func (r *repairer) findLostKeys() {
+	if len(r.report.LostRanges) == 0 {
+		return
+	}
+	if _, err := os.Stat(filepath.Join(r.dirPath, data.MergeFinishedFileName)); err != nil {
+		return
+	}
+	// 增量压缩之后只保留了完成标识文件, 没有可用的 hint 文件
+	if _, err := os.Stat(filepath.Join(r.dirPath, data.HintFileName)); err != nil {
+		return
+	}
+	ioManager, err := fio.NewIOManager(filepath.Join(r.dirPath, data.HintFileName), fio.MemoryMapFIO)
+	if err != nil {
+		return
+	}
+	hintFile := &data.DataFile{IoManager: ioManager, Cipher: r.cipher}
+	defer hintFile.Close()
+	header := make([]byte, data.FileHeaderSize)
+	if _, err = ioManager.Read(header, 0); err == nil {
+		hintFile.Header, _ = data.DecodeFileHeader(header)
+	}
+
+	// hint 文件损坏时只使用之前的记录
+	offset := hintFile.HeaderSize()
+	for {
+		record, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			return
+		}
+		offset += size
+		if _, ok := r.entries[string(record.Key)]; ok {
+			continue
+		}
+		pos := data.DecodeLogRecordPos(record.Value)
+		for _, lostRange := range r.report.LostRanges {
+			if lostRange.fid == pos.Fid && pos.Offset >= lostRange.Start && pos.Offset < lostRange.End {
+				lostRange.Keys = append(lostRange.Keys, record.Key)
+				break
+			}
+		}
+	}
+}
+
+// writeTo 把所有有效的 key 按照顺序写入新的数据库
+func (r *repairer) writeTo(db *DB) error {
+	keys := make([]string, 0, len(r.entries))
+	now := time.Now().UnixNano()
+	for key, entry := range r.entries {
+		if entry.expire > 0 && entry.expire <= now {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	db.lock.Lock()
+	for _, key := range keys {
+		entry := r.entries[key]
+		value, err := r.value(entry)
+		if err == nil {
+			err = db.putLocked([]byte(key), value, entry.expire)
+		}
+		if err != nil {
+			db.lock.Unlock()
+			return err
+		}
+	}
+	db.lock.Unlock()
+	r.report.Keys = len(keys)
+	return db.Sync()
+}
@@ -0,0 +1,145 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"GoKeeper/fio"
+	"GoKeeper/util"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readAll 读取数据库中所有的 key 和 value
+func readAll(t *testing.T, opts Options) map[string]string {
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	kvs := make(map[string]string)
+	assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+		kvs[string(key)] = string(value)
+		return true
+	}))
+	return kvs
+}
+
+func TestRepair(t *testing.T) {
+	dir := prepareVerifyDB(t, DefaultOptions)
+	defer os.RemoveAll(dir)
+	opts := DefaultOptions
+	opts.DirPath = dir
+	expected := readAll(t, opts)
+
+	// 1. 损坏 merge 之后的第一个数据文件中一条没有被重新写入过的记录
+	dataFile, err := data.OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	rewritten := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		rewritten[string(util.GetRandomKey(i))] = true
+	}
+	var prevKey, lostKey, nextKey []byte
+	var lostOffset, lostSize int64
+	offset := dataFile.HeaderSize()
+	for nextKey == nil {
+		record, size, err := dataFile.ReadLogRecord(offset)
+		assert.Nil(t, err)
+		key, _ := parseLogRecordKey(record.Key)
+		if lostKey != nil {
+			nextKey = key
+		} else if !rewritten[string(key)] && prevKey != nil {
+			lostKey, lostOffset, lostSize = key, offset, size
+		} else {
+			prevKey = key
+		}
+		offset += size
+	}
+	assert.Nil(t, dataFile.Close())
+	fileName := data.GetDataFileName(dir, 0)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	content[lostOffset+lostSize-3]++
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+
+	// 2. 最后一个数据文件末尾的垃圾数据和没有完成标记的事务
+	dataFiles, err := filepath.Glob(filepath.Join(dir, "*"+data.DataFileNameSuffix))
+	assert.Nil(t, err)
+	lastFile := dataFiles[len(dataFiles)-1]
+	record, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq([]byte("txn-key"), 99),
+		Value: []byte("value"),
+	})
+	appendToFile(t, lastFile, append(bytes.Repeat([]byte("x"), 50), record...))
+
+	outDir := dir + "-repaired"
+	defer os.RemoveAll(outDir)
+	opts.DirPath = outDir
+	report, err := Repair(dir, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.DroppedTxns)
+	assert.Equal(t, len(expected)-1, report.Keys)
+	assert.Equal(t, 2, len(report.LostRanges))
+
+	lostRange := report.LostRanges[0]
+	assert.Equal(t, filepath.Base(fileName), lostRange.File)
+	assert.Equal(t, lostOffset, lostRange.Start)
+	assert.Equal(t, lostOffset+lostSize, lostRange.End)
+	assert.Equal(t, prevKey, lostRange.PrevKey)
+	assert.Equal(t, nextKey, lostRange.NextKey)
+	assert.Equal(t, [][]byte{lostKey}, lostRange.Keys)
+
+	lostRange = report.LostRanges[1]
+	assert.Equal(t, filepath.Base(lastFile), lostRange.File)
+	assert.Equal(t, int64(50), lostRange.End-lostRange.Start)
+	assert.Equal(t, []byte("txn-key"), lostRange.NextKey)
+	assert.Equal(t, lostSize+50, report.CorruptBytes)
+
+	// 修复之后的数据目录是完整的, 除了损坏的 key 之外数据都没有丢失
+	verifyReport, err := Verify(outDir)
+	assert.Nil(t, err)
+	assert.True(t, verifyReport.OK)
+	delete(expected, string(lostKey))
+	assert.Equal(t, expected, readAll(t, opts))
+
+	// 3. 输出目录不为空
+	_, err = Repair(dir, opts)
+	assert.Equal(t, ErrRepairDirNotEmpty, err)
+}
+
+func TestRepair_Replay(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "goKeeper-repair")
+	defer os.RemoveAll(dir)
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.MergeOperator = Int64AddOperator{}
+	opts.EncryptionKey = []byte("0123456789abcdef")
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("counter"), []byte("1")))
+	assert.Nil(t, db.Apply([]byte("counter"), []byte("2")))
+	assert.Nil(t, db.Apply([]byte("counter"), []byte("3")))
+	assert.Nil(t, db.Put([]byte("prefix-a"), []byte("a")))
+	assert.Nil(t, db.Put([]byte("prefix-b"), []byte("b")))
+	assert.Nil(t, db.DeletePrefix([]byte("prefix-")))
+	assert.Nil(t, db.Put([]byte("deleted"), []byte("value")))
+	assert.Nil(t, db.Delete([]byte("deleted")))
+	assert.Nil(t, db.PutWithTTL([]byte("expired"), []byte("value"), 1))
+	assert.Nil(t, db.Close())
+
+	outDir := dir + "-repaired"
+	defer os.RemoveAll(outDir)
+	repairOpts := opts
+	repairOpts.DirPath = outDir
+
+	// 加密的数据目录需要提供密钥
+	repairOpts.EncryptionKey = nil
+	_, err = Repair(dir, repairOpts)
+	assert.Equal(t, ErrEncryptionKeyRequired, err)
+
+	repairOpts.EncryptionKey = opts.EncryptionKey
+	report, err := Repair(dir, repairOpts)
+	assert.Nil(t, err)
+	assert.Empty(t, report.LostRanges)
+	assert.Equal(t, 1, report.Keys)
+	assert.Equal(t, map[string]string{"counter": "6"}, readAll(t, repairOpts))
+}
@@ -0,0 +1,364 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"GoKeeper/index"
+	"bytes"
+	"github.com/google/btree"
+	"sync"
+	"time"
+)
+
+// Snapshot 数据库在某一时刻的只读视图
+// 快照创建之后, 写入方在修改内存索引之前会把 key 原来的位置保存到快照的 overlay 中,
+// 读取时优先使用 overlay 中的位置, 所以快照的内存开销只和创建之后被修改的 key 的数量有关
+type Snapshot struct {
+	db       *DB
+	id       uint64
+	readTime int64                     // 快照的创建时间, 用于判断数据是否过期
+	files    map[uint32]*data.DataFile // 创建快照时的数据文件, 快照释放之前不会被删除
+	overlay  *btree.BTreeG[*overlayItem]
+	released bool
+	lock     *sync.RWMutex
+}
+
+// overlayItem 快照创建时 key 的位置信息, pos 为 nil 表示当时 key 不存在
+type overlayItem struct {
+	key []byte
+	pos *data.LogRecordPos
+}
+
+func overlayItemLess(a, b *overlayItem) bool {
+	return bytes.Compare(a.key, b.key) < 0
+}
+
+// NewSnapshot 创建一个快照, 快照可以看到创建时刻数据库中的所有数据
+// 之后的 Put, Delete, WriteBatch.Commit 以及 Merge 都不会影响快照
+// 使用完之后必须调用 Release 释放快照
+func (db *DB) NewSnapshot() *Snapshot {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.snapshotSeq++
+	snapshot := &Snapshot{
+		db:       db,
+		id:       db.snapshotSeq,
+		readTime: time.Now().UnixNano(),
+		files:    make(map[uint32]*data.DataFile, len(db.olderFiles)+1),
+		overlay:  btree.NewG[*overlayItem](32, overlayItemLess),
+		lock:     new(sync.RWMutex),
+	}
+	// 引用当前所有的数据文件
+	if db.activeFile != nil {
+		snapshot.files[db.activeFile.FileID] = db.activeFile
+	}
+	for fid, dataFile := range db.olderFiles {
+		snapshot.files[fid] = dataFile
+	}
+	for fid := range snapshot.files {
+		db.fileRefs[fid]++
+	}
+	db.snapshots[snapshot.id] = snapshot
+	return snapshot
+}
+
+// Get 读取快照创建时 key 对应的数据
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	if s.isReleased() {
+		return nil, ErrSnapshotReleased
+	}
+	logRecordPos := s.lookup(key)
+	if logRecordPos == nil || logRecordPos.IsExpired(s.readTime) {
+		return nil, ErrKeyNotFound
+	}
+	return s.getValueByPosition(logRecordPos)
+}
+
+// NewIterator 创建一个遍历快照数据的迭代器
+func (s *Snapshot) NewIterator(options IteratorOption) *Iterator {
+	return &Iterator{
+		indexIter: newSnapshotIterator(s, options),
+		db:        s.db,
+		snapshot:  s,
+		options:   options,
+	}
+}
+
+// Fold 遍历快照中的所有数据, 函数返回 false 时终止遍历
+func (s *Snapshot) Fold(fn func(key []byte, value []byte) bool) error {
+	if s.isReleased() {
+		return ErrSnapshotReleased
+	}
+	iterator := newSnapshotIterator(s, DefaultIteratorOption)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		value, err := s.getValueByPosition(iterator.Value())
+		if err != nil {
+			return err
+		}
+		if !fn(iterator.Key(), value) {
+			break
+		}
+	}
+	return nil
+}
+
+// Release 释放快照, 释放之后快照不能再使用
+func (s *Snapshot) Release() {
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+	s.release()
+}
+
+// release 释放快照引用的数据文件, 调用前必须持有 db.lock
+func (s *Snapshot) release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+	delete(s.db.snapshots, s.id)
+	for fid := range s.files {
+		if s.db.fileRefs[fid]--; s.db.fileRefs[fid] <= 0 {
+			delete(s.db.fileRefs, fid)
+		}
+	}
+	s.overlay.Clear(false)
+}
+
+func (s *Snapshot) isReleased() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.released
+}
+
+// lookup 查找 key 在快照创建时的位置信息
+// 写入方先保存 overlay 再修改索引, 所以这里要先读索引再读 overlay,
+// 这样即使读到了快照之后写入的位置, 也一定能在 overlay 中找到原来的位置
+func (s *Snapshot) lookup(key []byte) *data.LogRecordPos {
+	pos := s.db.index.Get(key)
+	if item, ok := s.getOverlay(key); ok {
+		return item.pos
+	}
+	return pos
+}
+
+func (s *Snapshot) getOverlay(key []byte) (*overlayItem, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.overlay.Get(&overlayItem{key: key})
+}
+
+// seekOverlay 找到第一个大于(反向时小于)key 的 overlay 记录, inclusive 表示包含 key 本身
+// key 为 nil 时从头(反向时从尾)开始查找
+func (s *Snapshot) seekOverlay(key []byte, inclusive bool, reverse bool) (*overlayItem, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var found *overlayItem
+	visit := func(item *overlayItem) bool {
+		if !inclusive && bytes.Equal(item.key, key) {
+			return true
+		}
+		found = item
+		return false
+	}
+	switch {
+	case key == nil && reverse:
+		s.overlay.Descend(visit)
+	case key == nil:
+		s.overlay.Ascend(visit)
+	case reverse:
+		s.overlay.DescendLessOrEqual(&overlayItem{key: key}, visit)
+	default:
+		s.overlay.AscendGreaterOrEqual(&overlayItem{key: key}, visit)
+	}
+	return found, found != nil
+}
+
+// getValueByPosition 根据位置信息读取数据
+// 优先使用快照引用的数据文件, 其他的数据文件从当前的数据文件集合中查找
+func (s *Snapshot) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	files := s.db.acquireFiles()
+	defer s.db.releaseFiles(files)
+	return s.db.readValue(func(fid uint32) *data.DataFile {
+		if dataFile, ok := s.files[fid]; ok {
+			return dataFile
+		}
+		return files.get(fid)
+	}, logRecordPos)
+}
+
+// saveSnapshotOverlay 在修改 key 的索引之前, 为每个存活的快照保存 key 原来的位置
+// 调用前必须持有 db.lock
+func (db *DB) saveSnapshotOverlay(key []byte) {
+	if len(db.snapshots) == 0 {
+		return
+	}
+	var pos *data.LogRecordPos
+	var loaded bool
+	for _, snapshot := range db.snapshots {
+		snapshot.lock.Lock()
+		if !snapshot.overlay.Has(&overlayItem{key: key}) {
+			if !loaded {
+				pos, loaded = db.index.Get(key), true
+			}
+			snapshot.overlay.ReplaceOrInsert(&overlayItem{key: key, pos: pos})
+		}
+		snapshot.lock.Unlock()
+	}
+}
+
+// closeSnapshots 释放所有存活的快照
+func (db *DB) closeSnapshots() {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	for _, snapshot := range db.snapshots {
+		snapshot.release()
+	}
+}
+
+// snapshotIterator 快照迭代器, 合并遍历内存索引和快照的 overlay
+// 两边都是按 key 有序的, 同一个 key 以 overlay 中的位置为准
+type snapshotIterator struct {
+	snapshot  *Snapshot
+	indexIter index.Iterator
+	reverse   bool
+
+	// 遍历范围 [lowerBound, upperBound)
+	lowerBound []byte
+	upperBound []byte
+
+	overlay      *overlayItem // 当前位置之后的第一条 overlay 记录
+	overlayValid bool
+
+	currKey []byte
+	currPos *data.LogRecordPos
+	valid   bool
+}
+
+func newSnapshotIterator(s *Snapshot, options IteratorOption) *snapshotIterator {
+	lowerBound, upperBound := options.bounds()
+	iterator := &snapshotIterator{
+		snapshot:   s,
+		indexIter:  s.db.index.RangeIterator(options.Reverse, lowerBound, upperBound),
+		reverse:    options.Reverse,
+		lowerBound: lowerBound,
+		upperBound: upperBound,
+	}
+	iterator.Rewind()
+	return iterator
+}
+
+// Rewind 重新回到迭代器的起点
+func (si *snapshotIterator) Rewind() {
+	si.indexIter.Rewind()
+	switch {
+	case si.reverse && len(si.upperBound) != 0:
+		si.overlay, si.overlayValid = si.snapshot.seekOverlay(si.upperBound, false, true)
+	case si.reverse:
+		si.overlay, si.overlayValid = si.snapshot.seekOverlay(nil, true, true)
+	default:
+		si.overlay, si.overlayValid = si.snapshot.seekOverlay(si.lowerBound, true, false)
+	}
+	si.settle()
+}
+
+// Seek 根据传入的 key 查找到第一个大于(或小于)等于的目标 key
+func (si *snapshotIterator) Seek(key []byte) {
+	si.indexIter.Seek(key)
+	switch {
+	case si.reverse && len(si.upperBound) != 0 && bytes.Compare(key, si.upperBound) >= 0:
+		si.overlay, si.overlayValid = si.snapshot.seekOverlay(si.upperBound, false, true)
+	case !si.reverse && bytes.Compare(key, si.lowerBound) < 0:
+		si.overlay, si.overlayValid = si.snapshot.seekOverlay(si.lowerBound, true, false)
+	default:
+		si.overlay, si.overlayValid = si.snapshot.seekOverlay(key, true, si.reverse)
+	}
+	si.settle()
+}
+
+// Next 跳转到下一个 key
+func (si *snapshotIterator) Next() {
+	if !si.valid {
+		return
+	}
+	si.advance(si.currKey)
+	si.settle()
+}
+
+// Valid 是否已经遍历完了所有的 key
+func (si *snapshotIterator) Valid() bool {
+	return si.valid
+}
+
+// Key 当前遍历位置的 key
+func (si *snapshotIterator) Key() []byte {
+	return si.currKey
+}
+
+// Value 当前遍历位置的位置信息
+func (si *snapshotIterator) Value() *data.LogRecordPos {
+	return si.currPos
+}
+
+// Close 关闭迭代器
+func (si *snapshotIterator) Close() {
+	si.indexIter.Close()
+}
+
+// before 判断 a 是否在遍历顺序上位于 b 之前
+func (si *snapshotIterator) before(a, b []byte) bool {
+	if si.reverse {
+		return bytes.Compare(a, b) > 0
+	}
+	return bytes.Compare(a, b) < 0
+}
+
+// advance 把两边的游标都移动到 key 之后
+func (si *snapshotIterator) advance(key []byte) {
+	if si.indexIter.Valid() && bytes.Equal(si.indexIter.Key(), key) {
+		si.indexIter.Next()
+	}
+	si.overlay, si.overlayValid = si.snapshot.seekOverlay(key, false, si.reverse)
+}
+
+// settle 找到下一个在快照中存在并且没有过期的 key
+func (si *snapshotIterator) settle() {
+	for {
+		indexValid := si.indexIter.Valid()
+		if !indexValid && !si.overlayValid {
+			si.valid = false
+			return
+		}
+
+		var key []byte
+		var pos *data.LogRecordPos
+		if si.overlayValid && (!indexValid || !si.before(si.indexIter.Key(), si.overlay.key)) {
+			// overlay 中的记录排在前面或者和索引中的 key 相同
+			key, pos = si.overlay.key, si.overlay.pos
+		} else {
+			// 只在索引中出现的 key, 还需要确认 overlay 中是否后来加入了这个 key
+			key, pos = si.indexIter.Key(), si.indexIter.Value()
+			if item, ok := si.snapshot.getOverlay(key); ok {
+				pos = item.pos
+			}
+		}
+
+		// 按照遍历顺序最靠前的 key 已经超出范围, 后面的 key 也都超出范围
+		if !inRange(key, si.lowerBound, si.upperBound) {
+			si.valid = false
+			return
+		}
+		if pos == nil || pos.IsExpired(si.snapshot.readTime) {
+			si.advance(key)
+			continue
+		}
+		si.currKey, si.currPos, si.valid = key, pos, true
+		return
+	}
+}
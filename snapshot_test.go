@@ -0,0 +1,152 @@
+package GoKeeper
+
+import (
+	"GoKeeper/util"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestDB_NewSnapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-snapshot")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10; i++ {
+		err = db.Put(util.GetRandomKey(i), []byte("old"))
+		assert.Nil(t, err)
+	}
+	snapshot := db.NewSnapshot()
+
+	// 快照之后的修改: 覆盖, 删除, 新增, 批量写
+	err = db.Put(util.GetRandomKey(1), []byte("new"))
+	assert.Nil(t, err)
+	err = db.Delete(util.GetRandomKey(2))
+	assert.Nil(t, err)
+	err = db.Put(util.GetRandomKey(100), []byte("new"))
+	assert.Nil(t, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(util.GetRandomKey(3), []byte("new")))
+	assert.Nil(t, wb.Delete(util.GetRandomKey(4)))
+	assert.Nil(t, wb.Commit())
+
+	// 1. 数据库中可以看到新的数据
+	val, err := db.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+	_, err = db.Get(util.GetRandomKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 2. 快照中只能看到创建时的数据
+	for i := 0; i < 10; i++ {
+		val, err = snapshot.Get(util.GetRandomKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("old"), val)
+	}
+	_, err = snapshot.Get(util.GetRandomKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 3. 正向和反向迭代都只看到快照中的 10 个 key
+	iterator := snapshot.NewIterator(DefaultIteratorOption)
+	var keys [][]byte
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keys = append(keys, iterator.Key())
+		value, err := iterator.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("old"), value)
+	}
+	iterator.Close()
+	assert.Equal(t, 10, len(keys))
+
+	reverseIter := snapshot.NewIterator(IteratorOption{Reverse: true})
+	var reverseKeys [][]byte
+	for reverseIter.Rewind(); reverseIter.Valid(); reverseIter.Next() {
+		reverseKeys = append(reverseKeys, reverseIter.Key())
+	}
+	reverseIter.Close()
+	assert.Equal(t, len(keys), len(reverseKeys))
+	for i := range keys {
+		assert.Equal(t, keys[i], reverseKeys[len(keys)-1-i])
+	}
+
+	// 4. Seek 到一个快照之后被删除的 key
+	iterator = snapshot.NewIterator(DefaultIteratorOption)
+	iterator.Seek(util.GetRandomKey(2))
+	assert.True(t, iterator.Valid())
+	assert.Equal(t, util.GetRandomKey(2), iterator.Key())
+	iterator.Close()
+
+	// 5. Fold
+	var count int
+	err = snapshot.Fold(func(key []byte, value []byte) bool {
+		assert.Equal(t, []byte("old"), value)
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 10, count)
+
+	// 6. 释放之后不能再使用
+	snapshot.Release()
+	_, err = snapshot.Get(util.GetRandomKey(1))
+	assert.Equal(t, ErrSnapshotReleased, err)
+	assert.Equal(t, 0, len(db.snapshots))
+	assert.Equal(t, 0, len(db.fileRefs))
+}
+
+func TestSnapshot_ConcurrentWrite(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-snapshot-concurrent")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err = db.Put(util.GetRandomKey(i), util.GetRandomKey(i))
+		assert.Nil(t, err)
+	}
+	snapshot := db.NewSnapshot()
+	defer snapshot.Release()
+
+	// 一边写入一边遍历快照
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 2000; i++ {
+			if i%2 == 0 {
+				_ = db.Delete(util.GetRandomKey(i))
+			} else {
+				_ = db.Put(util.GetRandomKey(i), util.GetRandomValue(10))
+			}
+		}
+	}()
+
+	var count int
+	err = snapshot.Fold(func(key []byte, value []byte) bool {
+		assert.Equal(t, key, value)
+		count++
+		return true
+	})
+	wg.Wait()
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, count)
+
+	// 写入完成之后快照依然不变
+	count = 0
+	err = snapshot.Fold(func(key []byte, value []byte) bool {
+		assert.Equal(t, key, value)
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, count)
+}
@@ -0,0 +1,325 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"sync"
+)
+
+// subscribeBatchSize 订阅者每次持有读锁时最多读取的事件数量
+const subscribeBatchSize = 128
+
+// ChangeType 变更事件类型
+type ChangeType = int8
+
+const (
+	// ChangePut 写入数据
+	ChangePut ChangeType = iota + 1
+
+	// ChangeDelete 删除数据
+	ChangeDelete
+
+	// ChangeDeleteRange 范围删除, Key 为范围的下界, Value 为范围的上界(为空表示没有上界)
+	ChangeDeleteRange
+)
+
+// ChangeEvent 变更事件
+type ChangeEvent struct {
+	Type   ChangeType
+	Key    []byte
+	Value  []byte // 删除事件为 nil, 合并操作数为合并之后的值
+	Expire int64  // 过期时间, 0 表示永不过期
+	Seq    uint64 // 事件序列号, 按照写入顺序递增, 可以用来恢复订阅
+}
+
+// Subscription 变更订阅
+// 订阅者直接从数据文件中按顺序读取日志记录, 落后的订阅者不会丢失事件, 也不会阻塞写入
+type Subscription struct {
+	db     *DB
+	id     uint64
+	prefix []byte
+
+	// 只投递序列号不小于 fromSeq 的事件
+	fromSeq uint64
+	// 下一条要读取的日志记录位置
+	fid    uint32
+	offset int64
+	// 暂存读取到的事务数据, 读取到事务完成的记录之后再投递
+	transactionRecords map[uint64][]*data.TransactionRecord
+
+	events    chan *ChangeEvent
+	notify    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+	err       error
+}
+
+// Subscribe 订阅前缀为 prefix 的 key 的变更事件, 从序列号 fromSeq 开始(包含 fromSeq)
+// fromSeq 为 0 表示从数据文件的起点开始, 为 NextSeq 的返回值表示只订阅之后的写入
+// 恢复订阅时传入最后收到的事件序列号加一即可
+// 注意: merge 之后被清理的历史数据无法再读取到, 只能读取到 merge 之后保留的数据
+// merge 安装之后重写过的文件以及增量压缩重写过的文件中记录的序列号都变了, fromSeq 指向这些文件内部时返回 ErrSubscribeSeqMerged
+func (db *DB) Subscribe(prefix []byte, fromSeq uint64) (*Subscription, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.subscriptions == nil {
+		return nil, ErrDatabaseClosed
+	}
+
+	fid, offset := decodeChangeSeq(fromSeq)
+	if fromSeq != 0 && fid < db.mergedFileId {
+		return nil, ErrSubscribeSeqMerged
+	}
+	// 从文件的起点开始时和文件是否被重写过无关
+	if dataFile := db.olderFiles[fid]; dataFile != nil && dataFile.IsCompacted() && offset > dataFile.HeaderSize() {
+		return nil, ErrSubscribeSeqMerged
+	}
+	db.subscriptionSeq++
+	sub := &Subscription{
+		db:                 db,
+		id:                 db.subscriptionSeq,
+		prefix:             prefix,
+		fromSeq:            fromSeq,
+		fid:                fid,
+		transactionRecords: make(map[uint64][]*data.TransactionRecord),
+		events:             make(chan *ChangeEvent, subscribeBatchSize),
+		notify:             make(chan struct{}, 1),
+		done:               make(chan struct{}),
+	}
+	db.subscriptions[sub.id] = sub
+
+	sub.wg.Add(1)
+	go sub.run()
+	return sub, nil
+}
+
+// NextSeq 返回下一次写入的事件序列号
+func (db *DB) NextSeq() uint64 {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.activeFile == nil {
+		return 0
+	}
+	return encodeChangeSeq(db.activeFile.FileID, db.activeFile.WriteOff)
+}
+
+// Events 返回变更事件的通道, 订阅结束之后通道会被关闭
+func (sub *Subscription) Events() <-chan *ChangeEvent {
+	return sub.events
+}
+
+// Err 返回导致订阅结束的错误, 需要在事件通道关闭之后调用
+func (sub *Subscription) Err() error {
+	return sub.err
+}
+
+// Close 结束订阅
+func (sub *Subscription) Close() {
+	sub.closeOnce.Do(func() {
+		close(sub.done)
+	})
+	sub.wg.Wait()
+
+	sub.db.lock.Lock()
+	defer sub.db.lock.Unlock()
+	delete(sub.db.subscriptions, sub.id)
+}
+
+// run 不断从数据文件中读取新的事件并投递
+func (sub *Subscription) run() {
+	defer sub.wg.Done()
+	defer close(sub.events)
+
+	for {
+		events, err := sub.poll()
+		for _, event := range events {
+			select {
+			case sub.events <- event:
+			case <-sub.done:
+				return
+			}
+		}
+		if err != nil {
+			sub.err = err
+			return
+		}
+
+		// 已经读取到最新的位置, 等待新的写入
+		if len(events) == 0 {
+			select {
+			case <-sub.notify:
+			case <-sub.done:
+				return
+			}
+		}
+	}
+}
+
+// poll 持有读锁, 从当前位置开始读取一批事件
+func (sub *Subscription) poll() ([]*ChangeEvent, error) {
+	db := sub.db
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.activeFile == nil {
+		return nil, nil
+	}
+
+	var events []*ChangeEvent
+	for len(events) < subscribeBatchSize {
+		dataFile := db.getDataFile(sub.fid)
+		if dataFile == nil {
+			// 文件不存在(已经被 merge 清理或者还没有创建), 从之后的第一个文件开始
+			fid, ok := db.nextFileId(sub.fid)
+			if !ok {
+				return events, nil
+			}
+			sub.fid, sub.offset = fid, 0
+			continue
+		}
+
+		// 跳过文件头
+		if sub.offset < dataFile.HeaderSize() {
+			sub.offset = dataFile.HeaderSize()
+		}
+		// 只能读取到已经写入完成的位置
+		if dataFile == db.activeFile && sub.offset >= db.activeFile.WriteOff {
+			return events, nil
+		}
+
+		logRecord, size, err := dataFile.ReadLogRecord(sub.offset)
+		if err != nil {
+			if errors.Is(err, io.EOF) && dataFile != db.activeFile {
+				// 旧的数据文件读取完毕, 继续读取下一个文件
+				fid, ok := db.nextFileId(sub.fid + 1)
+				if !ok {
+					return events, nil
+				}
+				sub.fid, sub.offset = fid, 0
+				continue
+			}
+			return events, err
+		}
+
+		pos := &data.LogRecordPos{
+			Fid:    sub.fid,
+			Offset: sub.offset,
+			Size:   uint32(size),
+			Expire: logRecord.Expire,
+		}
+		sub.offset += size
+
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		if seqNo == nonTransactionKey {
+			if events, err = sub.appendEvent(events, realKey, logRecord, pos); err != nil {
+				return events, err
+			}
+			continue
+		}
+
+		// 事务数据读取到事务完成的记录之后才投递
+		if logRecord.Type == data.LogRecordFinished {
+			for _, txRecord := range sub.transactionRecords[seqNo] {
+				if events, err = sub.appendEvent(events, txRecord.Record.Key, txRecord.Record, txRecord.Pos); err != nil {
+					return events, err
+				}
+			}
+			delete(sub.transactionRecords, seqNo)
+		} else {
+			logRecord.Key = realKey
+			sub.transactionRecords[seqNo] = append(sub.transactionRecords[seqNo], &data.TransactionRecord{
+				Pos:    pos,
+				Record: logRecord,
+			})
+		}
+	}
+	return events, nil
+}
+
+// appendEvent 把日志记录转换为变更事件, 过滤掉序列号过小和前缀不匹配的记录
+// 调用前必须持有 db.lock
+func (sub *Subscription) appendEvent(events []*ChangeEvent, key []byte, logRecord *data.LogRecord,
+	pos *data.LogRecordPos) ([]*ChangeEvent, error) {
+	seq := encodeChangeSeq(pos.Fid, pos.Offset)
+	if seq < sub.fromSeq {
+		return events, nil
+	}
+	// 范围删除只要和订阅的前缀有交集就需要投递
+	if logRecord.Type == data.LogRecordRangeDeleted {
+		if len(sub.prefix) != 0 && !rangeOverlaps(key, logRecord.Value, sub.prefix, prefixUpperBound(sub.prefix)) {
+			return events, nil
+		}
+	} else if !bytes.HasPrefix(key, sub.prefix) {
+		return events, nil
+	}
+
+	event := &ChangeEvent{Key: key, Expire: logRecord.Expire, Seq: seq}
+	switch logRecord.Type {
+	case data.LogRecordNormal:
+		event.Type, event.Value = ChangePut, logRecord.Value
+	case data.LogRecordDeleted:
+		event.Type = ChangeDelete
+	case data.LogRecordRangeDeleted:
+		event.Type, event.Value = ChangeDeleteRange, logRecord.Value
+	case data.LogRecordOperand:
+		value, err := sub.db.readValue(sub.db.getDataFile, pos)
+		if err != nil {
+			return events, err
+		}
+		event.Type, event.Value = ChangePut, value
+	default:
+		return events, nil
+	}
+	return append(events, event), nil
+}
+
+// nextFileId 找到 id 不小于 fid 的第一个数据文件
+// 调用前必须持有 db.lock
+func (db *DB) nextFileId(fid uint32) (uint32, bool) {
+	next, found := db.activeFile.FileID, db.activeFile.FileID >= fid
+	for id := range db.olderFiles {
+		if id >= fid && (!found || id < next) {
+			next, found = id, true
+		}
+	}
+	return next, found
+}
+
+// notifySubscribers 通知所有订阅者有新的写入
+// 调用前必须持有 db.lock
+func (db *DB) notifySubscribers() {
+	for _, sub := range db.subscriptions {
+		select {
+		case sub.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// closeSubscriptions 关闭所有的订阅
+func (db *DB) closeSubscriptions() {
+	db.lock.Lock()
+	subs := make([]*Subscription, 0, len(db.subscriptions))
+	for _, sub := range db.subscriptions {
+		subs = append(subs, sub)
+	}
+	db.subscriptions = nil
+	db.lock.Unlock()
+
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
+
+// encodeChangeSeq 事件序列号由数据文件 id 和记录在文件中的偏移量组成, 天然按照写入顺序递增
+func encodeChangeSeq(fid uint32, offset int64) uint64 {
+	return uint64(fid)<<32 | uint64(offset)
+}
+
+// decodeChangeSeq 从事件序列号中解析出数据文件 id 和偏移量
+func decodeChangeSeq(seq uint64) (uint32, int64) {
+	return uint32(seq >> 32), int64(seq & math.MaxUint32)
+}
@@ -0,0 +1,240 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"GoKeeper/util"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// receiveEvents 从订阅中读取 n 个事件, 超时返回已经读取到的事件
+func receiveEvents(sub *Subscription, n int) []*ChangeEvent {
+	var events []*ChangeEvent
+	timeout := time.After(5 * time.Second)
+	for len(events) < n {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		case <-timeout:
+			return events
+		}
+	}
+	return events
+}
+
+func TestDB_Subscribe(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-subscribe")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	sub, err := db.Subscribe([]byte("user:"), 0)
+	assert.Nil(t, err)
+
+	// 1. 普通写入, 删除, 批量写入, 前缀不匹配的 key 不会收到
+	assert.Nil(t, db.Put([]byte("user:1"), []byte("a")))
+	assert.Nil(t, db.Put([]byte("order:1"), []byte("b")))
+	assert.Nil(t, db.Delete([]byte("user:1")))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("user:2"), []byte("c")))
+	assert.Nil(t, wb.Put([]byte("order:2"), []byte("d")))
+	assert.Nil(t, wb.Commit())
+
+	events := receiveEvents(sub, 3)
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, ChangePut, events[0].Type)
+	assert.Equal(t, []byte("user:1"), events[0].Key)
+	assert.Equal(t, []byte("a"), events[0].Value)
+	assert.Equal(t, ChangeDelete, events[1].Type)
+	assert.Equal(t, []byte("user:1"), events[1].Key)
+	assert.Nil(t, events[1].Value)
+	assert.Equal(t, ChangePut, events[2].Type)
+	assert.Equal(t, []byte("user:2"), events[2].Key)
+	assert.Equal(t, []byte("c"), events[2].Value)
+	assert.True(t, events[0].Seq < events[1].Seq && events[1].Seq < events[2].Seq)
+	lastSeq := events[2].Seq
+
+	// 2. 跨越多个数据文件的大量写入, 事件按照顺序全部送达
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(append([]byte("user:"), util.GetRandomKey(i)...), util.GetRandomValue(64)))
+	}
+	events = receiveEvents(sub, 1000)
+	assert.Equal(t, 1000, len(events))
+	for i, event := range events {
+		assert.Equal(t, append([]byte("user:"), util.GetRandomKey(i)...), event.Key)
+		assert.True(t, event.Seq > lastSeq)
+		lastSeq = event.Seq
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+	sub.Close()
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+	assert.Nil(t, sub.Err())
+
+	// 3. 只订阅之后的写入
+	sub2, err := db.Subscribe(nil, db.NextSeq())
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("order:3"), []byte("e")))
+	events = receiveEvents(sub2, 1)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, []byte("order:3"), events[0].Key)
+	resumeSeq := events[0].Seq
+
+	// 4. 关闭数据库会结束订阅, 重启之后从序列号恢复
+	assert.Nil(t, db.Close())
+	_, ok = <-sub2.Events()
+	assert.False(t, ok)
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	sub3, err := db2.Subscribe(nil, resumeSeq)
+	assert.Nil(t, err)
+	defer sub3.Close()
+	assert.Nil(t, db2.Put([]byte("order:4"), []byte("f")))
+	events = receiveEvents(sub3, 2)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, []byte("order:3"), events[0].Key)
+	assert.Equal(t, []byte("order:4"), events[1].Key)
+}
+
+func TestDB_SubscribeOperand(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-subscribe-operand")
+	opts.DirPath = dir
+	opts.MergeOperator = Int64AddOperator{}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Apply([]byte("counter"), []byte("1")))
+	assert.Nil(t, db.Apply([]byte("counter"), []byte("2")))
+
+	// 落后的订阅者从数据文件中读取历史事件, 操作数投递合并之后的值
+	sub, err := db.Subscribe(nil, 0)
+	assert.Nil(t, err)
+	defer sub.Close()
+	events := receiveEvents(sub, 2)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, []byte("1"), events[0].Value)
+	assert.Equal(t, []byte("3"), events[1].Value)
+}
+
+func TestDB_SubscribeAfterMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-subscribe-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeThreshold = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put([]byte("before-merge"), []byte("v")))
+	oldSeq := db.NextSeq()
+	for n := 0; n < 2; n++ {
+		for i := 0; i < 500; i++ {
+			assert.Nil(t, db.Put(util.GetRandomKey(i), util.GetRandomValue(64)))
+		}
+	}
+	assert.Nil(t, db.Merge())
+	newSeq := db.NextSeq()
+	assert.Nil(t, db.Put([]byte("after-merge"), []byte("v")))
+	assert.Nil(t, db.Close())
+
+	// 1. merge 安装之后, 被重写过的文件中的序列号不能再用来恢复订阅
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = db.Subscribe(nil, oldSeq)
+	assert.Equal(t, ErrSubscribeSeqMerged, err)
+	sub, err := db.Subscribe(nil, newSeq)
+	assert.Nil(t, err)
+	events := receiveEvents(sub, 1)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, []byte("after-merge"), events[0].Key)
+	sub.Close()
+
+	// 2. 增量压缩删除 hint 文件之后, 重启时依然记得被 merge 重写过的范围
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(util.GetRandomKey(i), util.GetRandomValue(64)))
+	}
+	assert.Nil(t, db.Compact(0))
+	_, err = os.Stat(filepath.Join(dir, data.HintFileName))
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	_, err = db.Subscribe(nil, oldSeq)
+	assert.Equal(t, ErrSubscribeSeqMerged, err)
+	assert.Equal(t, 502, len(db.ListKeys()))
+	for i := 0; i < 500; i++ {
+		_, err := db.Get(util.GetRandomKey(i))
+		assert.Nil(t, err)
+	}
+}
+
+func TestDB_SubscribeAfterCompact(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-subscribe-compact")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeThreshold = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("k%05d", i)), util.GetRandomValue(64)))
+	}
+	sub, err := db.Subscribe(nil, 0)
+	assert.Nil(t, err)
+	events := receiveEvents(sub, 200)
+	assert.Equal(t, 200, len(events))
+	sub.Close()
+	last := events[len(events)-1]
+	assert.Equal(t, []byte("k00199"), last.Key)
+
+	// 订阅关闭之后压缩保存的序列号所在的文件
+	for i := 0; i < 150; i++ {
+		assert.Nil(t, db.Delete([]byte(fmt.Sprintf("k%05d", i))))
+	}
+	assert.Nil(t, db.Compact(0))
+	fid, _ := decodeChangeSeq(last.Seq)
+	assert.True(t, db.olderFiles[fid].IsCompacted())
+
+	// 1. 指向被重写过的文件内部的序列号不能再用来恢复订阅, 不会悄悄丢失事件
+	_, err = db.Subscribe(nil, last.Seq+1)
+	assert.Equal(t, ErrSubscribeSeqMerged, err)
+
+	// 2. 从文件的起点和没有被重写过的位置依然可以恢复订阅
+	nextSeq := db.NextSeq()
+	assert.Nil(t, db.Put([]byte("after-compact"), []byte("v")))
+	sub, err = db.Subscribe(nil, nextSeq)
+	assert.Nil(t, err)
+	events = receiveEvents(sub, 1)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, []byte("after-compact"), events[0].Key)
+	sub.Close()
+	sub, err = db.Subscribe(nil, encodeChangeSeq(fid, 0))
+	assert.Nil(t, err)
+	sub.Close()
+
+	// 3. 重启之后从文件头中读取到文件被重写过
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	_, err = db.Subscribe(nil, last.Seq+1)
+	assert.Equal(t, ErrSubscribeSeqMerged, err)
+}
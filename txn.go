@@ -0,0 +1,216 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"sync"
+	"time"
+)
+
+// Txn 乐观读写事务
+// 事务中的写操作先暂存在内存中, 读操作会优先读取事务自己暂存的数据,
+// 并记录下读到的 key 的版本号, 提交时如果这些 key 被其他写入修改过, 返回 ErrTxnConflict
+// 增量压缩只移动数据, 不会修改 key 的版本号, 不会导致冲突
+type Txn struct {
+	db *DB
+
+	// 缓存数据
+	pendingWrites map[string]*data.LogRecord
+
+	// 读集合: 第一次读取时 key 的版本号
+	readSet map[string]uint64
+
+	// 配置项
+	options WriteBatchOptions
+
+	// 事务是否已经结束(提交或者丢弃)
+	closed bool
+
+	lock *sync.Mutex
+}
+
+// NewTxn 创建一个乐观事务
+func (db *DB) NewTxn(options WriteBatchOptions) *Txn {
+	// 和 WriteBatch 一样, B+树索引在事务序列号文件不存在时无法使用事务
+	if db.options.IndexType == BPlusTree && !db.seqNoFileExists && !db.isInitial {
+		panic("cannot user transaction, seq no file not exists")
+	}
+	return &Txn{
+		db:            db,
+		options:       options,
+		pendingWrites: make(map[string]*data.LogRecord),
+		readSet:       make(map[string]uint64),
+		lock:          new(sync.Mutex),
+	}
+}
+
+// Get 读取数据, 优先读取事务中尚未提交的数据
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+	if txn.closed {
+		return nil, ErrTxnClosed
+	}
+
+	// 读取事务自己写入的数据
+	if logRecord, ok := txn.pendingWrites[string(key)]; ok {
+		if logRecord.Type == data.LogRecordDeleted || logRecord.IsExpired(time.Now().UnixNano()) {
+			return nil, ErrKeyNotFound
+		}
+		return logRecord.Value, nil
+	}
+
+	txn.db.lock.RLock()
+	defer txn.db.lock.RUnlock()
+
+	// 记录第一次读取时 key 的版本号, 提交时用来检测冲突
+	if _, ok := txn.readSet[string(key)]; !ok {
+		txn.readSet[string(key)] = txn.db.watchKey(key)
+	}
+	logRecordPos := txn.db.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+	return txn.db.getValueByPosition(logRecordPos)
+}
+
+// Put 写入数据
+func (txn *Txn) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+	if txn.closed {
+		return ErrTxnClosed
+	}
+	txn.pendingWrites[string(key)] = &data.LogRecord{
+		Key:   key,
+		Value: value,
+		Type:  data.LogRecordNormal,
+	}
+	return nil
+}
+
+// Delete 删除数据
+func (txn *Txn) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+	if txn.closed {
+		return ErrTxnClosed
+	}
+	txn.pendingWrites[string(key)] = &data.LogRecord{
+		Key:  key,
+		Type: data.LogRecordDeleted,
+	}
+	return nil
+}
+
+// Commit 提交事务
+// 如果事务读取过的 key 在提交之前被其他写入修改过, 返回 ErrTxnConflict, 事务中的写入全部丢弃
+func (txn *Txn) Commit() error {
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+	if txn.closed {
+		return ErrTxnClosed
+	}
+	txn.closed = true
+	defer txn.db.unwatchKeys(txn.readSet)
+
+	// 检查是否超过最大批量写入数量
+	if uint(len(txn.pendingWrites)) > txn.options.MaxBatchSize {
+		return ErrExceedMaxBatchNum
+	}
+
+	// 加锁保证检测冲突和写入是原子的
+	txn.db.lock.Lock()
+	defer txn.db.lock.Unlock()
+
+	for key, version := range txn.readSet {
+		if txn.db.keyVersion(key) != version {
+			return ErrTxnConflict
+		}
+	}
+
+	// 删除不存在的 key 不需要写入
+	for key, logRecord := range txn.pendingWrites {
+		if logRecord.Type == data.LogRecordDeleted && txn.db.index.Get(logRecord.Key) == nil {
+			delete(txn.pendingWrites, key)
+		}
+	}
+	if len(txn.pendingWrites) == 0 {
+		return nil
+	}
+	return txn.db.commitRecords(txn.pendingWrites, txn.options.SyncWrites)
+}
+
+// Discard 丢弃事务中所有暂存的写入
+func (txn *Txn) Discard() {
+	txn.lock.Lock()
+	defer txn.lock.Unlock()
+	if !txn.closed {
+		txn.db.unwatchKeys(txn.readSet)
+	}
+	txn.closed = true
+	txn.pendingWrites = nil
+	txn.readSet = nil
+}
+
+// txnWatch 被还没有结束的事务读取过的 key
+type txnWatch struct {
+	refs    int    // 读取过这个 key 并且还没有结束的事务数量
+	version uint64 // 被事务读取之后 key 被修改的次数
+}
+
+// watchKey 开始跟踪 key 的修改, 返回 key 当前的版本号
+// 只有被还没有结束的事务读取过的 key 才有版本号, 事务全部结束之后不再占用内存
+// 调用前必须持有 db.lock 的读锁或者写锁
+func (db *DB) watchKey(key []byte) uint64 {
+	db.txnWatchLock.Lock()
+	defer db.txnWatchLock.Unlock()
+	watch, ok := db.txnWatches[string(key)]
+	if !ok {
+		watch = &txnWatch{}
+		db.txnWatches[string(key)] = watch
+	}
+	watch.refs++
+	return watch.version
+}
+
+// unwatchKeys 事务结束, 不再跟踪读集合中的 key
+func (db *DB) unwatchKeys(readSet map[string]uint64) {
+	db.txnWatchLock.Lock()
+	defer db.txnWatchLock.Unlock()
+	for key := range readSet {
+		if watch, ok := db.txnWatches[key]; ok {
+			if watch.refs--; watch.refs <= 0 {
+				delete(db.txnWatches, key)
+			}
+		}
+	}
+}
+
+// keyVersion 返回被事务读取过的 key 当前的版本号
+func (db *DB) keyVersion(key string) uint64 {
+	db.txnWatchLock.Lock()
+	defer db.txnWatchLock.Unlock()
+	if watch, ok := db.txnWatches[key]; ok {
+		return watch.version
+	}
+	return 0
+}
+
+// bumpKeyVersion 写入修改了 key, 读取过这个 key 的事务提交时会发生冲突
+// 调用前必须持有 db.lock
+func (db *DB) bumpKeyVersion(key []byte) {
+	db.txnWatchLock.Lock()
+	defer db.txnWatchLock.Unlock()
+	if watch, ok := db.txnWatches[string(key)]; ok {
+		watch.version++
+	}
+}
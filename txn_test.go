@@ -0,0 +1,142 @@
+package GoKeeper
+
+import (
+	"GoKeeper/util"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_NewTxn(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-txn")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(util.GetRandomKey(1), []byte("v1"))
+	assert.Nil(t, err)
+
+	// 1. 读取自己写入的数据, 提交之前对数据库不可见
+	txn := db.NewTxn(DefaultWriteBatchOptions)
+	val, err := txn.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	assert.Nil(t, txn.Put(util.GetRandomKey(1), []byte("v2")))
+	assert.Nil(t, txn.Put(util.GetRandomKey(2), []byte("v2")))
+	val, err = txn.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	assert.Nil(t, txn.Delete(util.GetRandomKey(2)))
+	_, err = txn.Get(util.GetRandomKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	val, err = db.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+
+	// 2. 没有冲突时正常提交
+	assert.Nil(t, txn.Commit())
+	val, err = db.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	_, err = db.Get(util.GetRandomKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, ErrTxnClosed, txn.Put(util.GetRandomKey(3), []byte("v")))
+	assert.Equal(t, ErrTxnClosed, txn.Commit())
+
+	// 3. 读过的 key 被其他写入修改, 提交失败
+	txn2 := db.NewTxn(DefaultWriteBatchOptions)
+	_, err = txn2.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+	assert.Nil(t, txn2.Put(util.GetRandomKey(3), []byte("v3")))
+	assert.Nil(t, db.Put(util.GetRandomKey(1), []byte("other")))
+	assert.ErrorIs(t, txn2.Commit(), ErrTxnConflict)
+	_, err = db.Get(util.GetRandomKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 4. 读取时不存在的 key 被其他写入创建, 提交失败
+	txn3 := db.NewTxn(DefaultWriteBatchOptions)
+	_, err = txn3.Get(util.GetRandomKey(4))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, txn3.Put(util.GetRandomKey(4), []byte("txn")))
+	assert.Nil(t, db.Put(util.GetRandomKey(4), []byte("other")))
+	assert.ErrorIs(t, txn3.Commit(), ErrTxnConflict)
+	val, err = db.Get(util.GetRandomKey(4))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("other"), val)
+
+	// 5. 只写没有读过的 key 不会冲突
+	txn4 := db.NewTxn(DefaultWriteBatchOptions)
+	assert.Nil(t, txn4.Put(util.GetRandomKey(5), []byte("v5")))
+	assert.Nil(t, db.Put(util.GetRandomKey(5), []byte("other")))
+	assert.Nil(t, txn4.Commit())
+	val, err = db.Get(util.GetRandomKey(5))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v5"), val)
+
+	// 6. Discard 之后写入不会生效
+	txn5 := db.NewTxn(DefaultWriteBatchOptions)
+	assert.Nil(t, txn5.Put(util.GetRandomKey(6), []byte("v6")))
+	txn5.Discard()
+	assert.Equal(t, ErrTxnClosed, txn5.Commit())
+	_, err = db.Get(util.GetRandomKey(6))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 7. 重启之后从数据文件中恢复事务写入的数据
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	val, err = db2.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("other"), val)
+	val, err = db2.Get(util.GetRandomKey(5))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v5"), val)
+	_, err = db2.Get(util.GetRandomKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, uint64(2), db2.transactionSeq)
+}
+
+func TestTxn_CompactNoConflict(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-txn-compact")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeThreshold = 0.1
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	for n := 0; n < 2; n++ {
+		for i := 0; i < 500; i++ {
+			if n == 0 || i%2 == 0 {
+				assert.Nil(t, db.Put(util.GetRandomKey(i), util.GetRandomValue(64)))
+			}
+		}
+	}
+
+	// 读取之后 key 被增量压缩移动到了新的位置, 没有被修改, 提交不会冲突
+	txn := db.NewTxn(DefaultWriteBatchOptions)
+	val, err := txn.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+	pos := db.index.Get(util.GetRandomKey(1))
+	assert.Nil(t, db.Compact(0))
+	moved := db.index.Get(util.GetRandomKey(1))
+	assert.Equal(t, pos.Fid, moved.Fid)
+	assert.NotEqual(t, pos.Offset, moved.Offset)
+	assert.Nil(t, txn.Put(util.GetRandomKey(1), append(val, '!')))
+	assert.Nil(t, txn.Commit())
+	val2, err := db.Get(util.GetRandomKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, append(val, '!'), val2)
+
+	// 事务结束之后不再跟踪读取过的 key
+	txn2 := db.NewTxn(DefaultWriteBatchOptions)
+	_, err = txn2.Get(util.GetRandomKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(db.txnWatches))
+	txn2.Discard()
+	assert.Equal(t, 0, len(db.txnWatches))
+}
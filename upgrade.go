@@ -0,0 +1,73 @@
+package GoKeeper
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// optionsFingerprint 计算影响数据格式的配置项的指纹, 写入每个新文件的文件头中
+// 排查问题时可以知道文件是在什么配置下创建的
+func optionsFingerprint(options Options) uint64 {
+	buf := make([]byte, 0, 16)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(options.DataFileSize))
+	buf = append(buf, byte(options.IndexType), byte(options.Compression))
+	if options.EncryptionKey != nil || options.KeyProvider != nil {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(buf)
+	return h.Sum64()
+}
+
+// Upgrade 把旧版本的数据目录升级为当前的文件格式, 调用时数据目录不能被其他进程打开
+// 旧版本的文件没有文件头, Open 可以继续读取, 但是之后记录的编码发生变化时无法区分文件的版本
+// 流程:
+//  1. 打开数据库, 如果所有的数据文件都有文件头, 不需要升级
+//  2. 进行一次完整的 merge, 所有的有效数据重写到新格式的数据文件中
+//  3. 重新打开数据库, 安装 merge 的结果, 旧格式的数据文件被删除
+func Upgrade(options Options) error {
+	// 所有的有效数据都需要重写, 不能被压缩过滤器删除
+	options.MergeThreshold = 0
+	options.MergeCheckInterval = 0
+	options.CompactionFilter = nil
+
+	db, err := Open(options)
+	if err != nil {
+		return err
+	}
+	if !db.hasLegacyFiles() {
+		return db.Close()
+	}
+	if err = db.Merge(); err != nil && err != ErrMergeNotExceedThreshold {
+		_ = db.Close()
+		return err
+	}
+	if err = db.Close(); err != nil {
+		return err
+	}
+
+	// 重新打开时安装 merge 的结果
+	db, err = Open(options)
+	if err != nil {
+		return err
+	}
+	if db.hasLegacyFiles() {
+		_ = db.Close()
+		return ErrDataDirectoryCorrupted
+	}
+	return db.Close()
+}
+
+// hasLegacyFiles 判断是否还有旧版本没有文件头的数据文件
+func (db *DB) hasLegacyFiles() bool {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	for _, dataFile := range db.olderFiles {
+		if dataFile.Header == nil && dataFile.WriteOff > 0 {
+			return true
+		}
+	}
+	return db.activeFile != nil && db.activeFile.Header == nil && db.activeFile.WriteOff > 0
+}
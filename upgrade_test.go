@@ -0,0 +1,121 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"GoKeeper/util"
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeLegacyDataFile 按照旧版本没有文件头的格式写入数据文件
+func writeLegacyDataFile(t *testing.T, dirPath string, fid uint32, records []*data.LogRecord) {
+	var buf []byte
+	for _, record := range records {
+		encodeRecord, _ := data.EncodeLogRecord(record)
+		buf = append(buf, encodeRecord...)
+	}
+	assert.Nil(t, os.WriteFile(data.GetDataFileName(dirPath, fid), buf, 0644))
+}
+
+// assertFileHeaders 检查数据目录中的文件都有当前版本的文件头
+func assertFileHeaders(t *testing.T, dirPath string) {
+	entries, err := os.ReadDir(dirPath)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, data.DataFileNameSuffix) && name != data.HintFileName &&
+			name != data.MergeFinishedFileName && name != data.SeqNoFileName {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dirPath, name))
+		assert.Nil(t, err)
+		if len(content) == 0 {
+			continue
+		}
+		assert.True(t, bytes.HasPrefix(content, []byte("GKDB")), name)
+		assert.Equal(t, data.FileFormatVersion, binary.LittleEndian.Uint16(content[4:]), name)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	for _, indexType := range []IndexType{Btree, BPlusTree} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "goKeeper-upgrade")
+		opts.DirPath = dir
+		opts.IndexType = indexType
+
+		// 1. 旧版本的数据目录
+		expected := make(map[int][]byte)
+		for fid := uint32(0); fid < 3; fid++ {
+			var records []*data.LogRecord
+			for i := 0; i < 100; i++ {
+				key := int(fid)*50 + i
+				expected[key] = util.GetRandomValue(32)
+				records = append(records, &data.LogRecord{
+					Key:   logRecordKeyWithSeq(util.GetRandomKey(key), nonTransactionKey),
+					Value: expected[key],
+				})
+			}
+			records = append(records, &data.LogRecord{
+				Key:  logRecordKeyWithSeq(util.GetRandomKey(int(fid)*50), nonTransactionKey),
+				Type: data.LogRecordDeleted,
+			})
+			expected[int(fid)*50] = nil
+			writeLegacyDataFile(t, dir, fid, records)
+		}
+
+		// 2. 旧版本的数据文件可以直接读取, 新写入的文件有文件头
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		checkValues(t, db, expected)
+		expected[1000] = util.GetRandomValue(32)
+		assert.Nil(t, db.Put(util.GetRandomKey(1000), expected[1000]))
+		assert.True(t, db.hasLegacyFiles())
+		assert.Nil(t, db.Close())
+
+		// 3. 升级之后所有的文件都是新的格式, 数据不变
+		assert.Nil(t, Upgrade(opts))
+		assertFileHeaders(t, dir)
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.False(t, db.hasLegacyFiles())
+		checkValues(t, db, expected)
+		assert.Nil(t, db.Close())
+
+		// 已经是新的格式时不需要升级
+		assert.Nil(t, Upgrade(opts))
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		checkValues(t, db, expected)
+		destroyDB(db)
+	}
+}
+
+func TestOpen_UnsupportedFileVersion(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "goKeeper-version")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("name"), []byte("Sakura")))
+	assert.Nil(t, db.Close())
+
+	// 修改文件头中的版本号, 模拟更新版本写入的文件
+	fileName := data.GetDataFileName(dir, 0)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	binary.LittleEndian.PutUint16(content[4:], data.FileFormatVersion+1)
+	binary.LittleEndian.PutUint32(content[28:], crc32.ChecksumIEEE(content[:28]))
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+
+	_, err = Open(opts)
+	assert.ErrorIs(t, err, ErrUnsupportedFileVersion)
+	assert.Contains(t, err.Error(), fileName)
+}
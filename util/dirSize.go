@@ -6,7 +6,6 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
-	"syscall"
 )
 
 // DirSize 获取目录大小
@@ -24,40 +23,32 @@ func DirSize(dirPath string) (int64, error) {
 	return size, err
 }
 
-// AvailableDiskSize 获取磁盘有效空间
-func AvailableDiskSize() (uint64, error) {
-	_, err := syscall.Getwd()
-	if err != nil {
-		return 0, err
-	}
-	//var stat syscall.Statfs_t
-	//
-	//if err = syscall.Statfs(wd, &stat); err != nil {
-	//	return 0, err
-	//}
-
-	//return stat.Bavail * uint64(stat.Bsize), nil
-	return uint64(10000000), nil
-}
+// copyWorkers 拷贝目录时最多同时拷贝的文件数量
+const copyWorkers = 8
 
 // CopyDir 拷贝数据目录
 // src 数据目录
 // dst 目标目录
 // exclude 排除文件
+// 只拷贝目录下的文件, 不拷贝子目录, 任何一个文件拷贝失败时返回错误
 func CopyDir(src, dst string, excludes []string) error {
 	if _, err := os.Stat(dst); os.IsNotExist(err) {
 		if err = os.MkdirAll(dst, os.ModePerm); err != nil {
 			return err
 		}
 	}
-	task := make(chan string, 100)
-
-	wg := &sync.WaitGroup{}
 
+	var fileNames []string
 	if err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 		if path == src {
 			return nil
 		}
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
 		fileName := filepath.Base(path)
 
 		// 排除文件
@@ -66,54 +57,60 @@ func CopyDir(src, dst string, excludes []string) error {
 				return nil
 			}
 		}
-
-		// 向队列中添加文件名
-		task <- fileName
+		fileNames = append(fileNames, fileName)
 		return nil
 	}); err != nil {
 		return err
 	}
 
-	for i := 0; i < len(task); i++ {
+	// 所有文件名放入队列之后关闭队列, worker 取完队列中的文件名之后退出
+	task := make(chan string, len(fileNames))
+	for _, fileName := range fileNames {
+		task <- fileName
+	}
+	close(task)
+
+	wg := &sync.WaitGroup{}
+	errs := make(chan error, copyWorkers)
+	for i := 0; i < min(copyWorkers, len(fileNames)); i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			err := Worker(src, dst, task)
-			if err != nil {
-				return
+			if err := Worker(src, dst, task); err != nil {
+				errs <- err
 			}
 		}()
 	}
-
 	wg.Wait()
-	return nil
+	close(errs)
+	return <-errs
 }
 
+// Worker 从队列中取出文件名并拷贝文件, 直到队列关闭或者拷贝失败
 func Worker(src, dst string, task chan string) error {
-	fileName := <-task
+	for fileName := range task {
+		if err := copyFile(filepath.Join(src, fileName), filepath.Join(dst, fileName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	srcFile, err := os.Open(filepath.Join(src, fileName))
+// copyFile 拷贝一个文件
+func copyFile(src, dst string) error {
+	srcFile, err := os.Open(src)
 	if err != nil {
 		return err
 	}
-	defer func(srcFile *os.File) {
-		err := srcFile.Close()
-		if err != nil {
-			return
-		}
-	}(srcFile)
+	defer srcFile.Close()
 
-	dstFile, err := os.Create(filepath.Join(dst, fileName))
+	dstFile, err := os.Create(dst)
 	if err != nil {
 		return err
 	}
-	defer func(dstFile *os.File) {
-		err := dstFile.Close()
-		if err != nil {
-			return
-		}
-	}(dstFile)
-
-	_, err = io.Copy(dstFile, srcFile)
-	return err
+	if _, err = io.Copy(dstFile, srcFile); err != nil {
+		_ = dstFile.Close()
+		return err
+	}
+	return dstFile.Close()
 }
@@ -1,6 +1,12 @@
 package util
 
-import "testing"
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
 
 func TestDirSize(t *testing.T) {
 	size, err := DirSize("../tmp")
@@ -9,3 +15,35 @@ func TestDirSize(t *testing.T) {
 	}
 	t.Log(size)
 }
+
+func TestCopyDir(t *testing.T) {
+	src, _ := os.MkdirTemp("", "goKeeper-copy-src")
+	defer os.RemoveAll(src)
+	dst, _ := os.MkdirTemp("", "goKeeper-copy-dst")
+	defer os.RemoveAll(dst)
+
+	// 文件数量超过 worker 的数量, 排除的文件和子目录不会被拷贝
+	for i := 0; i < 300; i++ {
+		name := filepath.Join(src, fmt.Sprintf("%09d.data", i))
+		assert.Nil(t, os.WriteFile(name, GetRandomValue(i), 0644))
+	}
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "flock"), nil, 0644))
+	assert.Nil(t, os.Mkdir(filepath.Join(src, "sub"), os.ModePerm))
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "sub", "file"), nil, 0644))
+
+	assert.Nil(t, CopyDir(src, dst, []string{"flock"}))
+	entries, err := os.ReadDir(dst)
+	assert.Nil(t, err)
+	assert.Equal(t, 300, len(entries))
+	for i := 0; i < 300; i++ {
+		name := fmt.Sprintf("%09d.data", i)
+		expected, err := os.ReadFile(filepath.Join(src, name))
+		assert.Nil(t, err)
+		actual, err := os.ReadFile(filepath.Join(dst, name))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual)
+	}
+
+	// 源目录不存在时返回错误
+	assert.NotNil(t, CopyDir(filepath.Join(src, "missing"), dst, nil))
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package util
+
+import "syscall"
+
+// AvailableDiskSize 获取 dirPath 所在磁盘的可用空间
+func AvailableDiskSize(dirPath string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dirPath, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+package util
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// AvailableDiskSize 获取 dirPath 所在磁盘的可用空间
+func AvailableDiskSize(dirPath string) (uint64, error) {
+	path, err := syscall.UTF16PtrFromString(dirPath)
+	if err != nil {
+		return 0, err
+	}
+	var freeBytesAvailable uint64
+	ret, _, err := procGetDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(path)),
+		uintptr(unsafe.Pointer(&freeBytesAvailable)), 0, 0)
+	if ret == 0 {
+		return 0, err
+	}
+	return freeBytesAvailable, nil
+}
@@ -0,0 +1,354 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"GoKeeper/fio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 检查报告中问题的严重程度
+const (
+	VerifyError   = "error"   // 数据已经损坏, 启动时会失败或者丢失数据
+	VerifyWarning = "warning" // 不影响使用, 例如写入过程中崩溃留下的没有提交的事务
+)
+
+// VerifyReport 数据目录的检查报告, 可以直接编码成 JSON
+type VerifyReport struct {
+	DirPath      string         `json:"dir_path"`
+	OK           bool           `json:"ok"` // 没有 error 级别的问题
+	Files        []*VerifyFile  `json:"files"`
+	DanglingTxns []DanglingTxn  `json:"dangling_transactions"`
+	Issues       []*VerifyIssue `json:"issues"`
+}
+
+// VerifyFile 一个文件的检查结果
+type VerifyFile struct {
+	Name           string `json:"name"`
+	Kind           string `json:"kind"`    // data, hint, merge-finished, seq-no
+	Version        uint16 `json:"version"` // 文件格式版本, 0 表示旧版本没有文件头的文件
+	Size           int64  `json:"size"`
+	Records        int    `json:"records"`         // 有效的记录数
+	CorruptRecords int    `json:"corrupt_records"` // 损坏的记录数
+	ValidSize      int64  `json:"valid_size"`      // 最后一条有效记录之后的位置
+}
+
+// VerifyIssue 检查发现的问题
+type VerifyIssue struct {
+	Severity string `json:"severity"`
+	File     string `json:"file"`
+	Offset   int64  `json:"offset"`
+	Kind     string `json:"kind"`
+	Message  string `json:"message"`
+}
+
+// DanglingTxn 没有完成标记的事务
+type DanglingTxn struct {
+	SeqNo   uint64 `json:"seq_no"`
+	Records int    `json:"records"`
+	File    string `json:"file"` // 第一条记录所在的文件
+	Offset  int64  `json:"offset"`
+}
+
+// VerifyOptions 检查数据目录的配置项
+type VerifyOptions struct {
+	// 加密的数据目录需要提供密钥才能检查 hint 文件和事务, 和 Options 中的含义相同
+	EncryptionKey []byte
+	KeyProvider   KeyProvider
+}
+
+// Verify 离线检查数据目录是否完整, 不会修改任何文件, 检查时数据目录最好没有被打开
+// 检查每个数据文件, hint 文件, merge 完成标识文件和事务序列号文件中每一条记录的 crc,
+// hint 文件中的索引是否指向有效的记录, 以及没有完成标记的事务
+func Verify(dirPath string) (*VerifyReport, error) {
+	return VerifyWithOptions(dirPath, VerifyOptions{})
+}
+
+// VerifyWithOptions 按照配置检查数据目录
+func VerifyWithOptions(dirPath string, options VerifyOptions) (*VerifyReport, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	keyProvider := options.KeyProvider
+	if options.EncryptionKey != nil {
+		keyProvider = &KeyRing{Keys: map[uint32][]byte{0: options.EncryptionKey}}
+	}
+	v := &verifier{
+		report:    &VerifyReport{DirPath: dirPath},
+		dirPath:   dirPath,
+		cipher:    data.NewCipher(keyProvider),
+		dataFiles: make(map[uint32]*data.DataFile),
+		txns:      make(map[uint64]*DanglingTxn),
+	}
+	defer v.close()
+
+	var fileIds []int
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.DataFileNameSuffix))
+		if err != nil {
+			v.addIssue(VerifyError, entry.Name(), 0, "invalid-file-name", "the data file name is not a file id")
+			continue
+		}
+		fileIds = append(fileIds, fileId)
+	}
+	sort.Ints(fileIds)
+
+	// 按照写入的顺序检查数据文件, 事务的完成标记在事务的记录之后
+	for _, fid := range fileIds {
+		v.verifyDataFile(uint32(fid))
+	}
+	for _, txn := range v.txns {
+		v.report.DanglingTxns = append(v.report.DanglingTxns, *txn)
+		v.addIssue(VerifyWarning, txn.File, txn.Offset, "dangling-transaction",
+			fmt.Sprintf("transaction %d has %d records but no finished record", txn.SeqNo, txn.Records))
+	}
+	sort.Slice(v.report.DanglingTxns, func(i, j int) bool {
+		return v.report.DanglingTxns[i].SeqNo < v.report.DanglingTxns[j].SeqNo
+	})
+
+	v.verifyMergeFinishedFile()
+	v.verifyHintFile()
+	v.verifySeqNoFile()
+
+	v.report.OK = true
+	for _, issue := range v.report.Issues {
+		if issue.Severity == VerifyError {
+			v.report.OK = false
+		}
+	}
+	return v.report, nil
+}
+
+type verifier struct {
+	report    *VerifyReport
+	dirPath   string
+	cipher    *data.Cipher
+	dataFiles map[uint32]*data.DataFile // 通过检查的数据文件, 用来检查 hint 文件中的索引
+	txns      map[uint64]*DanglingTxn   // 还没有读取到完成标记的事务
+}
+
+func (v *verifier) close() {
+	for _, dataFile := range v.dataFiles {
+		_ = dataFile.Close()
+	}
+}
+
+func (v *verifier) addIssue(severity, file string, offset int64, kind, message string) {
+	v.report.Issues = append(v.report.Issues, &VerifyIssue{
+		Severity: severity,
+		File:     file,
+		Offset:   offset,
+		Kind:     kind,
+		Message:  message,
+	})
+}
+
+// openFile 只读打开文件, 检查文件头
+func (v *verifier) openFile(name, kind string, fid uint32) (*data.DataFile, *VerifyFile) {
+	fileName := filepath.Join(v.dirPath, name)
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			v.addIssue(VerifyError, name, 0, "io-error", err.Error())
+		}
+		return nil, nil
+	}
+	file := &VerifyFile{Name: name, Kind: kind, Size: stat.Size()}
+	v.report.Files = append(v.report.Files, file)
+
+	ioManager, err := fio.NewIOManager(fileName, fio.MemoryMapFIO)
+	if err != nil {
+		v.addIssue(VerifyError, name, 0, "io-error", err.Error())
+		return nil, file
+	}
+	dataFile := &data.DataFile{FileID: fid, IoManager: ioManager, Cipher: v.cipher}
+	header := make([]byte, min(stat.Size(), data.FileHeaderSize))
+	if _, err = ioManager.Read(header, 0); err != nil && err != io.EOF {
+		v.addIssue(VerifyError, name, 0, "io-error", err.Error())
+		_ = ioManager.Close()
+		return nil, file
+	}
+	if data.IsTornHeader(header) {
+		v.addIssue(VerifyError, name, 0, "invalid-header", "the file only has a torn header")
+		_ = ioManager.Close()
+		return nil, file
+	}
+	if dataFile.Header, err = data.DecodeFileHeader(header); err != nil {
+		kind := "invalid-header"
+		if errors.Is(err, data.ErrUnsupportedFileVersion) {
+			kind = "unsupported-version"
+		}
+		v.addIssue(VerifyError, name, 0, kind, err.Error())
+		_ = ioManager.Close()
+		return nil, file
+	}
+	if dataFile.Header != nil {
+		file.Version = dataFile.Header.Version
+		file.ValidSize = data.FileHeaderSize
+	}
+	return dataFile, file
+}
+
+// scanFile 依次读取文件中的每一条记录, 遇到损坏的记录时尽量跳过继续检查
+func (v *verifier) scanFile(dataFile *data.DataFile, file *VerifyFile, fn func(record *data.LogRecord, offset int64)) {
+	offset := dataFile.HeaderSize()
+	keyRequired := false
+	for offset < file.Size {
+		record, size, err := dataFile.ReadLogRecord(offset)
+		switch {
+		case err == nil:
+			file.Records++
+			file.ValidSize = offset + size
+			fn(record, offset)
+		case errors.Is(err, data.ErrEncryptionKeyRequired):
+			// crc 校验已经通过, 只是无法解密
+			file.Records++
+			file.ValidSize = offset + size
+			keyRequired = true
+		case errors.Is(err, data.ErrInvalidCRC) && size > 0:
+			file.CorruptRecords++
+			v.addIssue(VerifyError, file.Name, offset, "invalid-crc", "the record crc does not match")
+		case errors.Is(err, io.EOF) && bytes.Count(v.readRest(dataFile, offset, file.Size), []byte{0}) == int(file.Size-offset):
+			v.addIssue(VerifyWarning, file.Name, offset, "zero-tail", fmt.Sprintf("%d zero bytes at the end of the file", file.Size-offset))
+			return
+		case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+			file.CorruptRecords++
+			v.addIssue(VerifyError, file.Name, offset, "truncated",
+				fmt.Sprintf("the record is truncated, %d bytes after the last valid record", file.Size-offset))
+			return
+		default:
+			file.CorruptRecords++
+			v.addIssue(VerifyError, file.Name, offset, "invalid-record", err.Error())
+			if size == 0 {
+				return
+			}
+		}
+		offset += size
+	}
+	if keyRequired {
+		v.addIssue(VerifyWarning, file.Name, 0, "key-required", "some records are encrypted, provide the key to verify their content")
+	}
+}
+
+func (v *verifier) readRest(dataFile *data.DataFile, offset, size int64) []byte {
+	buf := make([]byte, size-offset)
+	_, _ = dataFile.IoManager.Read(buf, offset)
+	return buf
+}
+
+func (v *verifier) verifyDataFile(fid uint32) {
+	name := filepath.Base(data.GetDataFileName(v.dirPath, fid))
+	dataFile, file := v.openFile(name, "data", fid)
+	if dataFile == nil {
+		return
+	}
+	v.dataFiles[fid] = dataFile
+	v.scanFile(dataFile, file, func(record *data.LogRecord, offset int64) {
+		seqNo, n := binary.Uvarint(record.Key)
+		if n <= 0 {
+			v.addIssue(VerifyError, name, offset, "invalid-key", "the record key has no valid sequence number")
+			return
+		}
+		if seqNo == nonTransactionKey {
+			return
+		}
+		if record.Type == data.LogRecordFinished {
+			delete(v.txns, seqNo)
+			return
+		}
+		txn, ok := v.txns[seqNo]
+		if !ok {
+			txn = &DanglingTxn{SeqNo: seqNo, File: name, Offset: offset}
+			v.txns[seqNo] = txn
+		}
+		txn.Records++
+	})
+}
+
+func (v *verifier) verifyHintFile() {
+	hintFile, file := v.openFile(data.HintFileName, "hint", 0)
+	if hintFile == nil {
+		return
+	}
+	defer hintFile.Close()
+	if _, err := os.Stat(filepath.Join(v.dirPath, data.MergeFinishedFileName)); os.IsNotExist(err) {
+		v.addIssue(VerifyWarning, file.Name, 0, "unused-hint", "the hint file has no merge finished file and is ignored")
+	}
+	v.scanFile(hintFile, file, func(record *data.LogRecord, offset int64) {
+		pos := data.DecodeLogRecordPos(record.Value)
+		dataFile := v.dataFiles[pos.Fid]
+		if dataFile == nil {
+			v.addIssue(VerifyError, file.Name, offset, "dangling-hint",
+				fmt.Sprintf("key %q points to missing data file %d", record.Key, pos.Fid))
+			return
+		}
+		target, size, err := dataFile.ReadLogRecord(pos.Offset)
+		if errors.Is(err, data.ErrEncryptionKeyRequired) {
+			return
+		}
+		if err != nil || (pos.Size > 0 && uint32(size) != pos.Size) {
+			v.addIssue(VerifyError, file.Name, offset, "dangling-hint",
+				fmt.Sprintf("key %q points to an invalid record in data file %d at offset %d", record.Key, pos.Fid, pos.Offset))
+			return
+		}
+		if realKey, _ := parseLogRecordKey(target.Key); !bytes.Equal(realKey, record.Key) {
+			v.addIssue(VerifyError, file.Name, offset, "dangling-hint",
+				fmt.Sprintf("key %q points to the record of another key in data file %d at offset %d", record.Key, pos.Fid, pos.Offset))
+		}
+	})
+}
+
+func (v *verifier) verifyMergeFinishedFile() {
+	finishedFile, file := v.openFile(data.MergeFinishedFileName, "merge-finished", 0)
+	if finishedFile == nil {
+		return
+	}
+	defer finishedFile.Close()
+	first := true
+	v.scanFile(finishedFile, file, func(record *data.LogRecord, offset int64) {
+		if !first {
+			return
+		}
+		first = false
+		nonMergeFileId, err := strconv.Atoi(string(record.Value))
+		if string(record.Key) != mergerFinishedKey || err != nil {
+			v.addIssue(VerifyError, file.Name, offset, "invalid-merge-finished", "the first record is not a merge finished record")
+			return
+		}
+		for fid := range v.dataFiles {
+			if fid >= uint32(nonMergeFileId) {
+				return
+			}
+		}
+		v.addIssue(VerifyWarning, file.Name, offset, "invalid-merge-finished",
+			fmt.Sprintf("no data file after the merged file id %d", nonMergeFileId))
+	})
+	if file.Records == 0 && file.CorruptRecords == 0 {
+		v.addIssue(VerifyError, file.Name, 0, "invalid-merge-finished", "the merge finished file has no record")
+	}
+}
+
+func (v *verifier) verifySeqNoFile() {
+	seqNoFile, file := v.openFile(data.SeqNoFileName, "seq-no", 0)
+	if seqNoFile == nil {
+		return
+	}
+	defer seqNoFile.Close()
+	v.scanFile(seqNoFile, file, func(record *data.LogRecord, offset int64) {
+		if _, err := strconv.ParseUint(string(record.Value), 10, 64); string(record.Key) != seqNoKey || err != nil {
+			v.addIssue(VerifyError, file.Name, offset, "invalid-seq-no", "the record is not a sequence number record")
+		}
+	})
+}
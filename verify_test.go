@@ -0,0 +1,129 @@
+package GoKeeper
+
+import (
+	"GoKeeper/data"
+	"GoKeeper/util"
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// issueKinds 返回报告中所有问题的类型
+func issueKinds(report *VerifyReport) []string {
+	var kinds []string
+	for _, issue := range report.Issues {
+		kinds = append(kinds, issue.Kind)
+	}
+	return kinds
+}
+
+// prepareVerifyDB 写入数据, 包括事务和 merge 生成的 hint 文件, 关闭之后返回数据目录
+func prepareVerifyDB(t *testing.T, opts Options) string {
+	dir, _ := os.MkdirTemp("", "goKeeper-verify")
+	opts.DirPath = dir
+	opts.DataFileSize = 8 * 1024
+	opts.MergeThreshold = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, db.Put(util.GetRandomKey(i), util.GetRandomValue(32)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(util.GetRandomKey(1000), util.GetRandomValue(32)))
+	assert.Nil(t, wb.Delete(util.GetRandomKey(1)))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.Merge())
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(util.GetRandomKey(i), util.GetRandomValue(32)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 重新打开安装 merge 的结果
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(util.GetRandomKey(2000), util.GetRandomValue(32)))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.Close())
+	return dir
+}
+
+func TestVerify(t *testing.T) {
+	dir := prepareVerifyDB(t, DefaultOptions)
+	defer os.RemoveAll(dir)
+
+	// 1. 完整的数据目录
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK)
+	assert.Empty(t, report.Issues)
+	kinds := make(map[string]int)
+	for _, file := range report.Files {
+		kinds[file.Kind]++
+		assert.Equal(t, data.FileFormatVersion, file.Version, file.Name)
+		assert.Equal(t, file.Size, file.ValidSize, file.Name)
+		assert.Zero(t, file.CorruptRecords, file.Name)
+	}
+	assert.Greater(t, kinds["data"], 1)
+	assert.Equal(t, 1, kinds["hint"])
+	assert.Equal(t, 1, kinds["merge-finished"])
+	assert.Equal(t, 1, kinds["seq-no"])
+
+	// 报告可以编码成 JSON
+	buf, err := json.Marshal(report)
+	assert.Nil(t, err)
+	assert.Contains(t, string(buf), `"kind":"merge-finished"`)
+
+	// 2. 没有完成标记的事务只是警告
+	lastFile := report.Files[kinds["data"]-1].Name
+	record, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq([]byte("txn-key"), 99),
+		Value: []byte("value"),
+	})
+	appendToFile(t, filepath.Join(dir, lastFile), record)
+	report, err = Verify(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK)
+	assert.Equal(t, []DanglingTxn{{SeqNo: 99, Records: 1, File: lastFile, Offset: report.Files[kinds["data"]-1].Size - int64(len(record))}}, report.DanglingTxns)
+
+	// 3. 末尾不完整的记录
+	appendToFile(t, filepath.Join(dir, lastFile), record[:len(record)-3])
+	report, err = Verify(dir)
+	assert.Nil(t, err)
+	assert.False(t, report.OK)
+	assert.Contains(t, issueKinds(report), "truncated")
+
+	// 4. 损坏的记录, 以及指向损坏的记录的 hint 索引
+	firstFile := filepath.Join(dir, data.GetDataFileName("", 0))
+	content, err := os.ReadFile(firstFile)
+	assert.Nil(t, err)
+	content[data.FileHeaderSize+10]++
+	assert.Nil(t, os.WriteFile(firstFile, content, 0644))
+	report, err = Verify(dir)
+	assert.Nil(t, err)
+	assert.False(t, report.OK)
+	assert.Contains(t, issueKinds(report), "invalid-crc")
+	assert.Contains(t, issueKinds(report), "dangling-hint")
+	assert.Equal(t, 1, report.Files[0].CorruptRecords)
+}
+
+func TestVerify_Encrypted(t *testing.T) {
+	opts := DefaultOptions
+	opts.EncryptionKey = bytes.Repeat([]byte("k"), 16)
+	dir := prepareVerifyDB(t, opts)
+	defer os.RemoveAll(dir)
+
+	// 没有密钥时只能检查 crc
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK)
+	assert.Contains(t, issueKinds(report), "key-required")
+
+	report, err = VerifyWithOptions(dir, VerifyOptions{EncryptionKey: opts.EncryptionKey})
+	assert.Nil(t, err)
+	assert.True(t, report.OK)
+	assert.Empty(t, report.Issues)
+}